@@ -0,0 +1,10 @@
+// Package api holds the schemas of the records msgproc publishes, for teams
+// that generate consumers from them.
+package api
+
+import _ "embed"
+
+//go:generate protoc --go_out=. --go_opt=module=msgproc/api envelope.proto
+
+//go:embed envelope.avsc
+var EnvelopeAvroSchema string
@@ -5,24 +5,38 @@ import (
 	"fmt"
 	"log"
 	"msgproc/internal/config"
+	"net/url"
+	"path/filepath"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
 func main() {
 	cfg := config.MustLoad()
 
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable&x-migrations-table=%s",
-		cfg.Postgres.User,
-		cfg.Postgres.Password,
-		cfg.Postgres.Host,
-		cfg.Postgres.Database,
-		cfg.Migrator.MigrationsTable,
-	)
+	var dbURL string
+	switch cfg.Storage.Driver {
+	case "postgres":
+		dbURL = fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable&x-migrations-table=%s",
+			cfg.Postgres.User,
+			cfg.Postgres.Password,
+			cfg.Postgres.Host,
+			cfg.Postgres.Database,
+			cfg.Migrator.MigrationsTable,
+		)
+	case "sqlite":
+		dbURL = fmt.Sprintf("sqlite://%s?_pragma=foreign_keys(1)&x-migrations-table=%s",
+			cfg.SQLite.Path,
+			url.QueryEscape(cfg.Migrator.MigrationsTable),
+		)
+	default:
+		log.Fatalf("Storage driver %q has no migrations\n", cfg.Storage.Driver)
+	}
 
-	migrationsPath := fmt.Sprintf("file://%s", cfg.Migrator.MigrationsPath)
+	migrationsPath := fmt.Sprintf("file://%s", filepath.Join(cfg.Migrator.MigrationsPath, cfg.Storage.Driver))
 
 	m, err := migrate.New(
 		migrationsPath,
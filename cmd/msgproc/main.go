@@ -3,21 +3,44 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"log/slog"
 	"msgproc/internal/config"
+	eventStream "msgproc/internal/http-server/handlers/events"
+	"msgproc/internal/http-server/handlers/health"
+	"msgproc/internal/http-server/handlers/msg/batch"
+	"msgproc/internal/http-server/handlers/msg/get"
+	"msgproc/internal/http-server/handlers/msg/history"
+	"msgproc/internal/http-server/handlers/msg/list"
 	"msgproc/internal/http-server/handlers/msg/process"
 	"msgproc/internal/http-server/handlers/msg/stat"
+	"msgproc/internal/http-server/handlers/msg/webhooks"
 	mvLog "msgproc/internal/http-server/middleware/logger"
+	mwMetrics "msgproc/internal/http-server/middleware/metrics"
+	"msgproc/internal/lib/backoff"
 	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/lib/metrics"
+	"msgproc/internal/lib/migrations"
+	"msgproc/internal/lib/schemaregistry"
+	"msgproc/internal/services/events"
 	"msgproc/internal/services/kafka"
 	"msgproc/internal/services/msgproc"
 	"msgproc/internal/services/msgstat"
+	"msgproc/internal/services/outbox"
+	"msgproc/internal/services/pipeline"
+	"msgproc/internal/services/webhook"
+	"msgproc/internal/storage"
+	"msgproc/internal/storage/instrumented"
+	"msgproc/internal/storage/memory"
 	"msgproc/internal/storage/postgres"
+	"msgproc/internal/storage/sqlite"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 )
@@ -28,32 +51,73 @@ const (
 	envProd  = "prod"
 )
 
+const (
+	storageDriverPostgres = "postgres"
+	storageDriverSQLite   = "sqlite"
+	storageDriverMemory   = "memory"
+)
+
 func main() {
 	cfg := config.MustLoad()
 	log := setupLogger(cfg.Env)
 	log.Info("Starting msgproc...")
 
-	storage, err := postgres.NewStorage(
-		cfg.Postgres.User,
-		cfg.Postgres.Password,
-		cfg.Postgres.Database,
-		cfg.Postgres.Host,
-	)
+	backend, err := setupStorage(cfg)
 	if err != nil {
 		log.Error("failed to create storage", sl.Err(err))
 		os.Exit(1)
 	}
 
-	brokers := []string{"localhost:9092"}
-	topic := "msgproc"
+	storage := instrumented.New(backend)
+	metrics.RegisterStatusGauge(storage, cfg.CtxTimeout)
+
+	kafkaCfg := setupKafka(cfg)
+	if err := kafkaCfg.Validate(); err != nil {
+		log.Error("invalid Kafka configuration", sl.Err(err))
+		os.Exit(1)
+	}
+
+	serializers, err := setupSerializers(cfg, kafkaCfg.Topic)
+	if err != nil {
+		log.Error("failed to create serializers", sl.Err(err))
+		return
+	}
+
+	serializer, err := selectSerializer(serializers, cfg.Kafka.Serializer)
+	if err != nil {
+		log.Error("failed to select serializer", sl.Err(err))
+		return
+	}
 
-	sender, err := kafka.NewKafkaSender(brokers, topic, log)
+	sender, err := kafka.NewKafkaSender(kafkaCfg, serializer, log)
 	if err != nil {
 		log.Error("failed to create Kafka sender", sl.Err(err))
 		return
 	}
 
-	receiver, err := kafka.NewKafkaReceiver(log, brokers, topic, "msgproc")
+	pipe, err := setupPipeline(cfg)
+	if err != nil {
+		log.Error("failed to build processing pipeline", sl.Err(err))
+		return
+	}
+
+	receiver, err := kafka.NewKafkaReceiver(
+		log,
+		kafkaCfg,
+		kafka.RetryPolicy{
+			MaxAttempts: cfg.Kafka.Retry.MaxAttempts,
+			Backoff: backoff.Backoff{
+				Initial:    cfg.Kafka.Retry.InitialBackoff,
+				Max:        cfg.Kafka.Retry.MaxBackoff,
+				Multiplier: cfg.Kafka.Retry.Multiplier,
+				Jitter:     cfg.Kafka.Retry.Jitter,
+			},
+			Mode:  cfg.Kafka.Retry.Mode,
+			Topic: cfg.Kafka.Retry.Topic,
+		},
+		pipe,
+		serializers,
+	)
 	if err != nil {
 		log.Error("failed to create Kafka receiver", sl.Err(err))
 		return
@@ -61,31 +125,145 @@ func main() {
 
 	done := make(chan struct{})
 
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.CtxTimeout)
+	// The consumer runs until shutdown; only per-operation work is bounded
+	// by cfg.CtxTimeout.
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
 
 	go func() {
 		defer close(done)
-		defer cancel()
 
-		err := receiver.ProcessMessages(ctx, storage)
-		if err != nil {
+		err := receiver.ProcessMessages(consumerCtx, storage)
+		if err != nil && !errors.Is(err, context.Canceled) {
 			log.Error("failed to process messages", sl.Err(err))
 		}
 	}()
 
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+
+	relay := outbox.New(
+		log,
+		storage,
+		sender,
+		cfg.Outbox.PollInterval,
+		cfg.Outbox.BatchSize,
+		cfg.Outbox.LeaseTimeout,
+	)
+
+	go func() {
+		defer close(relayDone)
+
+		err := relay.Run(relayCtx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Error("outbox relay stopped", sl.Err(err))
+		}
+	}()
+
 	// Создаем HTTP сервер
 	msgStatService := msgstat.New(log, storage)
-	msgProc := msgproc.New(log, storage, sender)
+	msgProc := msgproc.New(log, storage, storage, cfg.Idempotency.TTL)
+
+	go msgProc.PurgeIdempotencyKeys(relayCtx, cfg.Idempotency.PurgeInterval)
+	go msgStatService.RefreshStats(relayCtx, cfg.Stats.RefreshInterval)
+
+	dispatcher := webhook.New(
+		log,
+		storage,
+		webhook.NewClient(cfg.Webhook.Timeout, cfg.Webhook.AllowedHosts),
+		cfg.Webhook.Secret,
+		cfg.Webhook.PollInterval,
+		cfg.Webhook.BatchSize,
+		cfg.Webhook.LeaseTimeout,
+		cfg.Webhook.MaxAttempts,
+		backoff.Backoff{
+			Initial:    cfg.Webhook.InitialBackoff,
+			Max:        cfg.Webhook.MaxBackoff,
+			Multiplier: cfg.Webhook.Multiplier,
+			Jitter:     cfg.Webhook.Jitter,
+		},
+	)
+
+	webhookDone := make(chan struct{})
+
+	webhooksEnabled := cfg.Webhook.Secret != ""
+	if webhooksEnabled {
+		go func() {
+			defer close(webhookDone)
+
+			err := dispatcher.Run(relayCtx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Error("webhook dispatcher stopped", sl.Err(err))
+			}
+		}()
+	} else {
+		close(webhookDone)
+		log.Warn("webhook secret is not set, callback urls are disabled")
+	}
+
+	listener, err := setupListener(cfg)
+	if err != nil {
+		log.Error("failed to listen for status changes", sl.Err(err))
+		return
+	}
+
+	var notifier events.Notifier
+	if listener != nil {
+		notifier = listener
+	}
+
+	hub := events.New(
+		log,
+		storage,
+		notifier,
+		cfg.Events.PollInterval,
+		cfg.Events.ReorderWindow,
+		cfg.Events.BatchSize,
+		cfg.Events.BufferSize,
+	)
+
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	eventsDone := make(chan struct{})
+
+	go func() {
+		defer close(eventsDone)
+
+		err := hub.Run(eventsCtx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Error("event hub stopped", sl.Err(err))
+		}
+	}()
 
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
 	router.Use(mvLog.New(log))
+	router.Use(mwMetrics.New())
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.URLFormat)
 
+	router.Handle("/metrics", promhttp.Handler())
+	router.Get("/healthz", health.Liveness())
+	checks := []health.Check{
+		{Name: cfg.Storage.Driver, Check: storage.Ping},
+	}
+	if cfg.Storage.Driver != storageDriverMemory {
+		checks = append(checks, health.Check{Name: "migrations", Check: migrationCheck(log, cfg, storage)})
+	}
+	checks = append(checks,
+		health.Check{Name: "kafka_producer", Check: sender.CheckBrokers},
+		health.Check{Name: "kafka_consumer_group", Check: receiver.CheckMembership},
+	)
+
+	router.Get("/readyz", health.Readiness(log, cfg.ReadinessTimeout, checks...))
+
 	router.Route("/api/v1", func(r chi.Router) {
-		r.Post("/msg", process.New(log, msgProc))
-		r.Get("/stat", stat.New(log, msgStatService))
+		r.Post("/msg", process.New(log, msgProc, relay, cfg.API.AckTimeout, webhooksEnabled))
+		r.Post("/msg/batch", batch.New(log, msgProc, cfg.API.MaxBatchSize))
+		r.Get("/msg", list.New(log, msgProc, cfg.API.DefaultPageSize, cfg.API.MaxPageSize))
+		r.Get("/msg/{id}", get.New(log, msgProc))
+		r.Get("/msg/{id}/history", history.New(log, msgProc))
+		r.Get("/msg/{id}/webhooks", webhooks.New(log, dispatcher))
+		r.Get("/stat", stat.New(log, msgStatService, cfg.API.MaxStatBuckets))
+		r.Get("/events", eventStream.New(log, hub, cfg.Events.KeepAlive, cfg.Events.ReorderWindow))
 	})
 
 	log.Info("starting server", slog.String("address", cfg.HTTPServer.Host))
@@ -98,6 +276,9 @@ func main() {
 		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
 	}
 
+	// Event streams never finish on their own; stopping the hub ends them.
+	srv.RegisterOnShutdown(stopEvents)
+
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Error("failed to start server", sl.Err(err))
@@ -112,14 +293,34 @@ func main() {
 	<-sigCh
 	log.Info("stopping server")
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.CtxTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Error("failed to stop server", sl.Err(err))
 	}
 
 	log.Info("server stopped")
 
+	stopEvents()
+	<-eventsDone
+
+	if listener != nil {
+		if err := listener.Close(); err != nil {
+			log.Error("failed to close status listener", sl.Err(err))
+		}
+	}
+
+	stopRelay()
+	<-relayDone
+	<-webhookDone
+
+	if err := sender.Close(); err != nil {
+		log.Error("failed to close Kafka sender", sl.Err(err))
+	}
+
+	stopConsumer()
+
 	select {
 	case <-done:
 		log.Info("Kafka consumer gracefully stopped")
@@ -128,6 +329,173 @@ func main() {
 	}
 }
 
+// migrationCheck verifies that the schema is not dirty and, when the
+// migrations directory is available, that it is up to date.
+func migrationCheck(log *slog.Logger, cfg *config.Config, versioner storage.HealthChecker) func(ctx context.Context) error {
+	expected, err := migrations.LatestVersion(filepath.Join(cfg.Migrator.MigrationsPath, cfg.Storage.Driver))
+	if err != nil {
+		log.Warn("migrations directory is unavailable, readiness will not check the schema version", sl.Err(err))
+	}
+
+	return func(ctx context.Context) error {
+		version, dirty, err := versioner.MigrationVersion(ctx, cfg.Migrator.MigrationsTable)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return fmt.Errorf("schema version %d is dirty", version)
+		}
+
+		if version < expected {
+			return fmt.Errorf("schema version %d is behind %d", version, expected)
+		}
+
+		return nil
+	}
+}
+
+func setupStorage(cfg *config.Config) (storage.Storage, error) {
+	switch cfg.Storage.Driver {
+	case storageDriverPostgres:
+		return postgres.NewStorage(
+			cfg.Postgres.User,
+			cfg.Postgres.Password,
+			cfg.Postgres.Database,
+			cfg.Postgres.Host,
+		)
+	case storageDriverSQLite:
+		return sqlite.NewStorage(cfg.SQLite.Path)
+	case storageDriverMemory:
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Storage.Driver)
+	}
+}
+
+// setupListener listens for status changes made by any instance. Without
+// one, the event hub polls the status history.
+func setupListener(cfg *config.Config) (*postgres.Listener, error) {
+	if cfg.Storage.Driver != storageDriverPostgres {
+		return nil, nil
+	}
+
+	return postgres.NewListener(
+		cfg.Postgres.User,
+		cfg.Postgres.Password,
+		cfg.Postgres.Database,
+		cfg.Postgres.Host,
+	)
+}
+
+func setupKafka(cfg *config.Config) kafka.Config {
+	return kafka.Config{
+		Brokers:         cfg.Kafka.Brokers,
+		Topic:           cfg.Kafka.Topic,
+		GroupID:         cfg.Kafka.GroupID,
+		DeadLetterTopic: cfg.Kafka.DeadLetterTopic,
+		ClientID:        cfg.Kafka.ClientID,
+		Version:         cfg.Kafka.Version,
+		SASL: kafka.SASLConfig{
+			Enabled:   cfg.Kafka.SASL.Enabled,
+			Mechanism: cfg.Kafka.SASL.Mechanism,
+			Username:  cfg.Kafka.SASL.Username,
+			Password:  cfg.Kafka.SASL.Password,
+		},
+		TLS: kafka.TLSConfig{
+			Enabled:            cfg.Kafka.TLS.Enabled,
+			CAFile:             cfg.Kafka.TLS.CAFile,
+			CertFile:           cfg.Kafka.TLS.CertFile,
+			KeyFile:            cfg.Kafka.TLS.KeyFile,
+			InsecureSkipVerify: cfg.Kafka.TLS.InsecureSkipVerify,
+		},
+		Producer: kafka.ProducerConfig{
+			Mode:            cfg.Kafka.Producer.Mode,
+			Linger:          cfg.Kafka.Producer.Linger,
+			BatchSize:       cfg.Kafka.Producer.BatchSize,
+			BatchBytes:      cfg.Kafka.Producer.BatchBytes,
+			Acks:            cfg.Kafka.Producer.Acks,
+			Compression:     cfg.Kafka.Producer.Compression,
+			Idempotent:      cfg.Kafka.Producer.Idempotent,
+			MaxMessageBytes: cfg.Kafka.Producer.MaxMessageBytes,
+		},
+		Consumer: kafka.ConsumerConfig{
+			SessionTimeout:    cfg.Kafka.Consumer.SessionTimeout,
+			HeartbeatInterval: cfg.Kafka.Consumer.HeartbeatInterval,
+			RebalanceTimeout:  cfg.Kafka.Consumer.RebalanceTimeout,
+			Workers:           cfg.Kafka.Consumer.Workers,
+			OrderByKey:        cfg.Kafka.Consumer.OrderByKey,
+			ResultBatchSize:   cfg.Kafka.Consumer.ResultBatchSize,
+			ResultLinger:      cfg.Kafka.Consumer.ResultLinger,
+			CommitInterval:    cfg.Kafka.Consumer.CommitInterval,
+		},
+	}
+}
+
+// setupSerializers returns a serializer for every supported format, so the
+// consumer can read records whatever format their producer chose.
+func setupSerializers(cfg *config.Config, topic string) ([]kafka.Serializer, error) {
+	var registry schemaregistry.Client
+	switch {
+	case cfg.Kafka.SchemaRegistry.URL != "":
+		registry = schemaregistry.NewHTTP(
+			cfg.Kafka.SchemaRegistry.URL,
+			cfg.Kafka.SchemaRegistry.Username,
+			cfg.Kafka.SchemaRegistry.Password,
+			&http.Client{Timeout: cfg.CtxTimeout},
+		)
+	case cfg.Kafka.SchemaRegistry.File != "":
+		fileRegistry, err := schemaregistry.NewFile(cfg.Kafka.SchemaRegistry.File)
+		if err != nil {
+			return nil, err
+		}
+		registry = fileRegistry
+	default:
+		registry = schemaregistry.NewMemory()
+	}
+
+	formats := []string{kafka.FormatJSON, kafka.FormatProtobuf, kafka.FormatAvro}
+
+	serializers := make([]kafka.Serializer, 0, len(formats))
+	for _, format := range formats {
+		s, err := kafka.NewSerializer(format, registry, topic+"-value")
+		if err != nil {
+			return nil, err
+		}
+		serializers = append(serializers, s)
+	}
+
+	return serializers, nil
+}
+
+func selectSerializer(serializers []kafka.Serializer, format string) (kafka.Serializer, error) {
+	for _, s := range serializers {
+		if s.Format() == format {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown serializer %q", format)
+}
+
+// setupPipeline builds the consumer pipeline from the config. Custom
+// processors must be registered with pipeline.Register before it runs.
+func setupPipeline(cfg *config.Config) (*pipeline.Pipeline, error) {
+	if len(cfg.Pipeline) == 0 {
+		return pipeline.Build(pipeline.DefaultStages)
+	}
+
+	stages := make([]pipeline.StageConfig, 0, len(cfg.Pipeline))
+	for _, stage := range cfg.Pipeline {
+		stages = append(stages, pipeline.StageConfig{
+			Name:   stage.Name,
+			Params: stage.Params,
+		})
+	}
+
+	return pipeline.Build(stages)
+}
+
 func setupLogger(env string) *slog.Logger {
 	var log *slog.Logger
 
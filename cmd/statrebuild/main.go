@@ -0,0 +1,48 @@
+// Command statrebuild recomputes the statistics rollups from the messages,
+// for when they have drifted or were restored without them:
+//
+//	CONFIG_PATH=./config/local.yaml statrebuild
+package main
+
+import (
+	"context"
+	"log"
+	"msgproc/internal/config"
+	"msgproc/internal/storage"
+	"msgproc/internal/storage/postgres"
+	"msgproc/internal/storage/sqlite"
+)
+
+func main() {
+	cfg := config.MustLoad()
+
+	var stats storage.StatProvider
+	switch cfg.Storage.Driver {
+	case "postgres":
+		s, err := postgres.NewStorage(
+			cfg.Postgres.User,
+			cfg.Postgres.Password,
+			cfg.Postgres.Database,
+			cfg.Postgres.Host,
+		)
+		if err != nil {
+			log.Fatalf("Failed to connect to postgres: %v\n", err)
+		}
+		stats = s
+	case "sqlite":
+		s, err := sqlite.NewStorage(cfg.SQLite.Path)
+		if err != nil {
+			log.Fatalf("Failed to open sqlite database: %v\n", err)
+		}
+		stats = s
+	default:
+		log.Fatalf("Storage driver %q has no statistics rollups\n", cfg.Storage.Driver)
+	}
+
+	log.Println("Rebuilding statistics...")
+	if err := stats.RebuildStats(context.Background()); err != nil {
+		log.Fatalf("Rebuild failed: %v\n", err)
+	}
+
+	log.Println("Statistics rebuilt successfully")
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -17,6 +18,20 @@ type Config struct {
 		IdleTimeout  time.Duration `yaml:"idle_timeout" env-default:"30s"`
 	} `yaml:"http_server"`
 
+	API struct {
+		DefaultPageSize int           `yaml:"default_page_size" env-default:"50"`
+		MaxPageSize     int           `yaml:"max_page_size" env-default:"500"`
+		MaxBatchSize    int           `yaml:"max_batch_size" env-default:"1000"`
+		AckTimeout      time.Duration `yaml:"ack_timeout" env-default:"5s"`
+		MaxStatBuckets  int           `yaml:"max_stat_buckets" env-default:"1440"`
+	} `yaml:"api"`
+
+	Storage struct {
+		// Driver is postgres, sqlite or memory. The memory backend keeps
+		// nothing across restarts and is meant for local runs.
+		Driver string `yaml:"driver" env-default:"postgres"`
+	} `yaml:"storage"`
+
 	Postgres struct {
 		Host     string `yaml:"host" env-default:"localhost"`
 		Port     string `yaml:"port" env-default:"5432"`
@@ -25,16 +40,143 @@ type Config struct {
 		Password string `yaml:"password" env-default:"msgproc"`
 	} `yaml:"postgres"`
 
+	SQLite struct {
+		Path string `yaml:"path" env-default:"./msgproc.db"`
+	} `yaml:"sqlite"`
+
 	Kafka struct {
-		Hosts string `yaml:"hosts" env-default:"localhost:9092"`
+		Brokers []string `yaml:"brokers" env-default:"localhost:9092"`
+		// Hosts is the comma-separated broker list of older configs. It is
+		// deprecated in favour of Brokers and replaces it when set.
+		Hosts           string `yaml:"hosts"`
+		Topic           string `yaml:"topic" env-default:"msgproc"`
+		GroupID         string `yaml:"group_id" env-default:"msgproc"`
+		DeadLetterTopic string `yaml:"dead_letter_topic" env-default:"msgproc-dlq"`
+		ClientID        string `yaml:"client_id" env-default:"msgproc"`
+		Version         string `yaml:"version" env-default:"2.1.0"`
+
+		SASL struct {
+			Enabled   bool   `yaml:"enabled"`
+			Mechanism string `yaml:"mechanism" env-default:"PLAIN"`
+			Username  string `yaml:"username"`
+			Password  string `yaml:"password"`
+		} `yaml:"sasl"`
+
+		TLS struct {
+			Enabled            bool   `yaml:"enabled"`
+			CAFile             string `yaml:"ca_file"`
+			CertFile           string `yaml:"cert_file"`
+			KeyFile            string `yaml:"key_file"`
+			InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+		} `yaml:"tls"`
+
+		Producer struct {
+			Mode            string        `yaml:"mode" env-default:"sync"`
+			Linger          time.Duration `yaml:"linger" env-default:"5ms"`
+			BatchSize       int           `yaml:"batch_size" env-default:"500"`
+			BatchBytes      int           `yaml:"batch_bytes"`
+			Acks            string        `yaml:"acks" env-default:"all"`
+			Compression     string        `yaml:"compression" env-default:"none"`
+			Idempotent      bool          `yaml:"idempotent"`
+			MaxMessageBytes int           `yaml:"max_message_bytes" env-default:"1000000"`
+		} `yaml:"producer"`
+
+		Consumer struct {
+			SessionTimeout    time.Duration `yaml:"session_timeout" env-default:"10s"`
+			HeartbeatInterval time.Duration `yaml:"heartbeat_interval" env-default:"3s"`
+			RebalanceTimeout  time.Duration `yaml:"rebalance_timeout" env-default:"60s"`
+			Workers           int           `yaml:"workers" env-default:"1"`
+			OrderByKey        bool          `yaml:"order_by_key" env-default:"true"`
+			ResultBatchSize   int           `yaml:"result_batch_size" env-default:"1"`
+			ResultLinger      time.Duration `yaml:"result_linger" env-default:"5ms"`
+			CommitInterval    time.Duration `yaml:"commit_interval" env-default:"1s"`
+		} `yaml:"consumer"`
+
+		Retry struct {
+			MaxAttempts    int           `yaml:"max_attempts" env-default:"5"`
+			InitialBackoff time.Duration `yaml:"initial_backoff" env-default:"200ms"`
+			MaxBackoff     time.Duration `yaml:"max_backoff" env-default:"30s"`
+			Multiplier     float64       `yaml:"multiplier" env-default:"2"`
+			Jitter         float64       `yaml:"jitter" env-default:"0.2"`
+			Mode           string        `yaml:"mode" env-default:"inline"`
+			Topic          string        `yaml:"topic" env-default:"msgproc-retry"`
+		} `yaml:"retry"`
+
+		Serializer string `yaml:"serializer" env-default:"json"`
+
+		SchemaRegistry struct {
+			URL      string `yaml:"url"`
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+			File     string `yaml:"file"`
+		} `yaml:"schema_registry"`
 	} `yaml:"kafka"`
 
+	Pipeline []struct {
+		Name   string            `yaml:"name"`
+		Params map[string]string `yaml:"params"`
+	} `yaml:"pipeline"`
+
+	Idempotency struct {
+		TTL           time.Duration `yaml:"ttl" env-default:"24h"`
+		PurgeInterval time.Duration `yaml:"purge_interval" env-default:"10m"`
+	} `yaml:"idempotency"`
+
+	Outbox struct {
+		PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+		BatchSize    int           `yaml:"batch_size" env-default:"100"`
+		LeaseTimeout time.Duration `yaml:"lease_timeout" env-default:"30s"`
+	} `yaml:"outbox"`
+
+	Events struct {
+		// PollInterval is how often the status history is polled for new
+		// events when the storage cannot notify about them.
+		PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+		// ReorderWindow is how long a missing history entry is waited for,
+		// since IDs are taken on insert but entries show up on commit.
+		ReorderWindow time.Duration `yaml:"reorder_window" env-default:"1m"`
+		BatchSize     int           `yaml:"batch_size" env-default:"500"`
+		// BufferSize is how many events a client may fall behind before it
+		// is disconnected to resume from the history.
+		BufferSize int           `yaml:"buffer_size" env-default:"256"`
+		KeepAlive  time.Duration `yaml:"keep_alive" env-default:"15s"`
+	} `yaml:"events"`
+
+	Webhook struct {
+		// Secret signs webhook requests. Callback URLs are refused while it
+		// is empty.
+		Secret string `yaml:"secret"`
+		// AllowedHosts, when set, are the only hosts callback URLs may
+		// point to. Internal addresses are refused either way.
+		AllowedHosts []string      `yaml:"allowed_hosts"`
+		Timeout      time.Duration `yaml:"timeout" env-default:"10s"`
+		PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+		BatchSize    int           `yaml:"batch_size" env-default:"50"`
+		// LeaseTimeout must outlast Timeout, or a slow delivery may be
+		// attempted twice.
+		LeaseTimeout   time.Duration `yaml:"lease_timeout" env-default:"1m"`
+		MaxAttempts    int           `yaml:"max_attempts" env-default:"8"`
+		InitialBackoff time.Duration `yaml:"initial_backoff" env-default:"1s"`
+		MaxBackoff     time.Duration `yaml:"max_backoff" env-default:"10m"`
+		Multiplier     float64       `yaml:"multiplier" env-default:"2"`
+		Jitter         float64       `yaml:"jitter" env-default:"0.2"`
+	} `yaml:"webhook"`
+
+	Stats struct {
+		// RefreshInterval is how often message changes are folded into the
+		// statistics rollups, and so how stale /stat may be.
+		RefreshInterval time.Duration `yaml:"refresh_interval" env-default:"10s"`
+	} `yaml:"stats"`
+
 	Migrator struct {
+		// MigrationsPath holds a directory of migrations per storage driver.
 		MigrationsPath  string `yaml:"migrations_path" env-default:"./migrations"`
 		MigrationsTable string `yaml:"migrations_table" env-required:"true"`
 	} `yaml:"migrator"`
 
 	CtxTimeout time.Duration `yaml:"ctx_timeout" env-default:"5s"`
+
+	ReadinessTimeout time.Duration `yaml:"readiness_timeout" env-default:"2s"`
 }
 
 func LoadConfig(configPath string, cfg interface{}) {
@@ -55,5 +197,20 @@ func MustLoad() *Config {
 
 	var cfg Config
 	LoadConfig(configPath, &cfg)
+	applyDeprecated(&cfg)
 	return &cfg
 }
+
+// applyDeprecated maps deprecated settings onto the ones replacing them.
+func applyDeprecated(cfg *Config) {
+	if cfg.Kafka.Hosts != "" {
+		log.Printf("kafka.hosts is deprecated, use kafka.brokers")
+
+		cfg.Kafka.Brokers = nil
+		for _, host := range strings.Split(cfg.Kafka.Hosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				cfg.Kafka.Brokers = append(cfg.Kafka.Brokers, host)
+			}
+		}
+	}
+}
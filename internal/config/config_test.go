@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestKafkaHostsAlias(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{name: "default", want: []string{"localhost:9092"}},
+		{name: "brokers", yaml: "kafka:\n  brokers: [\"a:9092\", \"b:9092\"]\n", want: []string{"a:9092", "b:9092"}},
+		{name: "deprecated hosts", yaml: "kafka:\n  hosts: \"a:9092, b:9092\"\n", want: []string{"a:9092", "b:9092"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			data := "migrator:\n  migrations_table: migrations\n" + tt.yaml
+			if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			var cfg Config
+			LoadConfig(path, &cfg)
+			applyDeprecated(&cfg)
+
+			if !slices.Equal(cfg.Kafka.Brokers, tt.want) {
+				t.Errorf("brokers = %q, want %q", cfg.Kafka.Brokers, tt.want)
+			}
+		})
+	}
+}
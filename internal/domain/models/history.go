@@ -0,0 +1,59 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	ActorAPI      = "api"
+	ActorRelay    = "relay"
+	ActorConsumer = "consumer"
+	ActorAdmin    = "admin"
+)
+
+// Change describes who made a status change and why. Partition and Offset
+// are set when the change was caused by a Kafka record.
+type Change struct {
+	Actor     string
+	Reason    string
+	Partition *int32
+	Offset    *int64
+}
+
+type StatusHistoryEntry struct {
+	ID        int64     `json:"id"`
+	MsgID     int64     `json:"msg_id"`
+	OldStatus string    `json:"old_status,omitempty"`
+	NewStatus string    `json:"new_status"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason,omitempty"`
+	Partition *int32    `json:"kafka_partition,omitempty"`
+	Offset    *int64    `json:"kafka_offset,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EventFilter selects status history entries for the event stream, oldest
+// first. Entries match any of the message IDs and any of the statuses they
+// moved to; empty lists match everything.
+type EventFilter struct {
+	AfterID  int64
+	IDs      []int64
+	MsgIDs   []int64
+	Statuses []string
+	Limit    int
+}
+
+// Matches reports whether the entry is for one of the messages and moved to
+// one of the statuses of the filter. AfterID, IDs and Limit are not checked.
+func (f EventFilter) Matches(e StatusHistoryEntry) bool {
+	if len(f.MsgIDs) > 0 && !slices.Contains(f.MsgIDs, e.MsgID) {
+		return false
+	}
+
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, e.NewStatus) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"math"
+	"sort"
+)
+
+// Latency summarises durations in seconds. Percentiles interpolate between
+// the closest values, like Postgres percentile_cont.
+type Latency struct {
+	Count int64
+	P50   float64
+	P90   float64
+	P99   float64
+	Max   float64
+}
+
+// ProcessingLatency describes how long completed messages took: end to end
+// from ingestion, and on the consumer side from being picked up.
+type ProcessingLatency struct {
+	EndToEnd Latency
+	Consumer Latency
+}
+
+// NewLatency summarises the durations, in seconds. It sorts durations in
+// place.
+func NewLatency(durations []float64) Latency {
+	if len(durations) == 0 {
+		return Latency{}
+	}
+
+	sort.Float64s(durations)
+
+	return Latency{
+		Count: int64(len(durations)),
+		P50:   percentile(durations, 0.5),
+		P90:   percentile(durations, 0.9),
+		P99:   percentile(durations, 0.99),
+		Max:   durations[len(durations)-1],
+	}
+}
+
+// percentile returns the continuous percentile p of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lo := math.Floor(pos)
+	hi := math.Ceil(pos)
+
+	return sorted[int(lo)] + (sorted[int(hi)]-sorted[int(lo)])*(pos-lo)
+}
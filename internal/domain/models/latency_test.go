@@ -0,0 +1,73 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewLatency(t *testing.T) {
+	// 1..100 shuffled: positions are p*(n-1), so p50 sits between 50 and 51.
+	hundred := make([]float64, 100)
+	for i := range hundred {
+		hundred[i] = float64((i*37)%100 + 1)
+	}
+
+	tests := []struct {
+		name      string
+		durations []float64
+		want      Latency
+	}{
+		{name: "empty", durations: nil, want: Latency{}},
+		{name: "single sample", durations: []float64{2.5}, want: Latency{Count: 1, P50: 2.5, P90: 2.5, P99: 2.5, Max: 2.5}},
+		{name: "two samples", durations: []float64{3, 1}, want: Latency{Count: 2, P50: 2, P90: 2.8, P99: 2.98, Max: 3}},
+		{name: "identical samples", durations: []float64{4, 4, 4}, want: Latency{Count: 3, P50: 4, P90: 4, P99: 4, Max: 4}},
+		{name: "odd count", durations: []float64{5, 1, 3, 2, 4}, want: Latency{Count: 5, P50: 3, P90: 4.6, P99: 4.96, Max: 5}},
+		{name: "interpolated", durations: hundred, want: Latency{Count: 100, P50: 50.5, P90: 90.1, P99: 99.01, Max: 100}},
+		{name: "outlier", durations: []float64{0.1, 0.1, 0.1, 0.1, 60}, want: Latency{Count: 5, P50: 0.1, P90: 36.04, P99: 57.604, Max: 60}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewLatency(tt.durations)
+
+			if got.Count != tt.want.Count {
+				t.Errorf("Count = %d, want %d", got.Count, tt.want.Count)
+			}
+			for _, f := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"P50", got.P50, tt.want.P50},
+				{"P90", got.P90, tt.want.P90},
+				{"P99", got.P99, tt.want.P99},
+				{"Max", got.Max, tt.want.Max},
+			} {
+				if math.Abs(f.got-f.want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", f.name, f.got, f.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50}
+
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 10},
+		{0.25, 20},
+		{0.5, 30},
+		{0.95, 48},
+		{0.99, 49.6},
+		{1, 50},
+	}
+
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
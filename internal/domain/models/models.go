@@ -1,9 +1,77 @@
 package models
 
-type Statistics struct {
+import "time"
+
+type NewMsg struct {
+	Content   string
+	RequestID string
+	// CallbackURL, when set, is notified once the message completes or
+	// fails.
+	CallbackURL string
+}
+
+type Message struct {
+	ID        int64     `json:"id"`
+	Content   string    `json:"content"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// ProcessingStartedAt is when the consumer last picked the message up
+	// and ProcessedAt when it last completed or failed it.
+	ProcessingStartedAt *time.Time `json:"processing_started_at,omitempty"`
+	ProcessedAt         *time.Time `json:"processed_at,omitempty"`
+	// CallbackURL is notified once the message completes or fails.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+const (
+	SortByID        = "id"
+	SortByCreatedAt = "created_at"
+	SortByUpdatedAt = "updated_at"
+)
+
+type MsgFilter struct {
+	Statuses      []string
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	UpdatedFrom   *time.Time
+	UpdatedTo     *time.Time
+	ContentPrefix string
+	SortBy        string
+	Desc          bool
+	Limit         int
+	Cursor        string
+}
+
+type MsgPage struct {
+	Msgs       []Message `json:"msgs"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// StatsSummary is read from the statistics rollups, which are as fresh as
+// RefreshedAt.
+type StatsSummary struct {
 	TotalMessages          int64
 	MessagesByStatus       map[string]int64
 	MessagesLastDay        int64
 	MessagesUpdatedLastDay int64
 	AverageMessageLength   float64
+	RefreshedAt            time.Time
+}
+
+type Statistics struct {
+	StatsSummary
+	Series  []SeriesPoint
+	Latency ProcessingLatency
+}
+
+type OutboxMsg struct {
+	ID        int64
+	MsgID     int64
+	Content   string
+	RequestID string
+	Attempts  int
+	CreatedAt time.Time
 }
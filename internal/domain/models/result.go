@@ -0,0 +1,14 @@
+package models
+
+// ProcessingResult is the final outcome of processing a message, written by
+// the consumer in a single storage call.
+type ProcessingResult struct {
+	MsgID  int64
+	Status string
+	// Content replaces the stored content when set.
+	Content  *string
+	Attempts int
+	// Error is stored as the last error of the message; empty clears it.
+	Error  string
+	Change Change
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+const (
+	BucketMinute = "minute"
+	BucketHour   = "hour"
+	BucketDay    = "day"
+)
+
+// SeriesFilter selects the window [From, To) of the statistics time series.
+// Buckets are aligned to whole minutes, hours or days in UTC, so the first
+// bucket may start before From.
+type SeriesFilter struct {
+	From   time.Time
+	To     time.Time
+	Bucket string
+}
+
+// SeriesPoint holds the number of messages created, completed and failed in
+// the bucket starting at Time.
+type SeriesPoint struct {
+	Time      time.Time
+	Created   int64
+	Completed int64
+	Failed    int64
+}
+
+// BucketDuration returns the length of a bucket, or zero for an unknown one.
+func BucketDuration(bucket string) time.Duration {
+	switch bucket {
+	case BucketMinute:
+		return time.Minute
+	case BucketHour:
+		return time.Hour
+	case BucketDay:
+		return 24 * time.Hour
+	}
+
+	return 0
+}
+
+// Buckets returns the number of buckets in the window.
+func (f SeriesFilter) Buckets() int {
+	d := BucketDuration(f.Bucket)
+	if d == 0 || !f.From.Before(f.To) {
+		return 0
+	}
+
+	start := f.From.UTC().Truncate(d)
+
+	return int((f.To.Sub(start) + d - 1) / d)
+}
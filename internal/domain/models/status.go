@@ -0,0 +1,73 @@
+package models
+
+const (
+	StatusNew        = "new"
+	StatusQueued     = "queued"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+)
+
+// Statuses lists the lifecycle statuses of a message in order.
+var Statuses = []string{
+	StatusNew,
+	StatusQueued,
+	StatusProcessing,
+	StatusCompleted,
+	StatusFailed,
+	StatusCancelled,
+}
+
+// transitions maps each status to the statuses it may move to. A message
+// stays in processing while its record is retried or redelivered, and a
+// failed message may be queued again by hand.
+var transitions = map[string][]string{
+	StatusNew:        {StatusQueued, StatusProcessing, StatusCancelled},
+	StatusQueued:     {StatusProcessing, StatusCancelled},
+	StatusProcessing: {StatusProcessing, StatusCompleted, StatusFailed, StatusCancelled},
+	StatusFailed:     {StatusQueued},
+	StatusCompleted:  {},
+	StatusCancelled:  {},
+}
+
+func IsValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TransitionSources returns the statuses a message may be in to move to the
+// given status.
+func TransitionSources(to string) []string {
+	var sources []string
+	for _, from := range Statuses {
+		if CanTransition(from, to) {
+			sources = append(sources, from)
+		}
+	}
+
+	return sources
+}
+
+// TransitionPairs returns every allowed transition as parallel slices of
+// source and target statuses.
+func TransitionPairs() (from []string, to []string) {
+	for _, f := range Statuses {
+		for _, t := range transitions[f] {
+			from = append(from, f)
+			to = append(to, t)
+		}
+	}
+
+	return from, to
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Delivery states of a webhook.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookPayload is the body POSTed to the callback URL of a message when it
+// completes or fails. It describes the message as it was at that moment.
+type WebhookPayload struct {
+	DeliveryID  int64      `json:"delivery_id"`
+	MsgID       int64      `json:"msg_id"`
+	Status      string     `json:"status"`
+	Content     string     `json:"content"`
+	LastError   string     `json:"last_error,omitempty"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+// Webhook is a leased delivery, ready to be sent.
+type Webhook struct {
+	ID       int64
+	URL      string
+	Attempts int
+	Payload  WebhookPayload
+}
+
+// WebhookDelivery describes a webhook and every attempt to deliver it.
+type WebhookDelivery struct {
+	ID            int64            `json:"id"`
+	MsgID         int64            `json:"msg_id"`
+	URL           string           `json:"url"`
+	Status        string           `json:"status"`
+	State         string           `json:"state"`
+	Attempts      int              `json:"attempts"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+	Log           []WebhookAttempt `json:"log"`
+}
+
+// WebhookAttempt is the outcome of one attempt. StatusCode is 0 when no
+// response was received.
+type WebhookAttempt struct {
+	DeliveryID int64     `json:"-"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,210 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	resp "msgproc/internal/lib/api/response"
+	"msgproc/internal/lib/logger/sl"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EventStreamer interface {
+	Subscribe(filter models.EventFilter) (<-chan models.StatusHistoryEntry, func(), error)
+	Since(ctx context.Context, filter models.EventFilter, afterID int64) ([]models.StatusHistoryEntry, error)
+}
+
+// New streams status changes as Server-Sent Events. Every event carries the
+// ID of its history entry, so a client that reconnects with Last-Event-ID,
+// or the last_event_id parameter, first receives what it missed. Changes
+// committed within reorderWindow of the request may reach it both in the
+// replay and live; they are sent once.
+func New(log *slog.Logger, streamer EventStreamer, keepAlive, reorderWindow time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "http-server.handlers.events.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		filter, lastID, resume, err := parseRequest(r)
+		if err != nil {
+			log.Error("invalid query", sl.Err(err))
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		// Subscribe before replaying, so nothing written in between is
+		// missed.
+		events, unsubscribe, err := streamer.Subscribe(filter)
+		if err != nil {
+			log.Error("failed to subscribe to events", sl.Err(err))
+
+			w.WriteHeader(http.StatusServiceUnavailable)
+			render.JSON(w, r, resp.Error("event stream is unavailable"))
+
+			return
+		}
+		defer unsubscribe()
+
+		rc := http.NewResponseController(w)
+
+		// The server write timeout is meant for regular requests.
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Warn("failed to clear the write deadline", sl.Err(err))
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if err := rc.Flush(); err != nil {
+			log.Error("streaming is not supported", sl.Err(err))
+			return
+		}
+
+		// History IDs are not committed in order, so a live event may have a
+		// lower ID than the last replayed one and still be new. Only the
+		// replayed changes recent enough to be streamed live are remembered.
+		replayed := make(map[int64]struct{})
+		recent := time.Now().Add(-reorderWindow)
+		if resume {
+			for {
+				entries, err := streamer.Since(r.Context(), filter, lastID)
+				if err != nil {
+					log.Error("failed to replay events", sl.Err(err))
+					return
+				}
+				if len(entries) == 0 {
+					break
+				}
+
+				for _, e := range entries {
+					if err := writeEvent(w, e); err != nil {
+						log.Info("client disconnected", sl.Err(err))
+						return
+					}
+					if !e.CreatedAt.Before(recent) {
+						replayed[e.ID] = struct{}{}
+					}
+				}
+				if err := rc.Flush(); err != nil {
+					log.Info("client disconnected", sl.Err(err))
+					return
+				}
+
+				lastID = entries[len(entries)-1].ID
+			}
+		}
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-events:
+				if !ok {
+					// The client reconnects and resumes from its last event.
+					log.Info("event subscription closed")
+					return
+				}
+				if _, ok := replayed[e.ID]; ok {
+					delete(replayed, e.ID)
+					continue
+				}
+
+				if err := writeEvent(w, e); err != nil {
+					log.Info("client disconnected", sl.Err(err))
+					return
+				}
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					log.Info("client disconnected", sl.Err(err))
+					return
+				}
+			}
+
+			if err := rc.Flush(); err != nil {
+				log.Info("client disconnected", sl.Err(err))
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e models.StatusHistoryEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", e.ID, data)
+	return err
+}
+
+// parseRequest reads the message IDs and statuses to stream and, when the
+// client is resuming, the ID of the last event it received.
+func parseRequest(r *http.Request) (models.EventFilter, int64, bool, error) {
+	q := r.URL.Query()
+
+	filter, err := parseFilter(q)
+	if err != nil {
+		return filter, 0, false, err
+	}
+
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = q.Get("last_event_id")
+	}
+	if v == "" {
+		return filter, 0, false, nil
+	}
+
+	lastID, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || lastID < 0 {
+		return filter, 0, false, fmt.Errorf("invalid last event id: %s", v)
+	}
+
+	return filter, lastID, true, nil
+}
+
+func parseFilter(q url.Values) (models.EventFilter, error) {
+	var filter models.EventFilter
+
+	if v := q.Get("msg_id"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || id <= 0 {
+				return filter, fmt.Errorf("invalid message id: %s", s)
+			}
+			filter.MsgIDs = append(filter.MsgIDs, id)
+		}
+	}
+
+	if v := q.Get("status"); v != "" {
+		filter.Statuses = strings.Split(v, ",")
+		for _, status := range filter.Statuses {
+			if !models.IsValidStatus(status) {
+				return filter, fmt.Errorf("invalid status: %s", status)
+			}
+		}
+	}
+
+	return filter, nil
+}
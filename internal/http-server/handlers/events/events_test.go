@@ -0,0 +1,76 @@
+package events
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replayStreamer replays history once and then streams the live events.
+type replayStreamer struct {
+	history []models.StatusHistoryEntry
+	live    []models.StatusHistoryEntry
+}
+
+func (s *replayStreamer) Subscribe(models.EventFilter) (<-chan models.StatusHistoryEntry, func(), error) {
+	events := make(chan models.StatusHistoryEntry, len(s.live))
+	for _, e := range s.live {
+		events <- e
+	}
+	close(events)
+
+	return events, func() {}, nil
+}
+
+func (s *replayStreamer) Since(_ context.Context, _ models.EventFilter, afterID int64) ([]models.StatusHistoryEntry, error) {
+	var entries []models.StatusHistoryEntry
+	for _, e := range s.history {
+		if e.ID > afterID {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func TestResume(t *testing.T) {
+	now := time.Now()
+	entry := func(id int64, createdAt time.Time) models.StatusHistoryEntry {
+		return models.StatusHistoryEntry{ID: id, MsgID: 1, NewStatus: models.StatusQueued, CreatedAt: createdAt}
+	}
+
+	streamer := &replayStreamer{
+		history: []models.StatusHistoryEntry{entry(3, now.Add(-time.Hour)), entry(5, now)},
+		// 5 was replayed; 4 committed late and 6 is new.
+		live: []models.StatusHistoryEntry{entry(5, now), entry(4, now), entry(6, now)},
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := New(log, streamer, time.Hour, time.Minute)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/events", nil)
+	r.Header.Set("Last-Event-ID", "2")
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var ids []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	if got, want := strings.Join(ids, ","), "3,5,4,6"; got != want {
+		t.Errorf("event ids = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package health
+
+import (
+	"context"
+	"github.com/go-chi/render"
+	"log/slog"
+	resp "msgproc/internal/lib/api/response"
+	"msgproc/internal/lib/logger/sl"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Liveness reports that the process is up and serving HTTP.
+func Liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// Readiness runs all checks concurrently and responds with 503 if any of them
+// fails or does not finish within timeout.
+func Readiness(log *slog.Logger, timeout time.Duration, checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "http-server.handlers.health.Readiness"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		var (
+			mu      sync.Mutex
+			wg      sync.WaitGroup
+			results = make(map[string]CheckResult, len(checks))
+			ready   = true
+		)
+		for _, c := range checks {
+			wg.Add(1)
+			go func(c Check) {
+				defer wg.Done()
+
+				start := time.Now()
+				err := c.Check(ctx)
+				res := CheckResult{
+					Status:    resp.StatusOK,
+					LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+				}
+				if err != nil {
+					log.Warn("readiness check failed", slog.String("check", c.Name), sl.Err(err))
+
+					res.Status = resp.StatusError
+					res.Error = err.Error()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				results[c.Name] = res
+				if err != nil {
+					ready = false
+				}
+			}(c)
+		}
+		wg.Wait()
+
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			render.JSON(w, r, Response{
+				Response: resp.Error("not ready"),
+				Checks:   results,
+			})
+
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Checks:   results,
+		})
+	}
+}
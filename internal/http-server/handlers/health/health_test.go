@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	resp "msgproc/internal/lib/api/response"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ok(context.Context) error { return nil }
+
+func TestReadiness(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	blocked := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "ready",
+			checks:     []Check{{Name: "storage", Check: ok}, {Name: "kafka_consumer_group", Check: ok}},
+			wantCode:   http.StatusOK,
+			wantStatus: resp.StatusOK,
+			wantChecks: map[string]string{"storage": resp.StatusOK, "kafka_consumer_group": resp.StatusOK},
+		},
+		{
+			name: "failing check",
+			checks: []Check{
+				{Name: "storage", Check: ok},
+				{Name: "kafka_consumer_group", Check: func(context.Context) error {
+					return errors.New("not a member of the consumer group")
+				}},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: resp.StatusError,
+			wantChecks: map[string]string{"storage": resp.StatusOK, "kafka_consumer_group": resp.StatusError},
+		},
+		{
+			name:       "check timing out",
+			checks:     []Check{{Name: "storage", Check: ok}, {Name: "kafka_producer", Check: blocked}},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: resp.StatusError,
+			wantChecks: map[string]string{"storage": resp.StatusOK, "kafka_producer": resp.StatusError},
+		},
+		{
+			name:       "no checks",
+			wantCode:   http.StatusOK,
+			wantStatus: resp.StatusOK,
+			wantChecks: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Readiness(log, 50*time.Millisecond, tt.checks...)(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			var res Response
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if res.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", res.Status, tt.wantStatus)
+			}
+			if len(res.Checks) != len(tt.wantChecks) {
+				t.Errorf("checks = %+v, want %v", res.Checks, tt.wantChecks)
+			}
+			for name, want := range tt.wantChecks {
+				got, found := res.Checks[name]
+				if !found || got.Status != want {
+					t.Errorf("check %s = %+v, want status %q", name, got, want)
+				}
+				if want == resp.StatusError && got.Error == "" {
+					t.Errorf("check %s has no error", name)
+				}
+			}
+		})
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Liveness()(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
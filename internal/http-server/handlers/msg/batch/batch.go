@@ -0,0 +1,181 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	resp "msgproc/internal/lib/api/response"
+	"msgproc/internal/lib/logger/sl"
+	"net/http"
+	"strings"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+type Request struct {
+	Msg string `json:"msg" validate:"required"`
+}
+
+type Item struct {
+	Index int    `json:"index"`
+	MsgID int64  `json:"msg_id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type Response struct {
+	resp.Response
+	Items []Item `json:"items"`
+}
+
+type BatchProcessor interface {
+	ProcessMsgs(ctx context.Context, msgs []models.NewMsg) ([]int64, error)
+}
+
+var errTooManyItems = errors.New("too many items in batch")
+
+// New accepts either a JSON array of messages or an NDJSON stream, one
+// message per line, when the request has the application/x-ndjson content
+// type. Invalid items are reported per item and do not fail the batch.
+func New(log *slog.Logger, processor BatchProcessor, maxBatchSize int) http.HandlerFunc {
+	validate := validator.New()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "http-server.handlers.msg.batch.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		reqs, err := decodeBatch(r, maxBatchSize)
+		if err != nil {
+			log.Error("failed to decode request body", sl.Err(err))
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		if len(reqs) == 0 {
+			log.Error("request body is empty")
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("empty request"))
+
+			return
+		}
+
+		log.Info("request body decoded", slog.Int("count", len(reqs)))
+
+		requestID := middleware.GetReqID(r.Context())
+
+		items := make([]Item, len(reqs))
+		msgs := make([]models.NewMsg, 0, len(reqs))
+		valid := make([]int, 0, len(reqs))
+		for i, req := range reqs {
+			items[i].Index = i
+
+			if err := validate.Struct(req); err != nil {
+				items[i].Error = "request validation failed"
+				continue
+			}
+
+			msgs = append(msgs, models.NewMsg{
+				Content:   req.Msg,
+				RequestID: requestID,
+			})
+			valid = append(valid, i)
+		}
+
+		if len(msgs) == 0 {
+			log.Error("request validation failed for every item")
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, Response{
+				Response: resp.Error("request validation failed"),
+				Items:    items,
+			})
+
+			return
+		}
+
+		msgIDs, err := processor.ProcessMsgs(r.Context(), msgs)
+		if err != nil {
+			log.Error("failed to process messages", sl.Err(err))
+
+			w.WriteHeader(http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to process messages"))
+
+			return
+		}
+
+		for i, idx := range valid {
+			items[idx].MsgID = msgIDs[i]
+		}
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Items:    items,
+		})
+	}
+}
+
+func decodeBatch(r *http.Request, maxBatchSize int) ([]Request, error) {
+	dec := json.NewDecoder(r.Body)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeNDJSON) {
+		var reqs []Request
+		for {
+			var req Request
+			if err := dec.Decode(&req); err != nil {
+				if errors.Is(err, io.EOF) {
+					return reqs, nil
+				}
+				return nil, fmt.Errorf("failed to decode item %d", len(reqs))
+			}
+
+			if len(reqs) == maxBatchSize {
+				return nil, errTooManyItems
+			}
+			reqs = append(reqs, req)
+		}
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, errors.New("failed to decode request")
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("request body must be a JSON array")
+	}
+
+	var reqs []Request
+	for dec.More() {
+		var req Request
+		if err := dec.Decode(&req); err != nil {
+			return nil, fmt.Errorf("failed to decode item %d", len(reqs))
+		}
+
+		if len(reqs) == maxBatchSize {
+			return nil, errTooManyItems
+		}
+		reqs = append(reqs, req)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, errors.New("failed to decode request")
+	}
+
+	return reqs, nil
+}
@@ -0,0 +1,202 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/services/msgproc"
+	"msgproc/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func request(body, contentType string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/msg/batch", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+
+	return r
+}
+
+func TestDecodeBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        []Request
+		wantErr     error
+	}{
+		{name: "empty body", body: "", want: nil},
+		{name: "empty array", body: "[]", want: nil},
+		{
+			name: "array",
+			body: `[{"msg":"a"},{"msg":"b"}]`,
+			want: []Request{{Msg: "a"}, {Msg: "b"}},
+		},
+		{
+			name: "array at the limit",
+			body: `[{"msg":"a"},{"msg":"b"},{"msg":"c"}]`,
+			want: []Request{{Msg: "a"}, {Msg: "b"}, {Msg: "c"}},
+		},
+		{name: "array over the limit", body: `[{"msg":"a"},{"msg":"b"},{"msg":"c"},{"msg":"d"}]`, wantErr: errTooManyItems},
+		{name: "object instead of array", body: `{"msg":"a"}`, wantErr: errAny},
+		{name: "unterminated array", body: `[{"msg":"a"}`, wantErr: errAny},
+		{name: "invalid item", body: `[{"msg":"a"},"b"]`, wantErr: errAny},
+		{name: "not json", body: `hello`, wantErr: errAny},
+		{
+			name:        "ndjson",
+			body:        "{\"msg\":\"a\"}\n{\"msg\":\"b\"}\n",
+			contentType: contentTypeNDJSON,
+			want:        []Request{{Msg: "a"}, {Msg: "b"}},
+		},
+		{
+			name:        "ndjson with charset",
+			body:        `{"msg":"a"}`,
+			contentType: contentTypeNDJSON + "; charset=utf-8",
+			want:        []Request{{Msg: "a"}},
+		},
+		{name: "empty ndjson", body: "", contentType: contentTypeNDJSON, want: nil},
+		{
+			name:        "ndjson over the limit",
+			body:        "{\"msg\":\"a\"}\n{\"msg\":\"b\"}\n{\"msg\":\"c\"}\n{\"msg\":\"d\"}\n",
+			contentType: contentTypeNDJSON,
+			wantErr:     errTooManyItems,
+		},
+		{name: "invalid ndjson line", body: "{\"msg\":\"a\"}\n{\"msg\":\n", contentType: contentTypeNDJSON, wantErr: errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBatch(request(tt.body, tt.contentType), 3)
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Errorf("decodeBatch = %+v, want an error", got)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("decodeBatch error = %v, want %v", err, tt.wantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("decodeBatch: %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("decodeBatch = %+v, want %+v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// errAny stands for any decoding error in test tables.
+var errAny = errors.New("any error")
+
+func TestHandler(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memory.New()
+	handler := New(log, msgproc.New(log, store, store, time.Hour), 3)
+
+	post := func(body string) (int, Response) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		handler(rec, request(body, ""))
+
+		var res Response
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+
+		return rec.Code, res
+	}
+
+	t.Run("partial validation failure", func(t *testing.T) {
+		code, res := post(`[{"msg":"a"},{"msg":""},{}]`)
+		if code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if len(res.Items) != 3 {
+			t.Fatalf("got %d items, want 3", len(res.Items))
+		}
+
+		if res.Items[0].MsgID == 0 || res.Items[0].Error != "" {
+			t.Errorf("item 0 = %+v, want a message ID", res.Items[0])
+		}
+		for _, item := range res.Items[1:] {
+			if item.MsgID != 0 || item.Error == "" {
+				t.Errorf("item %d = %+v, want a validation error", item.Index, item)
+			}
+		}
+
+		msg, err := store.Msg(context.Background(), res.Items[0].MsgID)
+		if err != nil || msg.Content != "a" {
+			t.Errorf("stored message = %+v, %v, want content a", msg, err)
+		}
+	})
+
+	t.Run("every item invalid", func(t *testing.T) {
+		before := count(t, store)
+
+		code, res := post(`[{"msg":""},{}]`)
+		if code != http.StatusBadRequest || len(res.Items) != 2 {
+			t.Errorf("response = %d %+v, want 400 with 2 items", code, res)
+		}
+		if after := count(t, store); after != before {
+			t.Errorf("stored %d messages, want none", after-before)
+		}
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		for _, body := range []string{"", "[]"} {
+			if code, _ := post(body); code != http.StatusBadRequest {
+				t.Errorf("status for %q = %d, want %d", body, code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("too many items", func(t *testing.T) {
+		before := count(t, store)
+
+		code, res := post(`[{"msg":"a"},{"msg":"b"},{"msg":"c"},{"msg":"d"}]`)
+		if code != http.StatusBadRequest || res.Error != errTooManyItems.Error() {
+			t.Errorf("response = %d %+v, want 400 %q", code, res, errTooManyItems)
+		}
+		if after := count(t, store); after != before {
+			t.Errorf("stored %d messages, want none", after-before)
+		}
+	})
+
+	t.Run("ids in request order", func(t *testing.T) {
+		code, res := post(`[{"msg":"x"},{"msg":"y"},{"msg":"z"}]`)
+		if code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+
+		for i, want := range []string{"x", "y", "z"} {
+			msg, err := store.Msg(context.Background(), res.Items[i].MsgID)
+			if err != nil || msg.Content != want {
+				t.Errorf("item %d = %+v, %v, want content %s", i, msg, err, want)
+			}
+		}
+	})
+}
+
+func count(t *testing.T, store *memory.Storage) int {
+	t.Helper()
+
+	page, err := store.ListMsgs(context.Background(), models.MsgFilter{Limit: 1000})
+	if err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+
+	return len(page.Msgs)
+}
@@ -0,0 +1,79 @@
+package get
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubProvider returns msg, or err when it is set.
+type stubProvider struct {
+	msg *models.Message
+	err error
+}
+
+func (p stubProvider) Msg(_ context.Context, msgID int64) (*models.Message, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if msgID != p.msg.ID {
+		return nil, storage.ErrMsgNotFound
+	}
+
+	return p.msg, nil
+}
+
+func TestHandler(t *testing.T) {
+	msg := &models.Message{ID: 42, Content: "hello", Status: models.StatusCompleted}
+
+	tests := []struct {
+		name     string
+		id       string
+		err      error
+		wantCode int
+		wantMsg  *models.Message
+	}{
+		{name: "found", id: "42", wantCode: http.StatusOK, wantMsg: msg},
+		{name: "non-numeric id", id: "abc", wantCode: http.StatusBadRequest},
+		{name: "zero id", id: "0", wantCode: http.StatusBadRequest},
+		{name: "missing message", id: "7", wantCode: http.StatusNotFound},
+		{name: "storage failure", id: "42", err: errors.New("connection refused"), wantCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			router.Get("/api/v1/msg/{id}", New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubProvider{msg: msg, err: tt.err}))
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/msg/"+tt.id, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			var res Response
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+
+			if tt.wantMsg == nil {
+				if res.Msg != nil || res.Error == "" {
+					t.Errorf("response = %+v, want an error and no message", res)
+				}
+				return
+			}
+			if res.Msg == nil || res.Msg.ID != tt.wantMsg.ID || res.Msg.Content != tt.wantMsg.Content || res.Msg.Status != tt.wantMsg.Status {
+				t.Errorf("message = %+v, want %+v", res.Msg, tt.wantMsg)
+			}
+		})
+	}
+}
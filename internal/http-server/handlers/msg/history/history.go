@@ -0,0 +1,70 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	resp "msgproc/internal/lib/api/response"
+	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/storage"
+	"net/http"
+	"strconv"
+)
+
+type Response struct {
+	resp.Response
+	History []models.StatusHistoryEntry `json:"history"`
+}
+
+type HistoryProvider interface {
+	MsgHistory(ctx context.Context, msgID int64) ([]models.StatusHistoryEntry, error)
+}
+
+func New(log *slog.Logger, provider HistoryProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "http-server.handlers.msg.history.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil || msgID <= 0 {
+			log.Error("invalid message id", slog.String("id", chi.URLParam(r, "id")))
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid message id"))
+
+			return
+		}
+
+		history, err := provider.MsgHistory(r.Context(), msgID)
+		if err != nil {
+			if errors.Is(err, storage.ErrMsgNotFound) {
+				log.Info("message not found", slog.Int64("msgID", msgID))
+
+				w.WriteHeader(http.StatusNotFound)
+				render.JSON(w, r, resp.Error("message not found"))
+
+				return
+			}
+
+			log.Error("failed to get message history", sl.Err(err))
+
+			w.WriteHeader(http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to get message history"))
+
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			History:  history,
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stubProvider holds the history of message 42.
+type stubProvider struct {
+	history []models.StatusHistoryEntry
+	err     error
+}
+
+func (p stubProvider) MsgHistory(_ context.Context, msgID int64) ([]models.StatusHistoryEntry, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if msgID != 42 {
+		return nil, storage.ErrMsgNotFound
+	}
+
+	return p.history, nil
+}
+
+func TestHandler(t *testing.T) {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	partition, offset := int32(3), int64(17)
+
+	history := []models.StatusHistoryEntry{
+		{ID: 1, MsgID: 42, NewStatus: models.StatusNew, Actor: models.ActorAPI, CreatedAt: created},
+		{ID: 2, MsgID: 42, OldStatus: models.StatusNew, NewStatus: models.StatusQueued, Actor: models.ActorRelay, CreatedAt: created.Add(time.Second)},
+		{
+			ID: 5, MsgID: 42, OldStatus: models.StatusQueued, NewStatus: models.StatusFailed, Actor: models.ActorConsumer,
+			Reason: "empty content", Partition: &partition, Offset: &offset, CreatedAt: created.Add(2 * time.Second),
+		},
+	}
+
+	tests := []struct {
+		name        string
+		id          string
+		err         error
+		wantCode    int
+		wantHistory []models.StatusHistoryEntry
+	}{
+		{name: "entries oldest first", id: "42", wantCode: http.StatusOK, wantHistory: history},
+		{name: "non-numeric id", id: "abc", wantCode: http.StatusBadRequest},
+		{name: "negative id", id: "-1", wantCode: http.StatusBadRequest},
+		{name: "unknown message", id: "7", wantCode: http.StatusNotFound},
+		{name: "storage failure", id: "42", err: errors.New("connection refused"), wantCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			router.Get("/api/v1/msg/{id}/history", New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubProvider{history: history, err: tt.err}))
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/msg/"+tt.id+"/history", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			var res Response
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+
+			if tt.wantHistory == nil {
+				if len(res.History) != 0 || res.Error == "" {
+					t.Errorf("response = %+v, want an error and no history", res)
+				}
+				return
+			}
+			if !reflect.DeepEqual(res.History, tt.wantHistory) {
+				t.Errorf("history = %+v, want %+v", res.History, tt.wantHistory)
+			}
+		})
+	}
+}
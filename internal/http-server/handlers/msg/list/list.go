@@ -0,0 +1,137 @@
+package list
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	resp "msgproc/internal/lib/api/response"
+	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/storage"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Response struct {
+	resp.Response
+	models.MsgPage
+}
+
+type MsgLister interface {
+	ListMsgs(ctx context.Context, filter models.MsgFilter) (*models.MsgPage, error)
+}
+
+func New(log *slog.Logger, lister MsgLister, defaultPageSize, maxPageSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "http-server.handlers.msg.list.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("request_id", middleware.GetReqID(r.Context())),
+		)
+
+		filter, err := parseFilter(r.URL.Query(), defaultPageSize, maxPageSize)
+		if err != nil {
+			log.Error("invalid query", sl.Err(err))
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		page, err := lister.ListMsgs(r.Context(), filter)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) {
+				log.Error("invalid cursor", sl.Err(err))
+
+				w.WriteHeader(http.StatusBadRequest)
+				render.JSON(w, r, resp.Error("invalid cursor"))
+
+				return
+			}
+
+			log.Error("failed to list messages", sl.Err(err))
+
+			w.WriteHeader(http.StatusInternalServerError)
+			render.JSON(w, r, resp.Error("failed to list messages"))
+
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			MsgPage:  *page,
+		})
+	}
+}
+
+func parseFilter(q url.Values, defaultPageSize, maxPageSize int) (models.MsgFilter, error) {
+	filter := models.MsgFilter{
+		ContentPrefix: q.Get("prefix"),
+		Cursor:        q.Get("cursor"),
+		Limit:         defaultPageSize,
+	}
+
+	if v := q.Get("status"); v != "" {
+		filter.Statuses = strings.Split(v, ",")
+		for _, status := range filter.Statuses {
+			if !models.IsValidStatus(status) {
+				return filter, fmt.Errorf("invalid status: %s", status)
+			}
+		}
+	}
+
+	times := []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"created_from", &filter.CreatedFrom},
+		{"created_to", &filter.CreatedTo},
+		{"updated_from", &filter.UpdatedFrom},
+		{"updated_to", &filter.UpdatedTo},
+	}
+	for _, t := range times {
+		v := q.Get(t.name)
+		if v == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: expected RFC 3339 time", t.name)
+		}
+		*t.dst = &parsed
+	}
+
+	switch v := q.Get("sort"); v {
+	case "", models.SortByID, models.SortByCreatedAt, models.SortByUpdatedAt:
+		filter.SortBy = v
+	default:
+		return filter, fmt.Errorf("invalid sort: %s", v)
+	}
+
+	switch v := q.Get("order"); v {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return filter, fmt.Errorf("invalid order: %s", v)
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		filter.Limit = min(limit, maxPageSize)
+	}
+
+	return filter, nil
+}
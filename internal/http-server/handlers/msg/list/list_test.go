@@ -0,0 +1,197 @@
+package list
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/cursor"
+	"msgproc/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    models.MsgFilter
+		wantErr bool
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  models.MsgFilter{Limit: defaultPageSize},
+		},
+		{
+			name:  "single status",
+			query: "status=completed",
+			want:  models.MsgFilter{Statuses: []string{models.StatusCompleted}, Limit: defaultPageSize},
+		},
+		{
+			name:  "several statuses",
+			query: "status=new,failed",
+			want:  models.MsgFilter{Statuses: []string{models.StatusNew, models.StatusFailed}, Limit: defaultPageSize},
+		},
+		{name: "unknown status", query: "status=done", wantErr: true},
+		{name: "unknown status among valid", query: "status=new,done", wantErr: true},
+		{name: "empty status in list", query: "status=new,", wantErr: true},
+		{
+			name:  "limit",
+			query: "limit=10",
+			want:  models.MsgFilter{Limit: 10},
+		},
+		{
+			name:  "limit at max",
+			query: "limit=500",
+			want:  models.MsgFilter{Limit: maxPageSize},
+		},
+		{
+			name:  "limit above max is capped",
+			query: "limit=100000",
+			want:  models.MsgFilter{Limit: maxPageSize},
+		},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "negative limit", query: "limit=-1", wantErr: true},
+		{name: "non-numeric limit", query: "limit=ten", wantErr: true},
+		{
+			name:  "sort and order",
+			query: "sort=updated_at&order=desc",
+			want:  models.MsgFilter{SortBy: models.SortByUpdatedAt, Desc: true, Limit: defaultPageSize},
+		},
+		{name: "unknown sort", query: "sort=content", wantErr: true},
+		{name: "unknown order", query: "order=up", wantErr: true},
+		{name: "bad time", query: "created_from=yesterday", wantErr: true},
+		{
+			name:  "prefix and cursor are passed through",
+			query: "prefix=abc&cursor=xyz",
+			want:  models.MsgFilter{ContentPrefix: "abc", Cursor: "xyz", Limit: defaultPageSize},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery: %v", err)
+			}
+
+			got, err := parseFilter(q, defaultPageSize, maxPageSize)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseFilter(%q) = %+v, want an error", tt.query, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFilter(%q): %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFilter(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterTimes(t *testing.T) {
+	q := url.Values{"created_from": {"2024-05-01T10:00:00Z"}, "updated_to": {"2024-05-02T10:00:00+02:00"}}
+
+	got, err := parseFilter(q, defaultPageSize, maxPageSize)
+	if err != nil {
+		t.Fatalf("parseFilter: %v", err)
+	}
+	if got.CreatedFrom == nil || got.CreatedFrom.Format("2006-01-02T15:04:05Z07:00") != "2024-05-01T10:00:00Z" {
+		t.Errorf("CreatedFrom = %v, want 2024-05-01T10:00:00Z", got.CreatedFrom)
+	}
+	if got.UpdatedTo == nil || got.UpdatedTo.UTC().Hour() != 8 {
+		t.Errorf("UpdatedTo = %v, want 08:00 UTC", got.UpdatedTo)
+	}
+	if got.CreatedTo != nil || got.UpdatedFrom != nil {
+		t.Errorf("unset times = %v, %v, want nil", got.CreatedTo, got.UpdatedFrom)
+	}
+}
+
+func TestCursors(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	for _, content := range []string{"a", "b", "c"} {
+		if _, err := store.SaveMsg(ctx, models.NewMsg{Content: content}); err != nil {
+			t.Fatalf("SaveMsg: %v", err)
+		}
+	}
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), store, defaultPageSize, maxPageSize)
+
+	list := func(query string) (int, Response) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/msg?"+query, nil))
+
+		var res Response
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+
+		return rec.Code, res
+	}
+
+	code, first := list("limit=2")
+	if code != http.StatusOK || len(first.Msgs) != 2 || first.NextCursor == "" {
+		t.Fatalf("first page = %d %+v, want 2 messages and a cursor", code, first)
+	}
+
+	code, second := list("limit=2&cursor=" + url.QueryEscape(first.NextCursor))
+	if code != http.StatusOK || len(second.Msgs) != 1 || second.Msgs[0].Content != "c" || second.NextCursor != "" {
+		t.Errorf("second page = %d %+v, want the last message and no cursor", code, second)
+	}
+
+	c, err := cursor.Decode(first.NextCursor)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	reordered := c
+	reordered.Desc = !c.Desc
+	resorted := c
+	resorted.SortBy = models.SortByCreatedAt
+
+	bad := []struct {
+		name  string
+		query string
+	}{
+		{"garbage", "cursor=garbage"},
+		{"truncated", "cursor=" + url.QueryEscape(first.NextCursor[:len(first.NextCursor)-4])},
+		{"zero id", "cursor=" + cursor.Encode(cursor.Cursor{SortBy: c.SortBy})},
+		{"other order", "cursor=" + cursor.Encode(reordered)},
+		{"other sort", "cursor=" + cursor.Encode(resorted)},
+		{"other sort in query", "sort=updated_at&cursor=" + url.QueryEscape(first.NextCursor)},
+	}
+	for _, tt := range bad {
+		t.Run(tt.name, func(t *testing.T) {
+			code, res := list(tt.query)
+			if code != http.StatusBadRequest || res.Error != "invalid cursor" {
+				t.Errorf("list with a %s cursor = %d %+v, want 400 invalid cursor", tt.name, code, res)
+			}
+		})
+	}
+}
+
+func TestBadQuery(t *testing.T) {
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), memory.New(), defaultPageSize, maxPageSize)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/msg?status=bogus", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
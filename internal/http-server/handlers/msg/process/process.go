@@ -8,30 +8,61 @@ import (
 	"github.com/go-playground/validator/v10"
 	"io"
 	"log/slog"
+	"msgproc/internal/domain/models"
 	resp "msgproc/internal/lib/api/response"
 
 	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/storage"
 	"net/http"
+	"time"
 )
 
 type Request struct {
 	Msg string `json:"msg" validate:"required"`
+	// CallbackURL receives the result once the message is processed.
+	CallbackURL string `json:"callback_url" validate:"omitempty,http_url"`
 }
 
 type Response struct {
 	resp.Response
-	MsgID int64 `json:"msg_id"`
+	MsgID     int64 `json:"msg_id"`
+	Delivered bool  `json:"delivered,omitempty"`
 }
 
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLen = 255
+)
+
+// The ack query parameter selects whether the response waits for the
+// message to be acknowledged by Kafka.
+const (
+	AckNone = "none"
+	AckWait = "wait"
+)
+
 type MessageProcessor interface {
-	ProcessMsg(ctx context.Context, msg string) (int64, error)
+	ProcessMsg(ctx context.Context, msg models.NewMsg) (int64, error)
+	ProcessMsgIdempotent(ctx context.Context, msg models.NewMsg, key string) (int64, bool, error)
+}
+
+type DeliveryWaiter interface {
+	Await(ctx context.Context, msgID int64) error
 }
 
-func New(log *slog.Logger, processor MessageProcessor) http.HandlerFunc {
+func New(
+	log *slog.Logger,
+	processor MessageProcessor,
+	waiter DeliveryWaiter,
+	ackTimeout time.Duration,
+	webhooksEnabled bool,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "http-server.handlers.msg.New"
 
-		log = log.With(
+		log := log.With(
 			slog.String("op", op),
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
@@ -68,17 +99,95 @@ func New(log *slog.Logger, processor MessageProcessor) http.HandlerFunc {
 			return
 		}
 
-		msgID, err := processor.ProcessMsg(r.Context(), req.Msg)
+		if req.CallbackURL != "" && !webhooksEnabled {
+			log.Error("callback url given but webhooks are disabled")
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("webhooks are disabled"))
+
+			return
+		}
+
+		ack := r.URL.Query().Get("ack")
+		if ack != "" && ack != AckNone && ack != AckWait {
+			log.Error("invalid ack mode", slog.String("ack", ack))
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("invalid ack mode"))
+
+			return
+		}
+
+		key := r.Header.Get(HeaderIdempotencyKey)
+		if len(key) > maxIdempotencyKeyLen {
+			log.Error("idempotency key is too long")
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error("idempotency key is too long"))
+
+			return
+		}
+
+		msg := models.NewMsg{
+			Content:     req.Msg,
+			RequestID:   middleware.GetReqID(r.Context()),
+			CallbackURL: req.CallbackURL,
+		}
+
+		var (
+			msgID    int64
+			replayed bool
+		)
+		if key != "" {
+			msgID, replayed, err = processor.ProcessMsgIdempotent(r.Context(), msg, key)
+		} else {
+			msgID, err = processor.ProcessMsg(r.Context(), msg)
+		}
 		if err != nil {
+			if errors.Is(err, storage.ErrIdempotencyKeyReused) {
+				log.Error("idempotency key reused", sl.Err(err))
+
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				render.JSON(w, r, resp.Error("idempotency key was already used for a different request"))
+
+				return
+			}
+
 			log.Error("failed to process message", sl.Err(err))
 
 			w.WriteHeader(http.StatusInternalServerError)
 			render.JSON(w, r, resp.Error("failed to process message"))
+
+			return
+		}
+
+		if replayed {
+			w.Header().Set(HeaderIdempotentReplayed, "true")
+		}
+
+		if ack == AckWait {
+			ctx, cancel := context.WithTimeout(r.Context(), ackTimeout)
+			defer cancel()
+
+			if err := waiter.Await(ctx, msgID); err != nil {
+				// The message is stored and will still be published by the
+				// outbox relay, only the acknowledgement is missing.
+				log.Warn("message not acknowledged by kafka", slog.Int64("msgID", msgID), sl.Err(err))
+
+				w.WriteHeader(http.StatusAccepted)
+				render.JSON(w, r, Response{
+					Response: resp.Error("message saved but not yet delivered"),
+					MsgID:    msgID,
+				})
+
+				return
+			}
 		}
 
 		render.JSON(w, r, Response{
-			Response: resp.OK(),
-			MsgID:    msgID,
+			Response:  resp.OK(),
+			MsgID:     msgID,
+			Delivered: ack == AckWait,
 		})
 	}
 }
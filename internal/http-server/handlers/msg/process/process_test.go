@@ -0,0 +1,114 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/services/msgproc"
+	"msgproc/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type result struct {
+	code     int
+	replayed string
+	res      Response
+}
+
+func newTestHandler(t *testing.T, webhooksEnabled bool) (func(body, key string) result, *memory.Storage) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memory.New()
+	handler := New(log, msgproc.New(log, store, store, time.Hour), nil, time.Second, webhooksEnabled)
+
+	post := func(body, key string) result {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/msg", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(HeaderIdempotencyKey, key)
+		}
+
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		var res Response
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+
+		return result{code: rec.Code, replayed: rec.Header().Get(HeaderIdempotentReplayed), res: res}
+	}
+
+	return post, store
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	post, store := newTestHandler(t, false)
+
+	first := post(`{"msg":"hello"}`, "key-1")
+	if first.code != http.StatusOK || first.res.MsgID == 0 || first.replayed != "" {
+		t.Fatalf("first request = %+v, want 200 with a new message", first)
+	}
+
+	replay := post(`{"msg":"hello"}`, "key-1")
+	if replay.code != http.StatusOK || replay.res.MsgID != first.res.MsgID || replay.replayed != "true" {
+		t.Errorf("replay = %+v, want 200 with message %d and %s: true", replay, first.res.MsgID, HeaderIdempotentReplayed)
+	}
+
+	conflict := post(`{"msg":"goodbye"}`, "key-1")
+	if conflict.code != http.StatusUnprocessableEntity || conflict.res.MsgID != 0 {
+		t.Errorf("reuse with another message = %+v, want 422", conflict)
+	}
+
+	other := post(`{"msg":"hello"}`, "key-2")
+	if other.code != http.StatusOK || other.res.MsgID == first.res.MsgID || other.replayed != "" {
+		t.Errorf("same message under another key = %+v, want a new message", other)
+	}
+
+	page, err := store.ListMsgs(context.Background(), models.MsgFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+	if len(page.Msgs) != 2 {
+		t.Errorf("stored %d messages, want 2", len(page.Msgs))
+	}
+}
+
+func TestWithoutIdempotencyKey(t *testing.T) {
+	post, _ := newTestHandler(t, false)
+
+	first := post(`{"msg":"hello"}`, "")
+	second := post(`{"msg":"hello"}`, "")
+	if first.code != http.StatusOK || second.code != http.StatusOK || first.res.MsgID == second.res.MsgID {
+		t.Errorf("requests without a key = %+v, %+v, want two messages", first, second)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	post, _ := newTestHandler(t, false)
+
+	tests := []struct {
+		name string
+		body string
+		key  string
+	}{
+		{"empty body", "", ""},
+		{"invalid json", `{"msg":`, ""},
+		{"missing msg", `{}`, ""},
+		{"key too long", `{"msg":"hello"}`, strings.Repeat("k", maxIdempotencyKeyLen+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := post(tt.body, tt.key); got.code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", got.code, http.StatusBadRequest)
+			}
+		})
+	}
+}
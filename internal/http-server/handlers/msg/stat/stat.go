@@ -2,6 +2,7 @@ package stat
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
 	"log/slog"
@@ -9,18 +10,23 @@ import (
 	resp "msgproc/internal/lib/api/response"
 	"msgproc/internal/lib/logger/sl"
 	"net/http"
+	"net/url"
+	"time"
 )
 
+// defaultWindow is the time series window when the request sets no from.
+const defaultWindow = 24 * time.Hour
+
 type Response struct {
 	resp.Response
 	models.Statistics
 }
 
 type MsgStater interface {
-	Stats(ctx context.Context) (*models.Statistics, error)
+	Stats(ctx context.Context, filter models.SeriesFilter) (*models.Statistics, error)
 }
 
-func New(log *slog.Logger, stater MsgStater) http.HandlerFunc {
+func New(log *slog.Logger, stater MsgStater, maxBuckets int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "http-server.handlers.msgstat.New"
 
@@ -29,7 +35,17 @@ func New(log *slog.Logger, stater MsgStater) http.HandlerFunc {
 			slog.String("request_id", middleware.GetReqID(r.Context())),
 		)
 
-		stats, err := stater.Stats(r.Context())
+		filter, err := parseFilter(r.URL.Query(), time.Now(), maxBuckets)
+		if err != nil {
+			log.Error("invalid query", sl.Err(err))
+
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, resp.Error(err.Error()))
+
+			return
+		}
+
+		stats, err := stater.Stats(r.Context(), filter)
 		if err != nil {
 			log.Error("failed to get statistics", sl.Err(err))
 			w.WriteHeader(http.StatusInternalServerError)
@@ -42,3 +58,46 @@ func New(log *slog.Logger, stater MsgStater) http.HandlerFunc {
 		})
 	}
 }
+
+// parseFilter reads the time series window. It defaults to hourly buckets
+// over the last day.
+func parseFilter(q url.Values, now time.Time, maxBuckets int) (models.SeriesFilter, error) {
+	filter := models.SeriesFilter{
+		To:     now,
+		Bucket: models.BucketHour,
+	}
+
+	if v := q.Get("bucket"); v != "" {
+		if models.BucketDuration(v) == 0 {
+			return filter, fmt.Errorf("invalid bucket: %s", v)
+		}
+		filter.Bucket = v
+	}
+
+	if v := q.Get("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: expected RFC 3339 time")
+		}
+		filter.To = to
+	}
+
+	filter.From = filter.To.Add(-defaultWindow)
+	if v := q.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: expected RFC 3339 time")
+		}
+		filter.From = from
+	}
+
+	if !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("from must be before to")
+	}
+
+	if n := filter.Buckets(); n > maxBuckets {
+		return filter, fmt.Errorf("window has %d %s buckets, at most %d are allowed", n, filter.Bucket, maxBuckets)
+	}
+
+	return filter, nil
+}
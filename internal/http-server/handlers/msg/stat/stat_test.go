@@ -0,0 +1,195 @@
+package stat
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/services/msgstat"
+	"msgproc/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		query      string
+		maxBuckets int
+		want       models.SeriesFilter
+		wantErr    string
+	}{
+		{
+			name:       "defaults",
+			maxBuckets: 100,
+			want:       models.SeriesFilter{From: now.Add(-24 * time.Hour), To: now, Bucket: models.BucketHour},
+		},
+		{
+			name:       "window",
+			query:      "from=2024-04-30T00:00:00Z&to=2024-05-01T00:00:00Z&bucket=minute",
+			maxBuckets: 1440,
+			want: models.SeriesFilter{
+				From:   time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC),
+				To:     time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+				Bucket: models.BucketMinute,
+			},
+		},
+		{
+			name:       "to only moves the default window",
+			query:      "to=2024-04-01T00:00:00Z&bucket=day",
+			maxBuckets: 100,
+			want: models.SeriesFilter{
+				From:   time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+				To:     time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+				Bucket: models.BucketDay,
+			},
+		},
+		{name: "unknown bucket", query: "bucket=week", maxBuckets: 100, wantErr: "invalid bucket: week"},
+		{name: "bucket in upper case", query: "bucket=HOUR", maxBuckets: 100, wantErr: "invalid bucket"},
+		{name: "invalid from", query: "from=yesterday", maxBuckets: 100, wantErr: "invalid from"},
+		{name: "invalid to", query: "to=2024-05-01", maxBuckets: 100, wantErr: "invalid to"},
+		{
+			name:       "from after to",
+			query:      "from=2024-05-01T10:00:00Z&to=2024-05-01T09:00:00Z",
+			maxBuckets: 100,
+			wantErr:    "from must be before to",
+		},
+		{
+			name:       "empty window",
+			query:      "from=2024-05-01T10:00:00Z&to=2024-05-01T10:00:00Z",
+			maxBuckets: 100,
+			wantErr:    "from must be before to",
+		},
+		{
+			name:       "from after now",
+			query:      "from=2024-05-02T00:00:00Z",
+			maxBuckets: 100,
+			wantErr:    "from must be before to",
+		},
+		{
+			name:       "at the bucket cap",
+			query:      "from=2024-05-01T00:00:00Z&to=2024-05-01T01:00:00Z&bucket=minute",
+			maxBuckets: 60,
+			want: models.SeriesFilter{
+				From:   time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+				To:     time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC),
+				Bucket: models.BucketMinute,
+			},
+		},
+		{
+			name:       "over the bucket cap",
+			query:      "from=2024-05-01T00:00:00Z&to=2024-05-01T01:00:01Z&bucket=minute",
+			maxBuckets: 60,
+			wantErr:    "window has 61 minute buckets, at most 60 are allowed",
+		},
+		{
+			name:       "unaligned from counts its whole bucket",
+			query:      "from=2024-05-01T00:00:30Z&to=2024-05-01T01:00:00Z&bucket=minute",
+			maxBuckets: 59,
+			wantErr:    "window has 60 minute buckets",
+		},
+		{name: "default window over the cap", maxBuckets: 24, wantErr: "window has 25 hour buckets"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("bad test query: %v", err)
+			}
+
+			got, err := parseFilter(q, now, tt.maxBuckets)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseFilter error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseFilter: %v", err)
+			}
+			if !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) || got.Bucket != tt.want.Bucket {
+				t.Errorf("parseFilter = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := memory.New()
+	handler := New(log, msgstat.New(log, store), 100)
+
+	for _, content := range []string{"a", "b", "c"} {
+		if _, err := store.SaveMsg(context.Background(), models.NewMsg{Content: content}); err != nil {
+			t.Fatalf("SaveMsg: %v", err)
+		}
+	}
+
+	now := time.Now().UTC()
+	from := now.Add(-10 * time.Minute)
+	to := now.Add(time.Minute)
+
+	q := url.Values{}
+	q.Set("bucket", models.BucketMinute)
+	q.Set("from", from.Format(time.RFC3339))
+	q.Set("to", to.Format(time.RFC3339))
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stat?"+q.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var res Response
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	// The window is parsed at second precision.
+	filter := models.SeriesFilter{From: from.Truncate(time.Second), To: to.Truncate(time.Second), Bucket: models.BucketMinute}
+	if len(res.Series) != filter.Buckets() {
+		t.Fatalf("got %d buckets, want %d", len(res.Series), filter.Buckets())
+	}
+
+	start := filter.From.Truncate(time.Minute)
+	var created int64
+	for i, p := range res.Series {
+		if want := start.Add(time.Duration(i) * time.Minute); !p.Time.Equal(want) {
+			t.Errorf("bucket %d starts at %v, want %v", i, p.Time, want)
+		}
+
+		created += p.Created
+		if p.Time.Equal(now.Truncate(time.Minute)) {
+			continue
+		}
+		if p.Created != 0 || p.Completed != 0 || p.Failed != 0 {
+			t.Errorf("bucket %v = %+v, want an empty bucket", p.Time, p)
+		}
+	}
+	if created != 3 {
+		t.Errorf("series counts %d created messages, want 3", created)
+	}
+}
+
+func TestBadQuery(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := New(log, msgstat.New(log, memory.New()), 10)
+
+	for _, query := range []string{"bucket=week", "from=2024-05-01T10:00:00Z&to=2024-05-01T09:00:00Z", "bucket=minute"} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/stat?"+query, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status for %q = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
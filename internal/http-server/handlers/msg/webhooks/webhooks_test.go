@@ -0,0 +1,142 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+
+	// complete saves a message with a callback URL and completes it, which
+	// queues its webhook.
+	complete := func() int64 {
+		t.Helper()
+
+		msgID, err := store.SaveMsg(ctx, models.NewMsg{Content: "hello", CallbackURL: "https://example.com/hook"})
+		if err != nil {
+			t.Fatalf("SaveMsg: %v", err)
+		}
+		for _, status := range []string{models.StatusQueued, models.StatusProcessing, models.StatusCompleted} {
+			if err := store.UpdateMsgStatus(ctx, msgID, status, models.Change{Actor: "test"}); err != nil {
+				t.Fatalf("UpdateMsgStatus %s: %v", status, err)
+			}
+		}
+		return msgID
+	}
+
+	pending := complete()
+
+	retried := complete()
+	webhooks, err := store.FetchWebhooks(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("FetchWebhooks: %v", err)
+	}
+	for _, w := range webhooks {
+		if w.Payload.MsgID != retried {
+			continue
+		}
+		attempts := []struct {
+			attempt models.WebhookAttempt
+			state   string
+		}{
+			{models.WebhookAttempt{DeliveryID: w.ID, Attempt: 1, StatusCode: 503, Error: "unexpected status 503"}, models.WebhookPending},
+			{models.WebhookAttempt{DeliveryID: w.ID, Attempt: 2, StatusCode: 200}, models.WebhookDelivered},
+		}
+		for _, a := range attempts {
+			if err := store.RecordWebhookAttempt(ctx, a.attempt, a.state, 0); err != nil {
+				t.Fatalf("RecordWebhookAttempt: %v", err)
+			}
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/msg/{id}/webhooks", New(slog.New(slog.NewTextHandler(io.Discard, nil)), store))
+
+	get := func(id string) (int, Response) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/msg/"+id+"/webhooks", nil))
+
+		var res Response
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+
+		return rec.Code, res
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+		check    func(t *testing.T, res Response)
+	}{
+		{name: "invalid id", id: "abc", wantCode: http.StatusBadRequest},
+		{name: "unknown message", id: "999", wantCode: http.StatusNotFound},
+		{
+			name:     "not attempted yet",
+			id:       strconv.FormatInt(pending, 10),
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, res Response) {
+				if len(res.Deliveries) != 1 {
+					t.Fatalf("got %d deliveries, want 1", len(res.Deliveries))
+				}
+				d := res.Deliveries[0]
+				if d.State != models.WebhookPending || d.Attempts != 0 || len(d.Log) != 0 {
+					t.Errorf("delivery = %+v, want pending with an empty log", d)
+				}
+			},
+		},
+		{
+			name:     "failed then delivered",
+			id:       strconv.FormatInt(retried, 10),
+			wantCode: http.StatusOK,
+			check: func(t *testing.T, res Response) {
+				if len(res.Deliveries) != 1 {
+					t.Fatalf("got %d deliveries, want 1", len(res.Deliveries))
+				}
+				d := res.Deliveries[0]
+				if d.State != models.WebhookDelivered || d.Attempts != 2 || d.NextAttemptAt != nil {
+					t.Errorf("delivery = %+v, want delivered after 2 attempts", d)
+				}
+
+				codes := make([]int, 0, len(d.Log))
+				for _, a := range d.Log {
+					codes = append(codes, a.StatusCode)
+				}
+				if !slices.Equal(codes, []int{503, 200}) {
+					t.Errorf("log status codes = %v, want [503 200]", codes)
+				}
+				if d.Log[0].Error == "" || d.Log[1].Error != "" {
+					t.Errorf("log errors = %q, %q, want only the first attempt to fail", d.Log[0].Error, d.Log[1].Error)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, res := get(tt.id)
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", code, tt.wantCode)
+			}
+			if tt.check != nil {
+				tt.check(t, res)
+			}
+		})
+	}
+}
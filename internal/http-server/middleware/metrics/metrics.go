@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"msgproc/internal/lib/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// New records request counts and latency labelled by the matched chi route
+// pattern, so that path parameters do not blow up label cardinality.
+func New() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			t1 := time.Now()
+
+			defer func() {
+				route := "unmatched"
+				if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+					route = rctx.RoutePattern()
+				}
+
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				labels := []string{r.Method, route, strconv.Itoa(status)}
+
+				metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+				metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(t1).Seconds())
+			}()
+
+			next.ServeHTTP(ww, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
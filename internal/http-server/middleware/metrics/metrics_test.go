@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"msgproc/internal/lib/metrics"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteLabel(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(New())
+	router.Get("/api/v1/msg/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	router.Post("/api/v1/msg", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		labels []string
+	}{
+		{
+			name:   "path parameter",
+			method: http.MethodGet,
+			path:   "/api/v1/msg/42",
+			labels: []string{http.MethodGet, "/api/v1/msg/{id}", "200"},
+		},
+		{
+			name:   "status code",
+			method: http.MethodPost,
+			path:   "/api/v1/msg",
+			labels: []string{http.MethodPost, "/api/v1/msg", "202"},
+		},
+		{
+			name:   "no route",
+			method: http.MethodGet,
+			path:   "/nowhere/42",
+			labels: []string{http.MethodGet, "unmatched", "404"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := metrics.HTTPRequests.WithLabelValues(tt.labels...)
+			before := testutil.ToFloat64(counter)
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("requests labelled %v increased by %v, want 1", tt.labels, got)
+			}
+		})
+	}
+
+	raw := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/api/v1/msg/42", "200")
+	if got := testutil.ToFloat64(raw); got != 0 {
+		t.Errorf("requests labelled with the raw path = %v, want 0", got)
+	}
+}
@@ -0,0 +1,57 @@
+package backoff
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff computes exponential delays with random jitter.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Jitter is the fraction of the delay, between 0 and 1, that is randomised.
+	Jitter float64
+}
+
+// Duration returns the delay before the given retry attempt, counting from 1.
+func (b Backoff) Duration(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	mult := b.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+
+	d := float64(b.Initial) * math.Pow(mult, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if j := min(max(b.Jitter, 0), 1); j > 0 {
+		d = d*(1-j) + d*j*rand.Float64()
+	}
+
+	return time.Duration(d)
+}
+
+// Sleep waits for d or until ctx is done, whichever comes first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
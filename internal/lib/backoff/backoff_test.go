@@ -0,0 +1,108 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt", Backoff{Initial: 100 * time.Millisecond, Multiplier: 2}, 1, 100 * time.Millisecond},
+		{"second attempt", Backoff{Initial: 100 * time.Millisecond, Multiplier: 2}, 2, 200 * time.Millisecond},
+		{"fourth attempt", Backoff{Initial: 100 * time.Millisecond, Multiplier: 2}, 4, 800 * time.Millisecond},
+		{"fractional multiplier", Backoff{Initial: 100 * time.Millisecond, Multiplier: 1.5}, 3, 225 * time.Millisecond},
+		{"zero attempt counts as first", Backoff{Initial: 100 * time.Millisecond, Multiplier: 2}, 0, 100 * time.Millisecond},
+		{"negative attempt counts as first", Backoff{Initial: 100 * time.Millisecond, Multiplier: 2}, -5, 100 * time.Millisecond},
+		{"multiplier below one is constant", Backoff{Initial: 100 * time.Millisecond, Multiplier: 0.5}, 5, 100 * time.Millisecond},
+		{"zero multiplier is constant", Backoff{Initial: 100 * time.Millisecond}, 5, 100 * time.Millisecond},
+		{"below the cap", Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}, 4, 800 * time.Millisecond},
+		{"capped", Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}, 5, time.Second},
+		{"capped far out", Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}, 10_000, time.Second},
+		{"negative jitter is ignored", Backoff{Initial: 100 * time.Millisecond, Multiplier: 2, Jitter: -1}, 2, 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backoff.Duration(tt.attempt); got != tt.want {
+				t.Errorf("Duration(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDurationJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  Backoff
+		attempt  int
+		min, max time.Duration
+	}{
+		{
+			"fraction of the delay",
+			Backoff{Initial: 100 * time.Millisecond, Multiplier: 2, Jitter: 0.2},
+			3,
+			320 * time.Millisecond, 400 * time.Millisecond,
+		},
+		{
+			"applied after the cap",
+			Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2, Jitter: 0.5},
+			20,
+			500 * time.Millisecond, time.Second,
+		},
+		{
+			"clamped to the whole delay",
+			Backoff{Initial: 100 * time.Millisecond, Multiplier: 2, Jitter: 3},
+			1,
+			0, 100 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distinct := make(map[time.Duration]struct{})
+			for range 1000 {
+				got := tt.backoff.Duration(tt.attempt)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("Duration(%d) = %v, want within [%v, %v]", tt.attempt, got, tt.min, tt.max)
+				}
+				distinct[got] = struct{}{}
+			}
+
+			if len(distinct) < 2 {
+				t.Errorf("Duration(%d) returned the same delay every time, want jitter", tt.attempt)
+			}
+		})
+	}
+}
+
+func TestSleep(t *testing.T) {
+	if err := Sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("Sleep = %v, want nil", err)
+	}
+
+	if err := Sleep(context.Background(), 0); err != nil {
+		t.Errorf("Sleep(0) = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := Sleep(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("Sleep with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Sleep with a cancelled context waited for the delay")
+	}
+
+	if err := Sleep(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Sleep(0) with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
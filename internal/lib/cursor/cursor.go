@@ -0,0 +1,47 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var ErrInvalid = errors.New("invalid cursor")
+
+// Cursor is the position of the last row of a page in keyset pagination.
+// It is handed to clients as an opaque string.
+type Cursor struct {
+	SortBy string    `json:"s"`
+	Desc   bool      `json:"d,omitempty"`
+	Time   time.Time `json:"t,omitempty"`
+	ID     int64     `json:"i"`
+}
+
+func Encode(c Cursor) string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func Decode(s string) (Cursor, error) {
+	var c Cursor
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalid
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalid
+	}
+
+	if c.ID <= 0 {
+		return c, ErrInvalid
+	}
+
+	return c, nil
+}
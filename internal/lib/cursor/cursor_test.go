@@ -0,0 +1,57 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	want := Cursor{
+		SortBy: "created_at",
+		Desc:   true,
+		Time:   time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC),
+		ID:     42,
+	}
+
+	got, err := Decode(Encode(want))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !got.Time.Equal(want.Time) || got.SortBy != want.SortBy || got.Desc != want.Desc || got.ID != want.ID {
+		t.Errorf("Decode(Encode(c)) = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	raw := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	valid := Encode(Cursor{SortBy: "id", ID: 7})
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"id","i":7}`))},
+		{"not json", raw("not json")},
+		{"wrong types", raw(`{"s":1,"i":"7"}`)},
+		{"missing id", raw(`{"s":"id"}`)},
+		{"zero id", raw(`{"s":"id","i":0}`)},
+		{"negative id", raw(`{"s":"id","i":-3}`)},
+		{"truncated", valid[:len(valid)-3]},
+		{"bad time", raw(`{"s":"created_at","t":"yesterday","i":7}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.cursor); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Decode(%q) error = %v, want %v", tt.cursor, err, ErrInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+const namespace = "msgproc"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	StorageQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "query_duration_seconds",
+		Help:      "Storage call latency by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	StorageQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "query_errors_total",
+		Help:      "Number of failed storage calls by method.",
+	}, []string{"method"})
+
+	KafkaProduceDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "produce_duration_seconds",
+		Help:      "Latency of producing records to Kafka by topic.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic"})
+
+	KafkaProduceErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "kafka",
+		Name:      "produce_errors_total",
+		Help:      "Number of records Kafka failed to accept by topic.",
+	}, []string{"topic"})
+
+	ConsumerMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "messages_total",
+		Help:      "Number of records consumed by topic.",
+	}, []string{"topic"})
+
+	ProcessingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "processing_duration_seconds",
+		Help:      "Time spent handling a consumed record by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	ProcessingOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "outcomes_total",
+		Help:      "Number of consumed records by processing outcome.",
+	}, []string{"outcome"})
+)
+
+// Consumer processing outcomes.
+const (
+	OutcomeCompleted    = "completed"
+	OutcomeRejected     = "rejected"
+	OutcomeSkipped      = "skipped"
+	OutcomeIgnored      = "ignored"
+	OutcomeRetried      = "retried"
+	OutcomeDeadLettered = "dead_lettered"
+	OutcomeError        = "error"
+)
+
+// ObserveQuery records the latency and result of a storage call. It is meant
+// to be deferred with a pointer to the named error result.
+func ObserveQuery(method string, start time.Time, err *error) {
+	StorageQueryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil && *err != nil {
+		StorageQueryErrors.WithLabelValues(method).Inc()
+	}
+}
+
+type StatusCounter interface {
+	MessagesByStatus(ctx context.Context) (map[string]int64, error)
+}
+
+// RegisterStatusGauge exposes the number of messages in each status. The
+// counts are queried from storage on every scrape.
+func RegisterStatusGauge(counter StatusCounter, timeout time.Duration) {
+	prometheus.MustRegister(&statusCollector{
+		counter: counter,
+		timeout: timeout,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "messages"),
+			"Number of messages by status.",
+			[]string{"status"},
+			nil,
+		),
+	})
+}
+
+type statusCollector struct {
+	counter StatusCounter
+	timeout time.Duration
+	desc    *prometheus.Desc
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	counts, err := c.counter.MessagesByStatus(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), status)
+	}
+}
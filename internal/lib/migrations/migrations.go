@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LatestVersion returns the highest version among the up migrations in dir,
+// which are named <version>_<title>.up.sql.
+func LatestVersion(dir string) (uint, error) {
+	const op = "lib.migrations.LatestVersion"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var latest uint
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+
+		v, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		latest = max(latest, uint(v))
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,151 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// File is a registry stored in a local JSON file, for running producers and
+// consumers in separate processes without a registry server. It is not safe
+// for concurrent writers in different processes.
+type File struct {
+	mu   sync.Mutex
+	path string
+	mem  *Memory
+}
+
+type fileSchema struct {
+	ID       int      `json:"id"`
+	Schema   string   `json:"schema"`
+	Subjects []string `json:"subjects"`
+}
+
+func NewFile(path string) (*File, error) {
+	const op = "lib.schemaregistry.NewFile"
+
+	f := &File{path: path}
+	if err := f.load(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return f, nil
+}
+
+func (f *File) Register(ctx context.Context, subject string, schema string) (int, error) {
+	const op = "lib.schemaregistry.File.Register"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Pick up schemas registered by other processes before assigning an ID.
+	if err := f.load(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, _ := f.mem.Register(ctx, subject, schema)
+
+	if err := f.save(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (f *File) SchemaByID(ctx context.Context, id int) (string, error) {
+	const op = "lib.schemaregistry.File.SchemaByID"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	schema, err := f.mem.SchemaByID(ctx, id)
+	if err == nil {
+		return schema, nil
+	}
+
+	if err := f.load(); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return f.mem.SchemaByID(ctx, id)
+}
+
+func (f *File) load() error {
+	mem := NewMemory()
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		f.mem = mem
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var schemas []fileSchema
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &schemas); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range schemas {
+		mem.ids[s.Schema] = s.ID
+		mem.schemas[s.ID] = s.Schema
+		for _, subject := range s.Subjects {
+			mem.subjects[subject] = append(mem.subjects[subject], s.ID)
+		}
+	}
+
+	f.mem = mem
+
+	return nil
+}
+
+func (f *File) save() error {
+	bySchema := make(map[int]*fileSchema, len(f.mem.schemas))
+	for id, schema := range f.mem.schemas {
+		bySchema[id] = &fileSchema{ID: id, Schema: schema}
+	}
+	for subject, ids := range f.mem.subjects {
+		for _, id := range ids {
+			bySchema[id].Subjects = append(bySchema[id].Subjects, subject)
+		}
+	}
+
+	schemas := make([]fileSchema, 0, len(bySchema))
+	for _, s := range bySchema {
+		sort.Strings(s.Subjects)
+		schemas = append(schemas, *s)
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].ID < schemas[j].ID
+	})
+
+	data, err := json.MarshalIndent(schemas, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
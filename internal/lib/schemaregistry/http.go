@@ -0,0 +1,134 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// HTTP talks to a Confluent-compatible schema registry over its REST API.
+// Schemas are immutable, so lookups are cached for the life of the client.
+type HTTP struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+
+	mu      sync.RWMutex
+	schemas map[int]string
+}
+
+func NewHTTP(baseURL string, username string, password string, client *http.Client) *HTTP {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTP{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		username: username,
+		password: password,
+		client:   client,
+		schemas:  make(map[int]string),
+	}
+}
+
+func (h *HTTP) Register(ctx context.Context, subject string, schema string) (int, error) {
+	const op = "lib.schemaregistry.HTTP.Register"
+
+	body, err := json.Marshal(struct {
+		Schema string `json:"schema"`
+	}{Schema: schema})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var res struct {
+		ID int `json:"id"`
+	}
+	err = h.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", body, &res)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	h.mu.Lock()
+	h.schemas[res.ID] = schema
+	h.mu.Unlock()
+
+	return res.ID, nil
+}
+
+func (h *HTTP) SchemaByID(ctx context.Context, id int) (string, error) {
+	const op = "lib.schemaregistry.HTTP.SchemaByID"
+
+	h.mu.RLock()
+	schema, ok := h.schemas[id]
+	h.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	var res struct {
+		Schema string `json:"schema"`
+	}
+	if err := h.do(ctx, http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &res); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	h.mu.Lock()
+	h.schemas[id] = res.Schema
+	h.mu.Unlock()
+
+	return res.Schema, nil
+}
+
+func (h *HTTP) do(ctx context.Context, method string, path string, body []byte, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, h.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if h.username != "" {
+		req.SetBasicAuth(h.username, h.password)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var regErr struct {
+			ErrorCode int    `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&regErr)
+
+		// 40403 is the registry's "schema not found" error code.
+		if resp.StatusCode == http.StatusNotFound && regErr.ErrorCode == 40403 {
+			return ErrSchemaNotFound
+		}
+
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, regErr.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,124 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	var registers, lookups atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /subjects/{subject}/versions", func(w http.ResponseWriter, r *http.Request) {
+		registers.Add(1)
+
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Type") != contentType || r.PathValue("subject") != "msgs-value" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Schema string `json:"schema"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Schema != `"string"` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]int{"id": 7})
+	})
+	mux.HandleFunc("GET /schemas/ids/{id}", func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+
+		switch r.PathValue("id") {
+		case "8":
+			_ = json.NewEncoder(w).Encode(map[string]string{"schema": `"long"`})
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error_code": 50001, "message": "store error"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error_code": 40403, "message": "Schema not found"})
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := NewHTTP(srv.URL+"/", "user", "secret", srv.Client())
+
+	id, err := client.Register(ctx, "msgs-value", `"string"`)
+	if err != nil || id != 7 {
+		t.Fatalf("Register = %d, %v, want 7", id, err)
+	}
+
+	// Registered schemas are known without a lookup.
+	if schema, err := client.SchemaByID(ctx, 7); err != nil || schema != `"string"` {
+		t.Errorf("SchemaByID(7) = %q, %v, want the registered schema", schema, err)
+	}
+
+	for range 3 {
+		if schema, err := client.SchemaByID(ctx, 8); err != nil || schema != `"long"` {
+			t.Fatalf("SchemaByID(8) = %q, %v, want \"long\"", schema, err)
+		}
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("registry got %d lookups, want 1", n)
+	}
+
+	if _, err := client.SchemaByID(ctx, 9); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("SchemaByID(9) = %v, want %v", err, ErrSchemaNotFound)
+	}
+	if _, err := client.SchemaByID(ctx, 500); err == nil || errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("SchemaByID(500) = %v, want a registry error", err)
+	}
+
+	// Failed lookups are not cached.
+	_, _ = client.SchemaByID(ctx, 9)
+	if n := lookups.Load(); n != 4 {
+		t.Errorf("registry got %d lookups, want 4", n)
+	}
+
+	anonymous := NewHTTP(srv.URL, "", "", srv.Client())
+	if _, err := anonymous.Register(ctx, "msgs-value", `"string"`); err == nil {
+		t.Error("Register without credentials = nil, want an error")
+	}
+	if n := registers.Load(); n != 2 {
+		t.Errorf("registry got %d registrations, want 2", n)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	a, _ := m.Register(ctx, "a-value", `"string"`)
+	again, _ := m.Register(ctx, "a-value", `"string"`)
+	other, _ := m.Register(ctx, "b-value", `"string"`)
+	b, _ := m.Register(ctx, "a-value", `"long"`)
+
+	if a != again || a != other {
+		t.Errorf("same schema got IDs %d, %d and %d, want one ID", a, again, other)
+	}
+	if b == a {
+		t.Errorf("different schemas share ID %d", a)
+	}
+
+	if schema, err := m.SchemaByID(ctx, b); err != nil || schema != `"long"` {
+		t.Errorf("SchemaByID(%d) = %q, %v, want \"long\"", b, schema, err)
+	}
+	if _, err := m.SchemaByID(ctx, 99); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("SchemaByID(99) = %v, want %v", err, ErrSchemaNotFound)
+	}
+}
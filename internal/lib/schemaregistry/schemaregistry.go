@@ -0,0 +1,75 @@
+package schemaregistry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// Client is the subset of the Confluent schema registry API used by
+// serializers.
+type Client interface {
+	// Register adds schema under subject and returns its global ID. Registering
+	// a schema that already exists returns the existing ID.
+	Register(ctx context.Context, subject string, schema string) (int, error)
+	SchemaByID(ctx context.Context, id int) (string, error)
+}
+
+// Memory is an in-process registry. It is meant for local runs and for
+// setups where producer and consumer share a process.
+type Memory struct {
+	mu       sync.RWMutex
+	ids      map[string]int
+	schemas  map[int]string
+	subjects map[string][]int
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		ids:      make(map[string]int),
+		schemas:  make(map[int]string),
+		subjects: make(map[string][]int),
+	}
+}
+
+func (m *Memory) Register(_ context.Context, subject string, schema string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.register(subject, schema), nil
+}
+
+func (m *Memory) SchemaByID(_ context.Context, id int) (string, error) {
+	const op = "lib.schemaregistry.Memory.SchemaByID"
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	schema, ok := m.schemas[id]
+	if !ok {
+		return "", fmt.Errorf("%s: %d: %w", op, id, ErrSchemaNotFound)
+	}
+
+	return schema, nil
+}
+
+func (m *Memory) register(subject string, schema string) int {
+	id, ok := m.ids[schema]
+	if !ok {
+		id = len(m.schemas) + 1
+		m.ids[schema] = id
+		m.schemas[id] = schema
+	}
+
+	for _, v := range m.subjects[subject] {
+		if v == id {
+			return id
+		}
+	}
+	m.subjects[subject] = append(m.subjects[subject], id)
+
+	return id
+}
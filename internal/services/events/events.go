@@ -0,0 +1,377 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/storage"
+	"sync"
+	"time"
+)
+
+var ErrStopped = errors.New("event hub stopped")
+
+// Notifier announces new status history entries, including those written by
+// other instances.
+type Notifier interface {
+	// Notifications delivers the ID of every new entry as it is committed,
+	// and 0 when some may have been missed.
+	Notifications() <-chan int64
+}
+
+// Hub reads status changes from the history and fans them out to the
+// subscribers of the event stream. With a Notifier it fetches the entries it
+// is told about; without one it polls the history for entries after the last
+// one it has seen.
+//
+// History IDs are taken when a change is written but become visible when its
+// transaction commits, so a lower ID can appear after a higher one. The hub
+// keeps re-reading from the oldest missing ID for up to reorderWindow, and
+// skips the entries it has already delivered.
+type Hub struct {
+	log           *slog.Logger
+	store         storage.EventProvider
+	notifier      Notifier
+	pollInterval  time.Duration
+	reorderWindow time.Duration
+	batchSize     int
+	bufferSize    int
+
+	mu      sync.Mutex
+	subs    map[*subscription]struct{}
+	stopped bool
+}
+
+type subscription struct {
+	filter models.EventFilter
+	events chan models.StatusHistoryEntry
+}
+
+func New(
+	log *slog.Logger,
+	store storage.EventProvider,
+	notifier Notifier,
+	pollInterval time.Duration,
+	reorderWindow time.Duration,
+	batchSize int,
+	bufferSize int,
+) *Hub {
+	return &Hub{
+		log:           log,
+		store:         store,
+		notifier:      notifier,
+		pollInterval:  pollInterval,
+		reorderWindow: reorderWindow,
+		batchSize:     batchSize,
+		bufferSize:    bufferSize,
+		subs:          make(map[*subscription]struct{}),
+	}
+}
+
+// Run delivers status changes to the subscribers until ctx is cancelled,
+// then closes every subscription.
+func (h *Hub) Run(ctx context.Context) error {
+	const op = "services.events.Run"
+
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	defer h.stop()
+
+	ticker := time.NewTicker(h.pollInterval)
+	defer ticker.Stop()
+
+	// Only changes made after the hub started are streamed live; earlier
+	// ones are replayed from the history by the subscribers that ask.
+	var c *cursor
+	for {
+		last, err := h.store.LastStatusEventID(ctx)
+		if err == nil {
+			c = newCursor(last, h.reorderWindow)
+			break
+		}
+		log.Error("failed to read the last status event", sl.Err(err))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	var notifications <-chan int64
+	if h.notifier != nil {
+		notifications = h.notifier.Notifications()
+	}
+
+	// caughtUp holds the entries delivered by the last catch-up, whose
+	// notifications may still be pending.
+	caughtUp := make(map[int64]struct{})
+
+	log.Info("event hub started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("event hub stopped")
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		case id, ok := <-notifications:
+			if !ok {
+				log.Warn("status notifications closed, polling the history")
+				notifications = nil
+				continue
+			}
+
+			ids, resync := h.pending(id, notifications, caughtUp)
+			if len(ids) > 0 {
+				entries, err := h.store.StatusEvents(ctx, models.EventFilter{IDs: ids, Limit: len(ids)})
+				if err != nil {
+					log.Error("failed to read notified status events", sl.Err(err))
+				} else {
+					h.publish(c.filter(entries, true))
+					c.advance(time.Now())
+				}
+			}
+
+			if resync {
+				clear(caughtUp)
+				if err := h.catchUp(ctx, c, caughtUp); err != nil {
+					log.Error("failed to catch up on status events", sl.Err(err))
+				}
+			}
+		case <-ticker.C:
+			if notifications != nil {
+				continue
+			}
+
+			if err := h.catchUp(ctx, c, nil); err != nil {
+				log.Error("failed to poll status events", sl.Err(err))
+			}
+		}
+	}
+}
+
+// pending collects the notified IDs that are already queued, up to a batch,
+// leaving out those the last catch-up delivered. It reports whether
+// notifications may have been missed and the hub should catch up.
+func (h *Hub) pending(id int64, notifications <-chan int64, caughtUp map[int64]struct{}) ([]int64, bool) {
+	ids := make([]int64, 0, h.batchSize)
+
+	for {
+		if id == 0 {
+			return ids, true
+		}
+
+		if _, ok := caughtUp[id]; ok {
+			delete(caughtUp, id)
+		} else {
+			ids = append(ids, id)
+		}
+
+		if len(ids) == h.batchSize {
+			return ids, false
+		}
+
+		var ok bool
+		select {
+		case id, ok = <-notifications:
+			if !ok {
+				return ids, false
+			}
+		default:
+			return ids, false
+		}
+	}
+}
+
+// catchUp delivers every entry after the cursor that was not delivered yet,
+// recording them in delivered when it is set.
+func (h *Hub) catchUp(ctx context.Context, c *cursor, delivered map[int64]struct{}) error {
+	const op = "services.events.catchUp"
+
+	defer c.advance(time.Now())
+
+	afterID := c.after
+	for {
+		entries, err := h.store.StatusEvents(ctx, models.EventFilter{AfterID: afterID, Limit: h.batchSize})
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		fresh := c.filter(entries, false)
+		h.publish(fresh)
+
+		if delivered != nil {
+			for _, e := range fresh {
+				delivered[e.ID] = struct{}{}
+			}
+		}
+
+		if len(entries) < h.batchSize {
+			return nil
+		}
+		afterID = entries[len(entries)-1].ID
+	}
+}
+
+func (h *Hub) publish(entries []models.StatusHistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, e := range entries {
+		for sub := range h.subs {
+			if !sub.filter.Matches(e) {
+				continue
+			}
+
+			select {
+			case sub.events <- e:
+			default:
+				h.log.Warn("event subscriber is too slow, dropping it",
+					slog.Int64("event_id", e.ID),
+				)
+				delete(h.subs, sub)
+				close(sub.events)
+			}
+		}
+	}
+}
+
+// Subscribe starts delivering the events matching the filter that the hub
+// reads from now on, until unsubscribe is called. The channel is closed when
+// the subscriber falls too far behind or the hub stops; the subscriber then
+// catches up from the history with Since.
+func (h *Hub) Subscribe(filter models.EventFilter) (<-chan models.StatusHistoryEntry, func(), error) {
+	const op = "services.events.Subscribe"
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stopped {
+		return nil, nil, fmt.Errorf("%s: %w", op, ErrStopped)
+	}
+
+	sub := &subscription{
+		filter: filter,
+		events: make(chan models.StatusHistoryEntry, h.bufferSize),
+	}
+	h.subs[sub] = struct{}{}
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subs[sub]; ok {
+			delete(h.subs, sub)
+			close(sub.events)
+		}
+	}
+
+	return sub.events, unsubscribe, nil
+}
+
+// Since returns a batch of the events matching the filter after afterID,
+// oldest first, for subscribers to replay what they missed.
+func (h *Hub) Since(ctx context.Context, filter models.EventFilter, afterID int64) ([]models.StatusHistoryEntry, error) {
+	const op = "services.events.Since"
+
+	filter.AfterID = afterID
+	filter.Limit = h.batchSize
+
+	entries, err := h.store.StatusEvents(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+func (h *Hub) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stopped = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// cursor tracks which history entries the hub has delivered. Every entry up
+// to after was delivered or given up on; above it, seen holds the entries
+// delivered so far and when. A missing ID is waited for until the entries
+// above it have been seen for longer than window, and is then taken to
+// belong to a rolled back transaction.
+type cursor struct {
+	after  int64
+	seen   map[int64]time.Time
+	window time.Duration
+}
+
+func newCursor(after int64, window time.Duration) *cursor {
+	return &cursor{
+		after:  after,
+		seen:   make(map[int64]time.Time),
+		window: window,
+	}
+}
+
+// filter returns the entries that were not delivered yet and records them as
+// delivered. Entries at or below after are dropped unless notified, since a
+// notification is only sent for an entry once it is committed.
+func (c *cursor) filter(entries []models.StatusHistoryEntry, notified bool) []models.StatusHistoryEntry {
+	now := time.Now()
+
+	fresh := make([]models.StatusHistoryEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.ID <= c.after {
+			if notified {
+				fresh = append(fresh, e)
+			}
+			continue
+		}
+
+		if _, ok := c.seen[e.ID]; ok {
+			continue
+		}
+
+		c.seen[e.ID] = now
+		fresh = append(fresh, e)
+	}
+
+	return fresh
+}
+
+// advance moves after past the delivered entries that directly follow it,
+// and past missing IDs that were not committed within the window.
+func (c *cursor) advance(now time.Time) {
+	for len(c.seen) > 0 {
+		if _, ok := c.seen[c.after+1]; ok {
+			delete(c.seen, c.after+1)
+			c.after++
+			continue
+		}
+
+		// The gap at after+1 has existed since the oldest entry above it
+		// was seen.
+		next, oldest := int64(0), now
+		for id, at := range c.seen {
+			if next == 0 || id < next {
+				next = id
+			}
+			if at.Before(oldest) {
+				oldest = at
+			}
+		}
+
+		if now.Sub(oldest) < c.window {
+			return
+		}
+
+		c.after = next - 1
+	}
+}
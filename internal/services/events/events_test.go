@@ -0,0 +1,239 @@
+package events
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/storage/memory"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lateStore hides history entries as if their transactions had not
+// committed yet.
+type lateStore struct {
+	*memory.Storage
+
+	started chan struct{}
+	once    sync.Once
+
+	mu     sync.Mutex
+	hidden map[int64]bool
+}
+
+func newLateStore() *lateStore {
+	return &lateStore{
+		Storage: memory.New(),
+		started: make(chan struct{}),
+		hidden:  make(map[int64]bool),
+	}
+}
+
+func (s *lateStore) StatusEvents(ctx context.Context, filter models.EventFilter) ([]models.StatusHistoryEntry, error) {
+	entries, err := s.Storage.StatusEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	visible := entries[:0]
+	for _, e := range entries {
+		if !s.hidden[e.ID] {
+			visible = append(visible, e)
+		}
+	}
+
+	return visible, nil
+}
+
+func (s *lateStore) LastStatusEventID(ctx context.Context) (int64, error) {
+	defer s.once.Do(func() { close(s.started) })
+
+	return s.Storage.LastStatusEventID(ctx)
+}
+
+func (s *lateStore) setHidden(id int64, hidden bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hidden[id] = hidden
+}
+
+// change writes a status change, hidden when late is set, and returns the ID
+// of its history entry.
+func (s *lateStore) change(t *testing.T, msgID int64, status string, late bool) int64 {
+	t.Helper()
+
+	// The entry is hidden before it is written, as its transaction would be.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx := context.Background()
+
+	id, err := s.Storage.LastStatusEventID(ctx)
+	if err != nil {
+		t.Fatalf("LastStatusEventID: %v", err)
+	}
+	id++
+	s.hidden[id] = late
+
+	if err := s.UpdateMsgStatus(ctx, msgID, status, models.Change{Actor: "test"}); err != nil {
+		t.Fatalf("UpdateMsgStatus: %v", err)
+	}
+
+	return id
+}
+
+func startHub(t *testing.T, store *lateStore, reorderWindow time.Duration) <-chan models.StatusHistoryEntry {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	hub := New(log, store, nil, 5*time.Millisecond, reorderWindow, 2, 64)
+
+	events, unsubscribe, err := hub.Subscribe(models.EventFilter{})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = hub.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		unsubscribe()
+		cancel()
+		<-done
+	})
+
+	<-store.started
+
+	return events
+}
+
+func receive(t *testing.T, events <-chan models.StatusHistoryEntry, want ...int64) {
+	t.Helper()
+
+	for _, id := range want {
+		select {
+		case e := <-events:
+			if e.ID != id {
+				t.Fatalf("got event %d, want %d", e.ID, id)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for event %d", id)
+		}
+	}
+}
+
+func TestHubLateCommit(t *testing.T) {
+	store := newLateStore()
+
+	ids, err := store.SaveMsgs(context.Background(), []models.NewMsg{{Content: "a"}, {Content: "b"}})
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+	msgID, otherID := ids[0], ids[1]
+
+	events := startHub(t, store, time.Hour)
+
+	first := store.change(t, msgID, models.StatusQueued, false)
+	late := store.change(t, msgID, models.StatusProcessing, true)
+	third := store.change(t, msgID, models.StatusCompleted, false)
+
+	receive(t, events, first, third)
+
+	// The late entry commits after a higher one was delivered.
+	store.setHidden(late, false)
+	receive(t, events, late)
+
+	// Nothing is delivered twice.
+	next := store.change(t, otherID, models.StatusQueued, false)
+	receive(t, events, next)
+
+	select {
+	case e := <-events:
+		t.Fatalf("got unexpected event %d", e.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCursor(t *testing.T) {
+	entries := func(ids ...int64) []models.StatusHistoryEntry {
+		entries := make([]models.StatusHistoryEntry, 0, len(ids))
+		for _, id := range ids {
+			entries = append(entries, models.StatusHistoryEntry{ID: id})
+		}
+		return entries
+	}
+	ids := func(entries []models.StatusHistoryEntry) []int64 {
+		ids := make([]int64, 0, len(entries))
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+
+	t.Run("contiguous", func(t *testing.T) {
+		c := newCursor(10, time.Minute)
+
+		if got := ids(c.filter(entries(11, 12), false)); !slices.Equal(got, []int64{11, 12}) {
+			t.Errorf("filter = %v, want [11 12]", got)
+		}
+		c.advance(time.Now())
+
+		if c.after != 12 || len(c.seen) != 0 {
+			t.Errorf("cursor = %d %v, want 12 with nothing seen", c.after, c.seen)
+		}
+		if got := c.filter(entries(11, 12), false); len(got) != 0 {
+			t.Errorf("filter = %v, want nothing", ids(got))
+		}
+	})
+
+	t.Run("gap", func(t *testing.T) {
+		c := newCursor(10, time.Minute)
+
+		c.filter(entries(11, 13), false)
+		c.advance(time.Now())
+		if c.after != 11 {
+			t.Fatalf("after = %d, want 11", c.after)
+		}
+
+		if got := ids(c.filter(entries(12, 13, 14), false)); !slices.Equal(got, []int64{12, 14}) {
+			t.Errorf("filter = %v, want [12 14]", got)
+		}
+		c.advance(time.Now())
+		if c.after != 14 || len(c.seen) != 0 {
+			t.Errorf("cursor = %d %v, want 14 with nothing seen", c.after, c.seen)
+		}
+	})
+
+	t.Run("gap given up", func(t *testing.T) {
+		c := newCursor(10, time.Minute)
+
+		c.filter(entries(12, 13, 15), false)
+		c.advance(time.Now())
+		if c.after != 10 {
+			t.Fatalf("after = %d, want 10 within the window", c.after)
+		}
+
+		c.advance(time.Now().Add(2 * time.Minute))
+		if c.after != 15 || len(c.seen) != 0 {
+			t.Errorf("cursor = %d %v, want 15 with nothing seen", c.after, c.seen)
+		}
+
+		// A notified entry is delivered even after its gap was given up.
+		if got := ids(c.filter(entries(11), true)); !slices.Equal(got, []int64{11}) {
+			t.Errorf("filter = %v, want [11]", got)
+		}
+		if got := c.filter(entries(14), false); len(got) != 0 {
+			t.Errorf("filter = %v, want nothing", ids(got))
+		}
+	})
+}
@@ -0,0 +1,360 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	SASLMechanismPlain       = "PLAIN"
+	SASLMechanismSCRAMSHA256 = "SCRAM-SHA-256"
+	SASLMechanismSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+const (
+	ProducerModeSync  = "sync"
+	ProducerModeAsync = "async"
+)
+
+const (
+	AcksNone   = "none"
+	AcksLeader = "leader"
+	AcksAll    = "all"
+)
+
+// Config describes the Kafka cluster and the topics msgproc works with.
+type Config struct {
+	Brokers         []string
+	Topic           string
+	GroupID         string
+	DeadLetterTopic string
+	ClientID        string
+	// Version is the Kafka protocol version, such as "2.8.0".
+	Version string
+
+	SASL     SASLConfig
+	TLS      TLSConfig
+	Producer ProducerConfig
+	Consumer ConsumerConfig
+}
+
+type SASLConfig struct {
+	Enabled   bool
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+type TLSConfig struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+type ProducerConfig struct {
+	// Mode is ProducerModeSync or ProducerModeAsync. Async producers batch
+	// records for up to Linger or until BatchSize records or BatchBytes bytes
+	// are buffered.
+	Mode       string
+	Linger     time.Duration
+	BatchSize  int
+	BatchBytes int
+
+	Acks string
+	// Compression is a codec name understood by sarama: none, gzip, snappy,
+	// lz4 or zstd.
+	Compression     string
+	Idempotent      bool
+	MaxMessageBytes int
+}
+
+type ConsumerConfig struct {
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	RebalanceTimeout  time.Duration
+
+	// Workers is the number of records of a partition processed
+	// concurrently. With OrderByKey, records sharing a key are processed by
+	// the same worker in offset order.
+	Workers    int
+	OrderByKey bool
+
+	// Up to ResultBatchSize results of concurrent workers are written with
+	// one storage call, waiting at most ResultLinger for a batch to fill.
+	ResultBatchSize int
+	ResultLinger    time.Duration
+
+	// CommitInterval is the minimum time between commits of processed
+	// offsets. Offsets are also committed when the group rebalances.
+	CommitInterval time.Duration
+}
+
+// Validate checks the configuration without connecting to the cluster.
+func (c Config) Validate() error {
+	const op = "services.kafka.Config.Validate"
+
+	if _, err := c.sarama(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// sarama builds the client configuration shared by producers and consumers.
+func (c Config) sarama() (*sarama.Config, error) {
+	var errs []error
+
+	if len(c.Brokers) == 0 {
+		errs = append(errs, errors.New("no brokers configured"))
+	}
+	for _, b := range c.Brokers {
+		if strings.TrimSpace(b) == "" {
+			errs = append(errs, errors.New("empty broker address"))
+		}
+	}
+	if c.Topic == "" {
+		errs = append(errs, errors.New("topic is required"))
+	}
+	if c.GroupID == "" {
+		errs = append(errs, errors.New("group id is required"))
+	}
+	if c.DeadLetterTopic == "" {
+		errs = append(errs, errors.New("dead-letter topic is required"))
+	}
+	if c.DeadLetterTopic != "" && c.DeadLetterTopic == c.Topic {
+		errs = append(errs, errors.New("dead-letter topic must differ from topic"))
+	}
+
+	config := sarama.NewConfig()
+
+	if c.ClientID != "" {
+		config.ClientID = c.ClientID
+	}
+
+	if c.Version != "" {
+		version, err := sarama.ParseKafkaVersion(c.Version)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			config.Version = version
+		}
+	}
+
+	if err := c.SASL.apply(config); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.TLS.apply(config); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Producer.apply(config); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.Consumer.apply(config); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c SASLConfig) apply(config *sarama.Config) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Username == "" {
+		return errors.New("sasl username is required")
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = c.Username
+	config.Net.SASL.Password = c.Password
+
+	switch c.Mechanism {
+	case SASLMechanismPlain, "":
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLMechanismSCRAMSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGen: sha256.New}
+		}
+	case SASLMechanismSCRAMSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGen: sha512.New}
+		}
+	default:
+		return fmt.Errorf("unknown sasl mechanism %q", c.Mechanism)
+	}
+
+	return nil
+}
+
+func (c TLSConfig) apply(config *sarama.Config) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read tls ca file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("tls cert file and key file must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load tls key pair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	config.Net.TLS.Enable = true
+	config.Net.TLS.Config = tlsConfig
+
+	return nil
+}
+
+func (c ProducerConfig) apply(config *sarama.Config) error {
+	switch c.Mode {
+	case ProducerModeSync, "":
+	case ProducerModeAsync:
+		if c.Linger < 0 || c.BatchSize < 0 || c.BatchBytes < 0 {
+			return errors.New("producer linger and batch sizes must not be negative")
+		}
+
+		config.Producer.Flush.Frequency = c.Linger
+		config.Producer.Flush.Messages = c.BatchSize
+		config.Producer.Flush.Bytes = c.BatchBytes
+	default:
+		return fmt.Errorf("unknown producer mode %q", c.Mode)
+	}
+
+	switch c.Acks {
+	case AcksAll, "":
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	case AcksLeader:
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	case AcksNone:
+		config.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		return fmt.Errorf("unknown acks value %q", c.Acks)
+	}
+
+	if c.Compression != "" {
+		if err := config.Producer.Compression.UnmarshalText([]byte(c.Compression)); err != nil {
+			return err
+		}
+	}
+
+	if c.MaxMessageBytes < 0 {
+		return errors.New("max message bytes must not be negative")
+	}
+	if c.MaxMessageBytes > 0 {
+		config.Producer.MaxMessageBytes = c.MaxMessageBytes
+	}
+
+	if c.Idempotent {
+		if config.Producer.RequiredAcks != sarama.WaitForAll {
+			return errors.New("idempotent producer requires acks=all")
+		}
+
+		config.Producer.Idempotent = true
+		config.Net.MaxOpenRequests = 1
+	}
+
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+
+	return nil
+}
+
+func (c ConsumerConfig) apply(config *sarama.Config) error {
+	if c.Workers < 0 {
+		return errors.New("consumer workers must not be negative")
+	}
+	if c.ResultBatchSize > 1 && c.ResultLinger <= 0 {
+		return errors.New("consumer result linger must be positive when results are batched")
+	}
+	if c.CommitInterval < 0 {
+		return errors.New("consumer commit interval must not be negative")
+	}
+
+	if c.SessionTimeout > 0 {
+		config.Consumer.Group.Session.Timeout = c.SessionTimeout
+	}
+	if c.HeartbeatInterval > 0 {
+		config.Consumer.Group.Heartbeat.Interval = c.HeartbeatInterval
+	}
+	if c.RebalanceTimeout > 0 {
+		config.Consumer.Group.Rebalance.Timeout = c.RebalanceTimeout
+	}
+
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	// Offsets are committed by the handler once records are processed.
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	return nil
+}
+
+// scramClient adapts xdg-go/scram to sarama.SCRAMClient.
+type scramClient struct {
+	hashGen scram.HashGeneratorFcn
+	conv    *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGen.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.conv = client.NewConversation()
+
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
+}
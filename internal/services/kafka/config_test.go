@@ -0,0 +1,251 @@
+package kafka
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/IBM/sarama"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig() Config {
+	return Config{
+		Brokers:         []string{"localhost:9092"},
+		Topic:           testTopic,
+		GroupID:         "msgproc",
+		DeadLetterTopic: testDeadLetterTopic,
+	}
+}
+
+// writeKeyPair writes a self-signed certificate and its key to dir.
+func writeKeyPair(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "msgproc test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir)
+	notPEM := filepath.Join(dir, "not.pem")
+	writeFile(t, notPEM, []byte("not a certificate"))
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"no brokers", func(c *Config) { c.Brokers = nil }, "no brokers configured"},
+		{"blank broker", func(c *Config) { c.Brokers = append(c.Brokers, " ") }, "empty broker address"},
+		{"no topic", func(c *Config) { c.Topic = "" }, "topic is required"},
+		{"no group", func(c *Config) { c.GroupID = "" }, "group id is required"},
+		{"no dead-letter topic", func(c *Config) { c.DeadLetterTopic = "" }, "dead-letter topic is required"},
+		{"dead-letter topic is the topic", func(c *Config) { c.DeadLetterTopic = c.Topic }, "dead-letter topic must differ"},
+		{"bad version", func(c *Config) { c.Version = "two" }, "invalid version"},
+
+		{"sasl without username", func(c *Config) {
+			c.SASL = SASLConfig{Enabled: true, Mechanism: SASLMechanismPlain, Password: "secret"}
+		}, "sasl username is required"},
+		{"unknown sasl mechanism", func(c *Config) {
+			c.SASL = SASLConfig{Enabled: true, Mechanism: "GSSAPI", Username: "user"}
+		}, `unknown sasl mechanism "GSSAPI"`},
+		{"lowercase sasl mechanism", func(c *Config) {
+			c.SASL = SASLConfig{Enabled: true, Mechanism: "scram-sha-256", Username: "user"}
+		}, "unknown sasl mechanism"},
+
+		{"tls cert without key", func(c *Config) {
+			c.TLS = TLSConfig{Enabled: true, CertFile: certFile}
+		}, "must be set together"},
+		{"tls key without cert", func(c *Config) {
+			c.TLS = TLSConfig{Enabled: true, KeyFile: keyFile}
+		}, "must be set together"},
+		{"tls key pair swapped", func(c *Config) {
+			c.TLS = TLSConfig{Enabled: true, CertFile: keyFile, KeyFile: certFile}
+		}, "failed to load tls key pair"},
+		{"missing tls ca file", func(c *Config) {
+			c.TLS = TLSConfig{Enabled: true, CAFile: filepath.Join(dir, "missing.pem")}
+		}, "failed to read tls ca file"},
+		{"tls ca file without certificates", func(c *Config) {
+			c.TLS = TLSConfig{Enabled: true, CAFile: notPEM}
+		}, "no certificates found"},
+		{"sasl and tls both invalid", func(c *Config) {
+			c.SASL = SASLConfig{Enabled: true}
+			c.TLS = TLSConfig{Enabled: true, CertFile: certFile}
+		}, "sasl username is required\ntls cert file and key file must be set together"},
+
+		{"unknown producer mode", func(c *Config) { c.Producer.Mode = "fire-and-forget" }, "unknown producer mode"},
+		{"negative linger", func(c *Config) {
+			c.Producer = ProducerConfig{Mode: ProducerModeAsync, Linger: -time.Millisecond}
+		}, "must not be negative"},
+		{"unknown acks", func(c *Config) { c.Producer.Acks = "some" }, "unknown acks value"},
+		{"unknown compression", func(c *Config) { c.Producer.Compression = "brotli" }, "brotli"},
+		{"negative max message bytes", func(c *Config) { c.Producer.MaxMessageBytes = -1 }, "max message bytes"},
+		{"idempotent without acks=all", func(c *Config) {
+			c.Producer = ProducerConfig{Acks: AcksLeader, Idempotent: true}
+		}, "idempotent producer requires acks=all"},
+
+		{"negative workers", func(c *Config) { c.Consumer.Workers = -1 }, "consumer workers"},
+		{"batched results without linger", func(c *Config) { c.Consumer.ResultBatchSize = 10 }, "result linger must be positive"},
+		{"negative commit interval", func(c *Config) { c.Consumer.CommitInterval = -time.Second }, "commit interval"},
+		{"heartbeat above session timeout", func(c *Config) {
+			c.Consumer.SessionTimeout = 6 * time.Second
+			c.Consumer.HeartbeatInterval = 10 * time.Second
+		}, "Heartbeat.Interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(&c)
+
+			if _, err := c.sarama(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("sarama() error = %v, want %q", err, tt.want)
+			}
+			if err := c.Validate(); err == nil {
+				t.Error("Validate = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestConfigApplied(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir)
+
+	c := validConfig()
+	c.ClientID = "msgproc-test"
+	c.Version = "2.8.0"
+	c.SASL = SASLConfig{Enabled: true, Mechanism: SASLMechanismSCRAMSHA512, Username: "user", Password: "secret"}
+	c.TLS = TLSConfig{Enabled: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile}
+	c.Producer = ProducerConfig{
+		Mode:            ProducerModeAsync,
+		Linger:          5 * time.Millisecond,
+		BatchSize:       100,
+		BatchBytes:      1 << 20,
+		Compression:     "zstd",
+		Idempotent:      true,
+		MaxMessageBytes: 2 << 20,
+	}
+	c.Consumer = ConsumerConfig{
+		SessionTimeout:    20 * time.Second,
+		HeartbeatInterval: 2 * time.Second,
+		RebalanceTimeout:  40 * time.Second,
+		Workers:           4,
+		ResultBatchSize:   10,
+		ResultLinger:      time.Millisecond,
+	}
+
+	config, err := c.sarama()
+	if err != nil {
+		t.Fatalf("sarama(): %v", err)
+	}
+
+	if config.ClientID != "msgproc-test" || config.Version != sarama.V2_8_0_0 {
+		t.Errorf("client = %s %s, want msgproc-test 2.8.0", config.ClientID, config.Version)
+	}
+
+	if !config.Net.SASL.Enable || config.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 ||
+		config.Net.SASL.User != "user" || config.Net.SASL.Password != "secret" {
+		t.Errorf("sasl = %+v, want SCRAM-SHA-512 as user", config.Net.SASL)
+	}
+	if config.Net.SASL.SCRAMClientGeneratorFunc == nil {
+		t.Error("no scram client for SCRAM-SHA-512")
+	}
+
+	if !config.Net.TLS.Enable || config.Net.TLS.Config.RootCAs == nil || len(config.Net.TLS.Config.Certificates) != 1 {
+		t.Errorf("tls = %+v, want the ca and the client certificate", config.Net.TLS)
+	}
+
+	if config.Producer.Flush.Frequency != 5*time.Millisecond || config.Producer.Flush.Messages != 100 ||
+		config.Producer.Flush.Bytes != 1<<20 {
+		t.Errorf("flush = %+v, want the configured batching", config.Producer.Flush)
+	}
+	if config.Producer.RequiredAcks != sarama.WaitForAll || !config.Producer.Idempotent || config.Net.MaxOpenRequests != 1 {
+		t.Errorf("producer = acks %d idempotent %v, want an idempotent producer with acks=all", config.Producer.RequiredAcks, config.Producer.Idempotent)
+	}
+	if config.Producer.Compression != sarama.CompressionZSTD || config.Producer.MaxMessageBytes != 2<<20 {
+		t.Errorf("producer = %s %d, want zstd and 2 MiB records", config.Producer.Compression, config.Producer.MaxMessageBytes)
+	}
+
+	if config.Consumer.Group.Session.Timeout != 20*time.Second ||
+		config.Consumer.Group.Heartbeat.Interval != 2*time.Second ||
+		config.Consumer.Group.Rebalance.Timeout != 40*time.Second {
+		t.Errorf("group = %+v, want the configured timeouts", config.Consumer.Group)
+	}
+	if config.Consumer.Offsets.Initial != sarama.OffsetOldest {
+		t.Errorf("initial offset = %d, want oldest", config.Consumer.Offsets.Initial)
+	}
+	if config.Consumer.Offsets.AutoCommit.Enable {
+		t.Error("offsets are auto-committed, want them committed after processing")
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	config, err := validConfig().sarama()
+	if err != nil {
+		t.Fatalf("sarama(): %v", err)
+	}
+
+	if config.Net.SASL.Enable || config.Net.TLS.Enable {
+		t.Error("sasl or tls enabled without being configured")
+	}
+	if config.Producer.RequiredAcks != sarama.WaitForAll || config.Producer.Idempotent {
+		t.Errorf("producer = acks %d idempotent %v, want acks=all", config.Producer.RequiredAcks, config.Producer.Idempotent)
+	}
+	if !config.Producer.Return.Successes || !config.Producer.Return.Errors || !config.Consumer.Return.Errors {
+		t.Error("producer or consumer does not return results")
+	}
+	if config.Consumer.Offsets.Initial != sarama.OffsetOldest || config.Consumer.Offsets.AutoCommit.Enable {
+		t.Errorf("offsets = %+v, want oldest without auto-commit", config.Consumer.Offsets)
+	}
+
+	plain := validConfig()
+	plain.SASL = SASLConfig{Enabled: true, Username: "user", Password: "secret"}
+	config, err = plain.sarama()
+	if err != nil || config.Net.SASL.Mechanism != sarama.SASLTypePlaintext {
+		t.Errorf("sasl without a mechanism = %v, want PLAIN", err)
+	}
+}
@@ -0,0 +1,97 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/IBM/sarama"
+	"log/slog"
+	"strconv"
+)
+
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderError             = "x-error"
+	HeaderAttempts          = "x-attempts"
+)
+
+// deadLetter republishes a record that cannot be processed to the dead-letter
+// topic, keeping its key, value and headers and describing where it came from
+// and why it failed.
+func (k *Receiver) deadLetter(msg *sarama.ConsumerMessage, cause error, attempts int) error {
+	const op = "services.kafka.deadLetter"
+
+	dlqMsg := &sarama.ProducerMessage{
+		Topic: k.deadLetterTopic,
+		Value: sarama.ByteEncoder(msg.Value),
+		Headers: withHeaders(msg.Headers, append(originHeaders(msg),
+			header(HeaderError, cause.Error()),
+			header(HeaderAttempts, strconv.Itoa(attempts)),
+		)...),
+	}
+	if msg.Key != nil {
+		dlqMsg.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	partition, offset, err := k.producer.SendMessage(dlqMsg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	k.log.Warn("message sent to dead-letter topic",
+		slog.String("op", op),
+		slog.String("topic", k.deadLetterTopic),
+		slog.Int("partition", int(partition)),
+		slog.Int64("offset", offset),
+		slog.Int64("original_offset", msg.Offset),
+		slog.String("error", cause.Error()),
+	)
+
+	return nil
+}
+
+// originHeaders describes where a record was first consumed from. Records
+// coming from the retry topic already carry these headers and keep them.
+func originHeaders(msg *sarama.ConsumerMessage) []sarama.RecordHeader {
+	if headerValue(msg, HeaderOriginalTopic) != "" {
+		return nil
+	}
+
+	return []sarama.RecordHeader{
+		header(HeaderOriginalTopic, msg.Topic),
+		header(HeaderOriginalPartition, strconv.Itoa(int(msg.Partition))),
+		header(HeaderOriginalOffset, strconv.FormatInt(msg.Offset, 10)),
+	}
+}
+
+func header(key, value string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(key), Value: []byte(value)}
+}
+
+// withHeaders copies the headers of a consumed record, replacing any whose key
+// is set again in overrides.
+func withHeaders(headers []*sarama.RecordHeader, overrides ...sarama.RecordHeader) []sarama.RecordHeader {
+	replaced := make(map[string]bool, len(overrides))
+	for _, h := range overrides {
+		replaced[string(h.Key)] = true
+	}
+
+	out := make([]sarama.RecordHeader, 0, len(headers)+len(overrides))
+	for _, h := range headers {
+		if h != nil && !replaced[string(h.Key)] {
+			out = append(out, *h)
+		}
+	}
+
+	return append(out, overrides...)
+}
+
+func headerValue(msg *sarama.ConsumerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"msgproc/internal/domain/models"
+	"strconv"
+	"time"
+)
+
+const (
+	// SchemaVersion is the version of Envelope written by this build.
+	SchemaVersion = 1
+
+	ContentTypeText = "text/plain"
+)
+
+const (
+	HeaderSchemaVersion = "schema-version"
+	HeaderRequestID     = "request-id"
+	HeaderContentType   = "content-type"
+	HeaderFormat        = "format"
+)
+
+// Envelope is the value of a record on the messages topic.
+type Envelope struct {
+	Version     int       `json:"version" avro:"version"`
+	MsgID       int64     `json:"msg_id" avro:"msg_id"`
+	Content     string    `json:"content" avro:"content"`
+	CreatedAt   time.Time `json:"created_at" avro:"created_at"`
+	ContentType string    `json:"content_type" avro:"content_type"`
+}
+
+// newProducerMessage wraps an outbox entry in an envelope. The message ID is
+// used as the record key so that all records of a message share a partition.
+func newProducerMessage(
+	ctx context.Context,
+	topic string,
+	serializer Serializer,
+	e models.OutboxMsg,
+) (*sarama.ProducerMessage, error) {
+	value, err := serializer.Serialize(ctx, Envelope{
+		Version:     SchemaVersion,
+		MsgID:       e.MsgID,
+		Content:     e.Content,
+		CreatedAt:   e.CreatedAt,
+		ContentType: ContentTypeText,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	headers := []sarama.RecordHeader{
+		header(HeaderSchemaVersion, strconv.Itoa(SchemaVersion)),
+		header(HeaderContentType, ContentTypeText),
+		header(HeaderFormat, serializer.Format()),
+	}
+	if e.RequestID != "" {
+		headers = append(headers, header(HeaderRequestID, e.RequestID))
+	}
+
+	return &sarama.ProducerMessage{
+		Topic:    topic,
+		Key:      sarama.StringEncoder(strconv.FormatInt(e.MsgID, 10)),
+		Value:    sarama.ByteEncoder(value),
+		Headers:  headers,
+		Metadata: e.ID,
+	}, nil
+}
+
+// decodeEnvelope reads the value of a consumed record with the serializer
+// named in its format header, defaulting to JSON. JSON records without a
+// schema-version header are also accepted in the legacy {"msg", "msgID"}
+// format written by earlier releases.
+func (k *Receiver) decodeEnvelope(ctx context.Context, msg *sarama.ConsumerMessage) (Envelope, error) {
+	format := headerValue(msg, HeaderFormat)
+	if format == "" {
+		format = FormatJSON
+	}
+
+	serializer, ok := k.serializers[format]
+	if !ok {
+		return Envelope{}, fmt.Errorf("unsupported message format %q", format)
+	}
+
+	v := headerValue(msg, HeaderSchemaVersion)
+	if v == "" && format == FormatJSON {
+		return decodeLegacy(msg.Value)
+	}
+
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("invalid schema version %q", v)
+	}
+	if version < 1 || version > SchemaVersion {
+		return Envelope{}, fmt.Errorf("unsupported schema version %d", version)
+	}
+
+	env, err := serializer.Deserialize(ctx, msg.Value)
+	if err != nil {
+		return env, err
+	}
+
+	return env, validateEnvelope(env)
+}
+
+func decodeLegacy(value []byte) (Envelope, error) {
+	var env Envelope
+
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return env, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	if _, ok := fields["msgID"]; !ok {
+		env, err := jsonSerializer{}.Deserialize(context.Background(), value)
+		if err != nil {
+			return env, err
+		}
+
+		return env, validateEnvelope(env)
+	}
+
+	content, ok := fields["msg"].(string)
+	if !ok {
+		return env, errors.New("message content missing 'msg' field")
+	}
+
+	rawID, ok := fields["msgID"].(json.Number)
+	if !ok {
+		return env, errors.New("message content missing 'msgID' field")
+	}
+
+	msgID, err := rawID.Int64()
+	if err != nil {
+		return env, fmt.Errorf("invalid 'msgID' field: %w", err)
+	}
+
+	return Envelope{
+		MsgID:       msgID,
+		Content:     content,
+		ContentType: ContentTypeText,
+	}, nil
+}
+
+func validateEnvelope(env Envelope) error {
+	if env.MsgID <= 0 {
+		return errors.New("message envelope missing 'msg_id' field")
+	}
+
+	return nil
+}
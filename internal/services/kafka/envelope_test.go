@@ -0,0 +1,178 @@
+package kafka
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"msgproc/internal/lib/metrics"
+	"msgproc/internal/storage/memory"
+	"testing"
+	"time"
+)
+
+func TestDecodeLegacy(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Envelope
+		wantErr bool
+	}{
+		{
+			name:  "legacy shape",
+			value: `{"msg":"hello","msgID":42}`,
+			want:  Envelope{MsgID: 42, Content: "hello", ContentType: ContentTypeText},
+		},
+		{
+			name:  "legacy shape with extra fields",
+			value: `{"msgID":7,"msg":"hi","sent_by":"v0.3"}`,
+			want:  Envelope{MsgID: 7, Content: "hi", ContentType: ContentTypeText},
+		},
+		{
+			name:  "id above float64 precision",
+			value: `{"msg":"big","msgID":9007199254740993}`,
+			want:  Envelope{MsgID: 9007199254740993, Content: "big", ContentType: ContentTypeText},
+		},
+		{
+			name:  "largest id",
+			value: `{"msg":"max","msgID":9223372036854775807}`,
+			want:  Envelope{MsgID: 9223372036854775807, Content: "max", ContentType: ContentTypeText},
+		},
+		{
+			name:  "envelope without version header",
+			value: `{"version":1,"msg_id":5,"content":"env","created_at":"2024-05-01T10:00:00Z","content_type":"text/plain"}`,
+			want: Envelope{
+				Version:     1,
+				MsgID:       5,
+				Content:     "env",
+				CreatedAt:   time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+				ContentType: ContentTypeText,
+			},
+		},
+		{name: "id overflowing int64", value: `{"msg":"x","msgID":9223372036854775808}`, wantErr: true},
+		{name: "fractional id", value: `{"msg":"x","msgID":1.5}`, wantErr: true},
+		{name: "string id", value: `{"msg":"x","msgID":"42"}`, wantErr: true},
+		{name: "null id", value: `{"msg":"x","msgID":null}`, wantErr: true},
+		{name: "missing msg", value: `{"msgID":42}`, wantErr: true},
+		{name: "non-string msg", value: `{"msg":5,"msgID":42}`, wantErr: true},
+		{name: "envelope without id", value: `{"content":"x"}`, wantErr: true},
+		{name: "empty object", value: `{}`, wantErr: true},
+		{name: "array", value: `[1,2]`, wantErr: true},
+		{name: "truncated", value: `{"msg":"x","msgID":`, wantErr: true},
+		{name: "not json", value: `hello`, wantErr: true},
+		{name: "empty", value: ``, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeLegacy([]byte(tt.value))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodeLegacy = %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("decodeLegacy: %v", err)
+			}
+			if !got.CreatedAt.Equal(tt.want.CreatedAt) {
+				t.Errorf("decodeLegacy created at = %v, want %v", got.CreatedAt, tt.want.CreatedAt)
+			}
+			got.CreatedAt, tt.want.CreatedAt = time.Time{}, time.Time{}
+			if got != tt.want {
+				t.Errorf("decodeLegacy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeEnvelopeHeaders(t *testing.T) {
+	r := &Receiver{serializers: map[string]Serializer{FormatJSON: jsonSerializer{}}}
+	current := envelopeRecord(t, 3, "hello", 0).Value
+
+	tests := []struct {
+		name    string
+		value   string
+		headers map[string]string
+		wantID  int64
+		wantErr bool
+	}{
+		{name: "current version", value: string(current), headers: map[string]string{HeaderSchemaVersion: "1"}, wantID: 3},
+		{name: "legacy without headers", value: `{"msg":"hi","msgID":8}`, wantID: 8},
+		{name: "legacy with json format", value: `{"msg":"hi","msgID":8}`, headers: map[string]string{HeaderFormat: FormatJSON}, wantID: 8},
+		{name: "newer version", value: string(current), headers: map[string]string{HeaderSchemaVersion: "2"}, wantErr: true},
+		{name: "zero version", value: string(current), headers: map[string]string{HeaderSchemaVersion: "0"}, wantErr: true},
+		{name: "invalid version", value: string(current), headers: map[string]string{HeaderSchemaVersion: "v1"}, wantErr: true},
+		{name: "unknown format", value: string(current), headers: map[string]string{HeaderFormat: "xml"}, wantErr: true},
+		{name: "legacy shape with version header", value: `{"msg":"hi","msgID":8}`, headers: map[string]string{HeaderSchemaVersion: "1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{Value: []byte(tt.value)}
+			for k, v := range tt.headers {
+				msg.Headers = append(msg.Headers, &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+			}
+
+			env, err := r.decodeEnvelope(context.Background(), msg)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodeEnvelope = %+v, want an error", env)
+				}
+				return
+			}
+
+			if err != nil || env.MsgID != tt.wantID {
+				t.Errorf("decodeEnvelope = %+v, %v, want message %d", env, err, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestMalformedRecordDeadLettered(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"not json", `hello`},
+		{"id overflowing int64", `{"msg":"x","msgID":9223372036854775808}`},
+		{"missing msg", `{"msgID":42}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, producer := newTestHandler(t, memory.New(), RetryPolicy{MaxAttempts: 3}, failingPipeline(nil))
+			producer.expect(1)
+
+			record := &sarama.ConsumerMessage{Topic: testTopic, Partition: 1, Offset: 9, Value: []byte(tt.value)}
+
+			outcome, err := h.handleMessage(context.Background(), record)
+			if err != nil || outcome != metrics.OutcomeDeadLettered {
+				t.Fatalf("handleMessage = %q, %v, want %q", outcome, err, metrics.OutcomeDeadLettered)
+			}
+
+			dead := producer.last(t)
+			if dead.Topic != testDeadLetterTopic {
+				t.Errorf("record sent to %q, want %q", dead.Topic, testDeadLetterTopic)
+			}
+			if value, _ := dead.Value.Encode(); string(value) != tt.value {
+				t.Errorf("dead-letter value = %q, want the record as consumed %q", value, tt.value)
+			}
+
+			headers := producedHeaders(dead)
+			want := map[string]string{
+				HeaderAttempts:          "1",
+				HeaderOriginalTopic:     testTopic,
+				HeaderOriginalPartition: "1",
+				HeaderOriginalOffset:    "9",
+			}
+			for k, v := range want {
+				if len(headers[k]) != 1 || headers[k][0] != v {
+					t.Errorf("dead-letter header %s = %q, want [%q]", k, headers[k], v)
+				}
+			}
+			if len(headers[HeaderError]) != 1 || headers[HeaderError][0] == "" {
+				t.Errorf("dead-letter header %s = %q, want the decoding error", HeaderError, headers[HeaderError])
+			}
+		})
+	}
+}
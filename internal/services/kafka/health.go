@@ -0,0 +1,86 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// CheckBrokers refreshes the producer metadata for the topic and verifies
+// that every partition has a reachable leader.
+func (k *Sender) CheckBrokers(ctx context.Context) error {
+	const op = "services.kafka.CheckBrokers"
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- k.checkTopic()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return nil
+	}
+}
+
+func (k *Sender) checkTopic() error {
+	if err := k.client.RefreshMetadata(k.topic); err != nil {
+		return err
+	}
+
+	partitions, err := k.client.Partitions(k.topic)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partitions {
+		if _, err := k.client.Leader(k.topic, p); err != nil {
+			return fmt.Errorf("partition %d: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckMembership reports whether the receiver currently holds a consumer
+// group session.
+func (k *Receiver) CheckMembership(context.Context) error {
+	const op = "services.kafka.CheckMembership"
+
+	if !k.membership.joined() {
+		return fmt.Errorf("%s: %w", op, errors.New("not a member of the consumer group"))
+	}
+
+	return nil
+}
+
+type membership struct {
+	mu       sync.RWMutex
+	memberID string
+}
+
+func (m *membership) join(memberID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.memberID = memberID
+}
+
+func (m *membership) leave() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.memberID = ""
+}
+
+func (m *membership) joined() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.memberID != ""
+}
@@ -0,0 +1,24 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckMembership(t *testing.T) {
+	r := &Receiver{}
+
+	if err := r.CheckMembership(context.Background()); err == nil {
+		t.Error("CheckMembership before joining = nil, want an error")
+	}
+
+	r.membership.join("member-1")
+	if err := r.CheckMembership(context.Background()); err != nil {
+		t.Errorf("CheckMembership after joining = %v, want nil", err)
+	}
+
+	r.membership.leave()
+	if err := r.CheckMembership(context.Background()); err == nil {
+		t.Error("CheckMembership after leaving = nil, want an error")
+	}
+}
@@ -2,52 +2,83 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/IBM/sarama"
 	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/backoff"
 	"msgproc/internal/lib/logger/sl"
-	"strings"
+	"msgproc/internal/lib/metrics"
+	"msgproc/internal/services/pipeline"
+	"msgproc/internal/storage"
+	"sync"
+	"time"
 )
 
 type MsgReceiver interface {
-	ProcessMessages(ctx context.Context, msgUpdater MessageUpdater) error
-}
-
-type MessageUpdater interface {
-	UpdateMsgStatus(ctx context.Context, msgID int64, status string) error
-	UpdateMsg(ctx context.Context, msgID int64, msg string) error
+	ProcessMessages(ctx context.Context, msgUpdater storage.MsgUpdater) error
 }
 
 type Sender struct {
-	producer sarama.SyncProducer
-	topic    string
-	log      *slog.Logger
+	client     sarama.Client
+	producer   sarama.SyncProducer
+	async      sarama.AsyncProducer
+	dispatched chan struct{}
+	topic      string
+	serializer Serializer
+	log        *slog.Logger
 }
 
-func NewKafkaSender(brokers []string, topic string, log *slog.Logger) (*Sender, error) {
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
-	config.Producer.Return.Errors = true
+func NewKafkaSender(cfg Config, serializer Serializer, log *slog.Logger) (*Sender, error) {
+	const op = "services.kafka.NewKafkaSender"
+
+	config, err := cfg.sarama()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
-	producer, err := sarama.NewSyncProducer(brokers, config)
+	client, err := sarama.NewClient(cfg.Brokers, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Sender{
-		producer: producer,
-		topic:    topic,
-		log:      log,
-	}, nil
+	k := &Sender{
+		client:     client,
+		topic:      cfg.Topic,
+		serializer: serializer,
+		log:        log,
+	}
+
+	if cfg.Producer.Mode == ProducerModeAsync {
+		k.async, err = sarama.NewAsyncProducerFromClient(client)
+		if err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+
+		k.dispatched = make(chan struct{})
+		go k.dispatch()
+
+		return k, nil
+	}
+
+	k.producer, err = sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return k, nil
 }
 
-func (k *Sender) SendMsg(ctx context.Context, msg string, msgID int64) error {
+func (k *Sender) SendMsg(ctx context.Context, msg models.OutboxMsg) error {
 	const op = "services.kafka.SendMsg"
 
 	log := k.log.With(
 		slog.String("op", op),
-		slog.Int64("msgID", msgID),
+		slog.Int64("msgID", msg.MsgID),
+		slog.String("request_id", msg.RequestID),
 	)
 	select {
 	case <-ctx.Done():
@@ -57,66 +88,184 @@ func (k *Sender) SendMsg(ctx context.Context, msg string, msgID int64) error {
 	default:
 	}
 
-	messageContent := map[string]interface{}{
-		"msg":   msg,
-		"msgID": msgID,
-	}
-	messageBytes, err := json.Marshal(messageContent)
-	if err != nil {
-		log.Error("failed to marshal message", sl.Err(err))
+	d := k.Publish(ctx, msg)
+	if err := d.Wait(ctx); err != nil {
+		log.Error("failed to send message to kafka", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	message := &sarama.ProducerMessage{
-		Topic: k.topic,
-		Value: sarama.ByteEncoder(messageBytes),
+	log.Info("message sent to kafka",
+		slog.Int("partition", int(d.Partition())),
+		slog.Int64("offset", d.Offset()),
+	)
+
+	return nil
+}
+
+// SendMsgs publishes outbox entries as a single producer batch. Entries that
+// Kafka rejected are returned in failed, keyed by outbox entry ID.
+func (k *Sender) SendMsgs(ctx context.Context, entries []models.OutboxMsg) (failed map[int64]error, err error) {
+	const op = "services.kafka.SendMsgs"
+
+	log := k.log.With(
+		slog.String("op", op),
+		slog.Int("count", len(entries)),
+	)
+	select {
+	case <-ctx.Done():
+		err := ctx.Err()
+		log.Error("context cancelled", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	default:
+	}
+
+	if k.async != nil {
+		return k.sendAsync(ctx, entries)
+	}
+
+	failed = make(map[int64]error)
+	messages := make([]*sarama.ProducerMessage, 0, len(entries))
+	for _, e := range entries {
+		message, err := newProducerMessage(ctx, k.topic, k.serializer, e)
+		if err != nil {
+			failed[e.ID] = err
+			continue
+		}
+
+		messages = append(messages, message)
 	}
 
-	partition, offset, err := k.producer.SendMessage(message)
+	if len(messages) == 0 {
+		return failed, nil
+	}
+
+	start := time.Now()
+	err = k.producer.SendMessages(messages)
+	metrics.KafkaProduceDuration.WithLabelValues(k.topic).Observe(time.Since(start).Seconds())
 	if err != nil {
-		log.Error("failed to send message to kafka", sl.Err(err))
-		return fmt.Errorf("%s: %w", op, err)
+		var producerErrs sarama.ProducerErrors
+		if !errors.As(err, &producerErrs) {
+			metrics.KafkaProduceErrors.WithLabelValues(k.topic).Add(float64(len(messages)))
+			log.Error("failed to send messages to kafka", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		metrics.KafkaProduceErrors.WithLabelValues(k.topic).Add(float64(len(producerErrs)))
+
+		for _, pe := range producerErrs {
+			if id, ok := pe.Msg.Metadata.(int64); ok {
+				failed[id] = pe.Err
+			}
+		}
 	}
 
-	log.Info("message sent to kafka",
-		slog.Int("partition", int(partition)),
-		slog.Int64("offset", offset),
+	log.Info("messages sent to kafka",
+		slog.Int("failed", len(failed)),
 	)
 
-	return nil
+	return failed, nil
 }
 
 type Receiver struct {
-	consumerGroup sarama.ConsumerGroup
-	topic         string
-	log           *slog.Logger
+	membership      membership
+	consumerGroup   sarama.ConsumerGroup
+	producer        sarama.SyncProducer
+	topic           string
+	deadLetterTopic string
+	retry           RetryPolicy
+	pipeline        *pipeline.Pipeline
+	serializers     map[string]Serializer
+	workers         int
+	orderByKey      bool
+	resultBatchSize int
+	resultLinger    time.Duration
+	commitInterval  time.Duration
+	log             *slog.Logger
 }
 
-func NewKafkaReceiver(log *slog.Logger, brokers []string, topic string, groupID string) (*Receiver, error) {
-	config := sarama.NewConfig()
-	config.Consumer.Return.Errors = true
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	config.Consumer.Offsets.AutoCommit.Enable = false
-	config.Version = sarama.V0_10_2_0
+func NewKafkaReceiver(
+	log *slog.Logger,
+	cfg Config,
+	retry RetryPolicy,
+	pipe *pipeline.Pipeline,
+	serializers []Serializer,
+) (*Receiver, error) {
+	const op = "services.kafka.NewKafkaReceiver"
+
+	if err := retry.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if retry.Mode == RetryModeTopic && (retry.Topic == cfg.Topic || retry.Topic == cfg.DeadLetterTopic) {
+		return nil, fmt.Errorf("%s: retry topic must differ from topic and dead-letter topic", op)
+	}
+
+	config, err := cfg.sarama()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
-	consumerGroup, err := sarama.NewConsumerGroup(brokers, groupID, config)
+	consumerGroup, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID, config)
 	if err != nil {
 		return nil, err
 	}
 
+	// Dead-lettered and retried records are dropped from the source topic
+	// once produced, so they are always written with acks from all replicas.
+	producerConfig, err := cfg.sarama()
+	if err != nil {
+		_ = consumerGroup.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, producerConfig)
+	if err != nil {
+		_ = consumerGroup.Close()
+		return nil, err
+	}
+
+	byFormat := make(map[string]Serializer, len(serializers))
+	for _, s := range serializers {
+		byFormat[s.Format()] = s
+	}
+
 	return &Receiver{
-		consumerGroup: consumerGroup,
-		topic:         topic,
-		log:           log,
+		consumerGroup:   consumerGroup,
+		producer:        producer,
+		topic:           cfg.Topic,
+		deadLetterTopic: cfg.DeadLetterTopic,
+		retry:           retry,
+		pipeline:        pipe,
+		serializers:     byFormat,
+		workers:         max(cfg.Consumer.Workers, 1),
+		orderByKey:      cfg.Consumer.OrderByKey,
+		resultBatchSize: cfg.Consumer.ResultBatchSize,
+		resultLinger:    cfg.Consumer.ResultLinger,
+		commitInterval:  cfg.Consumer.CommitInterval,
+		log:             log,
 	}, nil
 }
 
-func (k *Receiver) ProcessMessages(ctx context.Context, msgUpdater MessageUpdater) error {
+func (k *Receiver) ProcessMessages(ctx context.Context, msgUpdater storage.MsgUpdater) error {
 	const op = "services.kafka.ProcessMessages"
 
+	results := newResultBatcher(msgUpdater, k.resultBatchSize, k.resultLinger)
+
+	batcherCtx, stopBatcher := context.WithCancel(ctx)
+	defer stopBatcher()
+
+	go results.Run(batcherCtx)
+
 	handler := &consumerGroupHandler{
 		receiver:   k,
 		msgUpdater: msgUpdater,
+		results:    results,
+	}
+
+	topics := []string{k.topic}
+	if k.retry.Mode == RetryModeTopic {
+		topics = append(topics, k.retry.Topic)
 	}
 
 	for {
@@ -126,7 +275,7 @@ func (k *Receiver) ProcessMessages(ctx context.Context, msgUpdater MessageUpdate
 		default:
 		}
 
-		if err := k.consumerGroup.Consume(ctx, []string{k.topic}, handler); err != nil {
+		if err := k.consumerGroup.Consume(ctx, topics, handler); err != nil {
 			k.log.Error("failed to consume messages", sl.Err(err))
 			return fmt.Errorf("%s: %w", op, err)
 		}
@@ -135,87 +284,330 @@ func (k *Receiver) ProcessMessages(ctx context.Context, msgUpdater MessageUpdate
 
 type consumerGroupHandler struct {
 	receiver   *Receiver
-	msgUpdater MessageUpdater
+	msgUpdater storage.MsgUpdater
+	results    *resultBatcher
 }
 
-func (h *consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+func (h *consumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.receiver.membership.join(session.MemberID())
 	return nil
 }
 
-func (h *consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+// Cleanup commits the offsets marked since the last periodic commit, so
+// that the next owner of the partitions does not process them again.
+func (h *consumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	h.receiver.membership.leave()
+	session.Commit()
 	return nil
 }
 
+// ConsumeClaim hands the records of a partition to a pool of workers. The
+// offset is only marked up to the last record before the oldest one still in
+// flight, so a crash or rebalance never skips an unprocessed record.
 func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	log := h.receiver.log.With(slog.String("op", "services.kafka.ConsumeClaim"))
+	const op = "services.kafka.ConsumeClaim"
 
-	for {
-		select {
-		case msg := <-claim.Messages():
+	log := h.receiver.log.With(
+		slog.String("op", op),
+		slog.String("topic", claim.Topic()),
+		slog.Int("partition", int(claim.Partition())),
+	)
 
-			select {
-			case <-session.Context().Done():
-				return session.Context().Err()
-			default:
-			}
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+
+	workers := h.receiver.workers
+
+	queues := make([]chan *trackedMsg, 1)
+	if h.receiver.orderByKey {
+		queues = make([]chan *trackedMsg, workers)
+	}
+	for i := range queues {
+		queues[i] = make(chan *trackedMsg)
+	}
 
-			log.Info("message received from kafka",
-				slog.String("msg", string(msg.Value)),
-				slog.Int64("offset", msg.Offset),
-			)
+	tracker := newOffsetTracker(session, h.receiver.commitInterval)
 
-			var messageContent map[string]interface{}
-			err := json.Unmarshal(msg.Value, &messageContent)
-			if err != nil {
-				log.Error("failed to unmarshal message", sl.Err(err))
-				continue
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for i := range workers {
+		queue := queues[i%len(queues)]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for t := range queue {
+				if err := h.consume(ctx, t.msg); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+
+				tracker.complete(t)
 			}
+		}()
+	}
 
-			msgStr, ok := messageContent["msg"].(string)
+	var next int
+dispatch:
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
 			if !ok {
-				log.Error("message content missing 'msg' field")
-				continue
+				break dispatch
 			}
 
-			msgIDFloat, ok := messageContent["msgID"].(float64)
-			if !ok {
-				log.Error("message content missing 'msgID' field")
-				continue
+			queue := queues[0]
+			if len(queues) > 1 {
+				if msg.Key != nil {
+					queue = queues[keyWorker(msg.Key, len(queues))]
+				} else {
+					queue = queues[next%len(queues)]
+					next++
+				}
 			}
-			msgID := int64(msgIDFloat)
 
-			log.Info("processing message",
-				slog.String("msg", msgStr),
-				slog.Int64("msgID", msgID),
-			)
+			t := tracker.add(msg)
 
-			trimmedMsg := strings.TrimSpace(msgStr)
+			select {
+			case queue <- t:
+			case <-ctx.Done():
+				break dispatch
+			}
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
 
-			err = h.msgUpdater.UpdateMsg(session.Context(), msgID, trimmedMsg)
-			if err != nil {
-				log.Error("failed to update message", sl.Err(err))
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		// The offset must not move past a record that is neither processed
+		// nor handed over to another topic, so the session is aborted and
+		// the record is redelivered after the rebalance.
+		log.Error("failed to handle message", sl.Err(firstErr))
+		return fmt.Errorf("%s: %w", op, firstErr)
+	}
 
-				err = h.msgUpdater.UpdateMsgStatus(session.Context(), msgID, "failed")
-				if err != nil {
-					log.Error("failed to update message status after processing", sl.Err(err))
-					continue
-				}
+	return session.Context().Err()
+}
 
-				continue
-			}
+// consume processes one record and records its metrics.
+func (h *consumerGroupHandler) consume(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	h.receiver.log.Info("message received from kafka",
+		slog.String("op", "services.kafka.consume"),
+		slog.String("msg", string(msg.Value)),
+		slog.String("topic", msg.Topic),
+		slog.Int64("offset", msg.Offset),
+	)
 
-			err = h.msgUpdater.UpdateMsgStatus(session.Context(), msgID, "completed")
-			if err != nil {
-				log.Error("failed to update message status after processing", sl.Err(err))
-				continue
+	metrics.ConsumerMessages.WithLabelValues(msg.Topic).Inc()
+
+	start := time.Now()
+	outcome, err := h.handleMessage(ctx, msg)
+	if err != nil {
+		outcome = metrics.OutcomeError
+	}
+	metrics.ProcessingOutcomes.WithLabelValues(outcome).Inc()
+	metrics.ProcessingDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+// handleMessage processes a single record, retrying transient failures, and
+// reports the outcome. It returns an error only when the record could not be
+// processed, retried or dead-lettered, in which case its offset must not be
+// marked.
+func (h *consumerGroupHandler) handleMessage(ctx context.Context, msg *sarama.ConsumerMessage) (string, error) {
+	log := h.receiver.log.With(slog.String("op", "services.kafka.handleMessage"))
+
+	env, err := h.receiver.decodeEnvelope(ctx, msg)
+	if err != nil {
+		return metrics.OutcomeDeadLettered, h.receiver.deadLetter(msg, err, 1)
+	}
+
+	msgID, msgStr := env.MsgID, env.Content
+
+	log = log.With(
+		slog.Int64("msgID", msgID),
+		slog.String("request_id", headerValue(msg, HeaderRequestID)),
+	)
+
+	change := models.Change{
+		Actor:     models.ActorConsumer,
+		Partition: &msg.Partition,
+		Offset:    &msg.Offset,
+	}
+
+	attempt := attemptsDone(msg)
+	if attempt > 0 {
+		if err := backoff.Sleep(ctx, time.Until(retryAfter(msg))); err != nil {
+			return "", err
+		}
+	}
+
+	for {
+		attempt++
+
+		log.Info("processing message",
+			slog.String("msg", msgStr),
+			slog.Int("attempt", attempt),
+		)
+
+		outcome, err := h.processMessage(ctx, msgID, msgStr, attempt, change)
+		if err == nil {
+			log.Info("message processed", slog.String("outcome", outcome))
+			return outcome, nil
+		}
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		log.Warn("message processing attempt failed",
+			slog.Int("attempt", attempt),
+			sl.Err(err),
+		)
+
+		if attempt >= h.receiver.retry.MaxAttempts {
+			log.Error("message processing retries exhausted", sl.Err(err))
+
+			failChange := change
+			failChange.Reason = err.Error()
+
+			_, failErr := h.ignoreIllegal(msgID, h.results.Save(ctx, models.ProcessingResult{
+				MsgID:    msgID,
+				Status:   models.StatusFailed,
+				Attempts: attempt,
+				Error:    err.Error(),
+				Change:   failChange,
+			}))
+			if failErr != nil {
+				log.Error("failed to mark message as failed", sl.Err(failErr))
 			}
 
-			session.MarkMessage(msg, "")
-			log.Info("message processed",
-				slog.Int64("msgID", msgID),
-			)
-		case <-session.Context().Done():
-			return session.Context().Err()
+			return metrics.OutcomeDeadLettered, h.receiver.deadLetter(msg, err, attempt)
+		}
+
+		delay := h.receiver.retry.Backoff.Duration(attempt)
+
+		if h.receiver.retry.Mode == RetryModeTopic {
+			return metrics.OutcomeRetried, h.receiver.scheduleRetry(msg, err, attempt, delay)
 		}
+
+		if err := backoff.Sleep(ctx, delay); err != nil {
+			return "", err
+		}
+	}
+}
+
+// processMessage runs one processing attempt. Any returned error is treated as
+// transient and the attempt is retried.
+func (h *consumerGroupHandler) processMessage(
+	ctx context.Context,
+	msgID int64,
+	msgStr string,
+	attempt int,
+	change models.Change,
+) (string, error) {
+	log := h.receiver.log.With(
+		slog.String("op", "services.kafka.processMessage"),
+		slog.Int64("msgID", msgID),
+	)
+
+	startChange := change
+	startChange.Reason = fmt.Sprintf("attempt %d", attempt)
+
+	applied, err := h.ignoreIllegal(msgID, h.msgUpdater.UpdateMsgStatus(ctx, msgID, models.StatusProcessing, startChange))
+	if err != nil {
+		return "", fmt.Errorf("failed to update message status to %s: %w", models.StatusProcessing, err)
+	}
+	if !applied {
+		return metrics.OutcomeIgnored, nil
+	}
+
+	res, err := h.receiver.pipeline.Run(ctx, msgStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to run processing pipeline: %w", err)
+	}
+
+	switch res.Action {
+	case pipeline.ActionReject:
+		log.Info("message rejected by pipeline", slog.String("reason", res.Reason))
+
+		change.Reason = res.Reason
+
+		_, err := h.ignoreIllegal(msgID, h.results.Save(ctx, models.ProcessingResult{
+			MsgID:    msgID,
+			Status:   models.StatusFailed,
+			Attempts: attempt,
+			Error:    res.Reason,
+			Change:   change,
+		}))
+		if err != nil {
+			return "", fmt.Errorf("failed to mark message as failed: %w", err)
+		}
+
+		return metrics.OutcomeRejected, nil
+	case pipeline.ActionSkip:
+		log.Info("message skipped by pipeline", slog.String("reason", res.Reason))
+
+		change.Reason = res.Reason
+
+		_, err := h.ignoreIllegal(msgID, h.results.Save(ctx, models.ProcessingResult{
+			MsgID:    msgID,
+			Status:   models.StatusCancelled,
+			Attempts: attempt,
+			Change:   change,
+		}))
+		if err != nil {
+			return "", fmt.Errorf("failed to update message status to %s: %w", models.StatusCancelled, err)
+		}
+
+		return metrics.OutcomeSkipped, nil
+	}
+
+	_, err = h.ignoreIllegal(msgID, h.results.Save(ctx, models.ProcessingResult{
+		MsgID:    msgID,
+		Status:   models.StatusCompleted,
+		Content:  &res.Content,
+		Attempts: attempt,
+		Change:   change,
+	}))
+	if err != nil {
+		return "", fmt.Errorf("failed to update message status to %s: %w", models.StatusCompleted, err)
+	}
+
+	return metrics.OutcomeCompleted, nil
+}
+
+// ignoreIllegal turns a status transition the lifecycle does not allow into a
+// no-op, so that a redelivered record cannot move a finished message back.
+// Messages that no longer exist are ignored the same way. It reports whether
+// the transition was applied.
+func (h *consumerGroupHandler) ignoreIllegal(msgID int64, err error) (bool, error) {
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, storage.ErrInvalidTransition), errors.Is(err, storage.ErrMsgNotFound):
+		h.receiver.log.Info("ignoring message status change",
+			slog.String("op", "services.kafka.ignoreIllegal"),
+			slog.Int64("msgID", msgID),
+			sl.Err(err),
+		)
+
+		return false, nil
+	default:
+		return false, err
 	}
 }
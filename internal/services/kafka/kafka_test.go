@@ -0,0 +1,358 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/backoff"
+	"msgproc/internal/lib/metrics"
+	"msgproc/internal/services/pipeline"
+	"msgproc/internal/storage"
+	"msgproc/internal/storage/memory"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testTopic           = "msgs"
+	testRetryTopic      = "msgs-retry"
+	testDeadLetterTopic = "msgs-dlq"
+)
+
+// testProducer records the records sent through a mock producer.
+type testProducer struct {
+	*mocks.SyncProducer
+
+	mu   sync.Mutex
+	sent []*sarama.ProducerMessage
+}
+
+func newTestProducer(t *testing.T) *testProducer {
+	p := &testProducer{SyncProducer: mocks.NewSyncProducer(t, nil)}
+	t.Cleanup(func() {
+		_ = p.Close()
+	})
+
+	return p
+}
+
+// expect lets n more records through.
+func (p *testProducer) expect(n int) {
+	for range n {
+		p.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			p.sent = append(p.sent, msg)
+			return nil
+		})
+	}
+}
+
+func (p *testProducer) last(t *testing.T) *sarama.ProducerMessage {
+	t.Helper()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.sent) == 0 {
+		t.Fatal("no record was produced")
+	}
+
+	return p.sent[len(p.sent)-1]
+}
+
+// newTestHandler returns a handler consuming testTopic into store.
+func newTestHandler(
+	t *testing.T,
+	store storage.MsgUpdater,
+	retry RetryPolicy,
+	pipe *pipeline.Pipeline,
+) (*consumerGroupHandler, *testProducer) {
+	t.Helper()
+
+	producer := newTestProducer(t)
+
+	receiver := &Receiver{
+		producer:        producer,
+		topic:           testTopic,
+		deadLetterTopic: testDeadLetterTopic,
+		retry:           retry,
+		pipeline:        pipe,
+		serializers:     map[string]Serializer{FormatJSON: jsonSerializer{}},
+		workers:         1,
+		log:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	return &consumerGroupHandler{
+		receiver:   receiver,
+		msgUpdater: store,
+		results:    newResultBatcher(store, 1, 0),
+	}, producer
+}
+
+// envelopeRecord returns a record of testTopic carrying an envelope of msgID.
+func envelopeRecord(t *testing.T, msgID int64, content string, offset int64) *sarama.ConsumerMessage {
+	t.Helper()
+
+	value, err := jsonSerializer{}.Serialize(context.Background(), Envelope{
+		Version:     SchemaVersion,
+		MsgID:       msgID,
+		Content:     content,
+		CreatedAt:   time.Now().UTC(),
+		ContentType: ContentTypeText,
+	})
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	return &sarama.ConsumerMessage{
+		Topic:     testTopic,
+		Partition: 2,
+		Offset:    offset,
+		Key:       []byte(strconv.FormatInt(msgID, 10)),
+		Value:     value,
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(HeaderSchemaVersion), Value: []byte(strconv.Itoa(SchemaVersion))},
+			{Key: []byte(HeaderFormat), Value: []byte(FormatJSON)},
+			{Key: []byte(HeaderRequestID), Value: []byte("req-1")},
+			{Key: []byte("traceparent"), Value: []byte("00-abc-def-01")},
+		},
+	}
+}
+
+// consumed turns a produced record into the record a consumer of its topic
+// would read.
+func consumed(t *testing.T, msg *sarama.ProducerMessage, offset int64) *sarama.ConsumerMessage {
+	t.Helper()
+
+	value, err := msg.Value.Encode()
+	if err != nil {
+		t.Fatalf("encode value: %v", err)
+	}
+
+	var key []byte
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			t.Fatalf("encode key: %v", err)
+		}
+	}
+
+	headers := make([]*sarama.RecordHeader, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, &h)
+	}
+
+	return &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    offset,
+		Key:       key,
+		Value:     value,
+		Headers:   headers,
+	}
+}
+
+func producedHeaders(msg *sarama.ProducerMessage) map[string][]string {
+	headers := make(map[string][]string)
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = append(headers[string(h.Key)], string(h.Value))
+	}
+
+	return headers
+}
+
+func failingPipeline(err error) *pipeline.Pipeline {
+	return pipeline.New(pipeline.Stage{
+		Name: "fail",
+		Processor: pipeline.ProcessorFunc(func(context.Context, string) (pipeline.Result, error) {
+			return pipeline.Result{}, err
+		}),
+	})
+}
+
+func saveMsg(t *testing.T, store storage.MsgSaver, content string) int64 {
+	t.Helper()
+
+	id, err := store.SaveMsg(context.Background(), models.NewMsg{Content: content})
+	if err != nil {
+		t.Fatalf("SaveMsg: %v", err)
+	}
+
+	return id
+}
+
+func TestRetryTopicThenDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	id := saveMsg(t, store, "hello")
+
+	h, producer := newTestHandler(t, store, RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     backoff.Backoff{Initial: time.Millisecond, Multiplier: 2},
+		Mode:        RetryModeTopic,
+		Topic:       testRetryTopic,
+	}, failingPipeline(errors.New("downstream unavailable")))
+	producer.expect(3)
+
+	record := envelopeRecord(t, id, "hello", 10)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		outcome, err := h.handleMessage(ctx, record)
+		if err != nil {
+			t.Fatalf("attempt %d: handleMessage: %v", attempt, err)
+		}
+		if outcome != metrics.OutcomeRetried {
+			t.Fatalf("attempt %d: outcome = %q, want %q", attempt, outcome, metrics.OutcomeRetried)
+		}
+
+		retried := producer.last(t)
+		if retried.Topic != testRetryTopic {
+			t.Fatalf("attempt %d: record sent to %q, want %q", attempt, retried.Topic, testRetryTopic)
+		}
+
+		headers := producedHeaders(retried)
+		want := map[string]string{
+			HeaderAttempts:          strconv.Itoa(attempt),
+			HeaderOriginalTopic:     testTopic,
+			HeaderOriginalPartition: "2",
+			HeaderOriginalOffset:    "10",
+			HeaderError:             "failed to run processing pipeline: services.pipeline.Run: stage \"fail\": downstream unavailable",
+			HeaderRequestID:         "req-1",
+			"traceparent":           "00-abc-def-01",
+		}
+		for k, v := range want {
+			if len(headers[k]) != 1 || headers[k][0] != v {
+				t.Errorf("attempt %d: header %s = %q, want [%q]", attempt, k, headers[k], v)
+			}
+		}
+		if len(headers[HeaderRetryAfter]) != 1 {
+			t.Errorf("attempt %d: header %s = %q, want one value", attempt, HeaderRetryAfter, headers[HeaderRetryAfter])
+		}
+
+		record = consumed(t, retried, int64(attempt))
+	}
+
+	outcome, err := h.handleMessage(ctx, record)
+	if err != nil {
+		t.Fatalf("last attempt: handleMessage: %v", err)
+	}
+	if outcome != metrics.OutcomeDeadLettered {
+		t.Fatalf("last attempt: outcome = %q, want %q", outcome, metrics.OutcomeDeadLettered)
+	}
+
+	dead := producer.last(t)
+	if dead.Topic != testDeadLetterTopic {
+		t.Fatalf("record sent to %q, want %q", dead.Topic, testDeadLetterTopic)
+	}
+	if key, _ := dead.Key.Encode(); string(key) != strconv.FormatInt(id, 10) {
+		t.Errorf("dead-letter key = %q, want %d", key, id)
+	}
+
+	headers := producedHeaders(dead)
+	want := map[string]string{
+		HeaderAttempts:          "3",
+		HeaderOriginalTopic:     testTopic,
+		HeaderOriginalPartition: "2",
+		HeaderOriginalOffset:    "10",
+		HeaderSchemaVersion:     "1",
+		HeaderFormat:            FormatJSON,
+		HeaderRequestID:         "req-1",
+		"traceparent":           "00-abc-def-01",
+	}
+	for k, v := range want {
+		if len(headers[k]) != 1 || headers[k][0] != v {
+			t.Errorf("dead-letter header %s = %q, want [%q]", k, headers[k], v)
+		}
+	}
+
+	msg, err := store.Msg(ctx, id)
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.Status != models.StatusFailed || msg.Attempts != 3 || msg.LastError == "" {
+		t.Errorf("message = %+v, want failed after 3 attempts with the last error", msg)
+	}
+}
+
+func TestInlineRetryThenDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	id := saveMsg(t, store, "hello")
+
+	var calls int
+	pipe := pipeline.New(pipeline.Stage{
+		Name: "fail",
+		Processor: pipeline.ProcessorFunc(func(context.Context, string) (pipeline.Result, error) {
+			calls++
+			return pipeline.Result{}, errors.New("still down")
+		}),
+	})
+
+	h, producer := newTestHandler(t, store, RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     backoff.Backoff{Initial: time.Millisecond},
+		Mode:        RetryModeInline,
+	}, pipe)
+	producer.expect(1)
+
+	outcome, err := h.handleMessage(ctx, envelopeRecord(t, id, "hello", 4))
+	if err != nil {
+		t.Fatalf("handleMessage: %v", err)
+	}
+	if outcome != metrics.OutcomeDeadLettered {
+		t.Errorf("outcome = %q, want %q", outcome, metrics.OutcomeDeadLettered)
+	}
+	if calls != 3 {
+		t.Errorf("pipeline ran %d times, want 3", calls)
+	}
+
+	dead := producer.last(t)
+	headers := producedHeaders(dead)
+	if dead.Topic != testDeadLetterTopic || headers[HeaderAttempts][0] != "3" || headers[HeaderOriginalOffset][0] != "4" {
+		t.Errorf("dead letter = %s %v, want attempt 3 of offset 4", dead.Topic, headers)
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	id := saveMsg(t, store, "hello")
+
+	var calls int
+	pipe := pipeline.New(pipeline.Stage{
+		Name: "flaky",
+		Processor: pipeline.ProcessorFunc(func(_ context.Context, content string) (pipeline.Result, error) {
+			calls++
+			if calls < 2 {
+				return pipeline.Result{}, errors.New("flaky")
+			}
+			return pipeline.Result{Content: content + "!"}, nil
+		}),
+	})
+
+	h, _ := newTestHandler(t, store, RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     backoff.Backoff{Initial: time.Millisecond},
+		Mode:        RetryModeInline,
+	}, pipe)
+
+	outcome, err := h.handleMessage(ctx, envelopeRecord(t, id, "hello", 0))
+	if err != nil || outcome != metrics.OutcomeCompleted {
+		t.Fatalf("handleMessage = %q, %v, want %q", outcome, err, metrics.OutcomeCompleted)
+	}
+
+	msg, err := store.Msg(ctx, id)
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.Status != models.StatusCompleted || msg.Content != "hello!" || msg.Attempts != 2 {
+		t.Errorf("message = %+v, want completed with hello! after 2 attempts", msg)
+	}
+}
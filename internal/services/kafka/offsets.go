@@ -0,0 +1,89 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+type trackedMsg struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+// offsetTracker marks offsets of records completed out of order. Records are
+// tracked in the order they were received, and an offset is only marked once
+// every record before it has completed too. Marked offsets are committed at
+// most once per interval; the rest is committed when the session ends.
+type offsetTracker struct {
+	session  sarama.ConsumerGroupSession
+	interval time.Duration
+
+	mu        sync.Mutex
+	pending   []*trackedMsg
+	committed time.Time
+}
+
+func newOffsetTracker(session sarama.ConsumerGroupSession, interval time.Duration) *offsetTracker {
+	return &offsetTracker{
+		session:   session,
+		interval:  interval,
+		committed: time.Now(),
+	}
+}
+
+func (t *offsetTracker) add(msg *sarama.ConsumerMessage) *trackedMsg {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m := &trackedMsg{msg: msg}
+	t.pending = append(t.pending, m)
+
+	return m
+}
+
+func (t *offsetTracker) complete(m *trackedMsg) {
+	// Commit blocks on the broker, so other workers are not held up by it.
+	if t.mark(m) {
+		t.session.Commit()
+	}
+}
+
+// mark moves the marked offset past the completed prefix of pending records
+// and reports whether it is time to commit it.
+func (t *offsetTracker) mark(m *trackedMsg) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m.done = true
+
+	var last *trackedMsg
+	for len(t.pending) > 0 && t.pending[0].done {
+		last = t.pending[0]
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+	}
+
+	if last == nil {
+		return false
+	}
+
+	t.session.MarkMessage(last.msg, "")
+
+	if time.Since(t.committed) < t.interval {
+		return false
+	}
+	t.committed = time.Now()
+
+	return true
+}
+
+// keyWorker picks the worker for a record key, so that records with the same
+// key are always processed by the same worker.
+func keyWorker(key []byte, workers int) int {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSession records marked and committed offsets like a consumer group
+// session of a single partition does.
+type testSession struct {
+	sarama.ConsumerGroupSession
+
+	mu        sync.Mutex
+	marked    int64
+	committed []int64
+}
+
+func newTestSession() *testSession {
+	return &testSession{marked: -1}
+}
+
+func (s *testSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Like sarama, the next offset to read is marked.
+	s.marked = msg.Offset + 1
+}
+
+func (s *testSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.marked >= 0 {
+		s.committed = append(s.committed, s.marked)
+	}
+}
+
+func (s *testSession) MemberID() string {
+	return "member-1"
+}
+
+func (s *testSession) Context() context.Context {
+	return context.Background()
+}
+
+// lastCommitted returns the committed offset, or -1 if none was committed.
+func (s *testSession) lastCommitted() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.committed) == 0 {
+		return -1
+	}
+
+	return s.committed[len(s.committed)-1]
+}
+
+func trackRecords(tracker *offsetTracker, n int) []*trackedMsg {
+	tracked := make([]*trackedMsg, n)
+	for i := range tracked {
+		tracked[i] = tracker.add(&sarama.ConsumerMessage{Topic: testTopic, Offset: int64(i)})
+	}
+
+	return tracked
+}
+
+func TestOffsetTrackerOutOfOrder(t *testing.T) {
+	session := newTestSession()
+	tracker := newOffsetTracker(session, 0)
+	tracked := trackRecords(tracker, 6)
+
+	steps := []struct {
+		complete int
+		want     int64
+	}{
+		{complete: 2, want: -1},
+		{complete: 1, want: -1},
+		{complete: 0, want: 3},
+		{complete: 5, want: 3},
+		{complete: 3, want: 4},
+		{complete: 4, want: 6},
+	}
+
+	for _, step := range steps {
+		tracker.complete(tracked[step.complete])
+
+		if got := session.lastCommitted(); got != step.want {
+			t.Fatalf("after completing offset %d: committed %d, want %d", step.complete, got, step.want)
+		}
+	}
+
+	// Offsets only ever move forward.
+	want := []int64{3, 4, 6}
+	if !reflect.DeepEqual(session.committed, want) {
+		t.Errorf("commits = %v, want %v", session.committed, want)
+	}
+}
+
+func TestOffsetTrackerConcurrent(t *testing.T) {
+	const n = 1000
+
+	session := newTestSession()
+	tracker := newOffsetTracker(session, 0)
+	tracked := trackRecords(tracker, n)
+
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := n - 1 - w; i >= 0; i -= 8 {
+				tracker.complete(tracked[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := session.lastCommitted(); got != n {
+		t.Errorf("committed %d, want %d", got, n)
+	}
+	for i := 1; i < len(session.committed); i++ {
+		if session.committed[i] < session.committed[i-1] {
+			t.Fatalf("commits went backwards: %v", session.committed)
+		}
+	}
+}
+
+func TestOffsetTrackerCommitInterval(t *testing.T) {
+	session := newTestSession()
+	tracker := newOffsetTracker(session, time.Hour)
+	tracked := trackRecords(tracker, 3)
+
+	for _, m := range tracked {
+		tracker.complete(m)
+	}
+
+	if got := session.lastCommitted(); got != -1 {
+		t.Errorf("committed %d within the interval, want no commit", got)
+	}
+	if session.marked != 3 {
+		t.Errorf("marked %d, want 3", session.marked)
+	}
+
+	// The handler commits what is left when the session ends.
+	h := &consumerGroupHandler{receiver: &Receiver{}}
+	if err := h.Setup(session); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if err := h.Cleanup(session); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+
+	if got := session.lastCommitted(); got != 3 {
+		t.Errorf("committed %d after cleanup, want 3", got)
+	}
+}
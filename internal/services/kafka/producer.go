@@ -0,0 +1,193 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/lib/metrics"
+	"sync"
+	"time"
+)
+
+// Delivery is the pending acknowledgement of a single published record.
+type Delivery struct {
+	outboxID int64
+	start    time.Time
+	done     chan struct{}
+	once     sync.Once
+
+	partition int32
+	offset    int64
+	err       error
+}
+
+func newDelivery(outboxID int64) *Delivery {
+	return &Delivery{
+		outboxID:  outboxID,
+		start:     time.Now(),
+		done:      make(chan struct{}),
+		partition: -1,
+		offset:    -1,
+	}
+}
+
+// Done is closed once Kafka acknowledged or rejected the record.
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err returns the delivery error. It is only meaningful after Done is closed.
+func (d *Delivery) Err() error {
+	return d.err
+}
+
+func (d *Delivery) Partition() int32 {
+	return d.partition
+}
+
+func (d *Delivery) Offset() int64 {
+	return d.offset
+}
+
+// Wait blocks until the record is acknowledged or ctx is done. A cancelled
+// wait does not cancel the delivery itself.
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Delivery) resolve(partition int32, offset int64, err error) {
+	d.once.Do(func() {
+		d.partition, d.offset, d.err = partition, offset, err
+		close(d.done)
+	})
+}
+
+// Publish hands msg to the producer and returns its pending delivery. In
+// sync mode the record is sent before Publish returns; in async mode it is
+// batched with other records according to the producer linger settings.
+func (k *Sender) Publish(ctx context.Context, msg models.OutboxMsg) *Delivery {
+	d := newDelivery(msg.ID)
+
+	message, err := newProducerMessage(ctx, k.topic, k.serializer, msg)
+	if err != nil {
+		d.resolve(-1, -1, fmt.Errorf("failed to marshal message: %w", err))
+		return d
+	}
+
+	if k.async == nil {
+		partition, offset, err := k.producer.SendMessage(message)
+		k.observe(d, err)
+		d.resolve(partition, offset, err)
+
+		return d
+	}
+
+	message.Metadata = d
+
+	select {
+	case k.async.Input() <- message:
+	case <-ctx.Done():
+		d.resolve(-1, -1, ctx.Err())
+	}
+
+	return d
+}
+
+// sendAsync publishes entries through the async producer and waits for all
+// of their acknowledgements.
+func (k *Sender) sendAsync(ctx context.Context, entries []models.OutboxMsg) (map[int64]error, error) {
+	const op = "services.kafka.sendAsync"
+
+	deliveries := make([]*Delivery, 0, len(entries))
+	for _, e := range entries {
+		deliveries = append(deliveries, k.Publish(ctx, e))
+	}
+
+	failed := make(map[int64]error)
+	for _, d := range deliveries {
+		if err := d.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("%s: %w", op, ctx.Err())
+			}
+			failed[d.outboxID] = err
+		}
+	}
+
+	k.log.Info("messages sent to kafka",
+		slog.String("op", op),
+		slog.Int("count", len(entries)),
+		slog.Int("failed", len(failed)),
+	)
+
+	return failed, nil
+}
+
+// dispatch resolves deliveries as the async producer reports them.
+func (k *Sender) dispatch() {
+	defer close(k.dispatched)
+
+	successes, errs := k.async.Successes(), k.async.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+
+			if d, ok := msg.Metadata.(*Delivery); ok {
+				k.observe(d, nil)
+				d.resolve(msg.Partition, msg.Offset, nil)
+			}
+		case pe, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			if d, ok := pe.Msg.Metadata.(*Delivery); ok {
+				k.observe(d, pe.Err)
+				d.resolve(-1, -1, pe.Err)
+			}
+		}
+	}
+}
+
+func (k *Sender) observe(d *Delivery, err error) {
+	metrics.KafkaProduceDuration.WithLabelValues(k.topic).Observe(time.Since(d.start).Seconds())
+	if err != nil {
+		metrics.KafkaProduceErrors.WithLabelValues(k.topic).Inc()
+	}
+}
+
+// Close flushes buffered records and releases the producer. It must not be
+// called while messages are still being published.
+func (k *Sender) Close() error {
+	const op = "services.kafka.Close"
+
+	var err error
+	if k.async != nil {
+		err = k.async.Close()
+		<-k.dispatched
+	} else {
+		err = k.producer.Close()
+	}
+
+	if closeErr := k.client.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		k.log.Error("failed to close producer", slog.String("op", op), sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
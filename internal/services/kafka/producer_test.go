@@ -0,0 +1,222 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSyncSender returns a sync-mode sender publishing through a mock
+// producer.
+func newSyncSender(tb testing.TB) (*Sender, *mocks.SyncProducer) {
+	producer := mocks.NewSyncProducer(tb, nil)
+	tb.Cleanup(func() {
+		_ = producer.Close()
+	})
+
+	return &Sender{
+		producer:   producer,
+		topic:      testTopic,
+		serializer: jsonSerializer{},
+		log:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, producer
+}
+
+// newAsyncSender returns an async-mode sender publishing through a mock
+// producer that reports successes like the configured one does.
+func newAsyncSender(tb testing.TB) (*Sender, *mocks.AsyncProducer) {
+	config, err := validConfig().sarama()
+	if err != nil {
+		tb.Fatalf("sarama(): %v", err)
+	}
+
+	producer := mocks.NewAsyncProducer(tb, config)
+
+	k := &Sender{
+		async:      producer,
+		dispatched: make(chan struct{}),
+		topic:      testTopic,
+		serializer: jsonSerializer{},
+		log:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	go k.dispatch()
+
+	tb.Cleanup(func() {
+		_ = producer.Close()
+		<-k.dispatched
+	})
+
+	return k, producer
+}
+
+func outboxMsg(id int64) models.OutboxMsg {
+	return models.OutboxMsg{
+		ID:        id,
+		MsgID:     id,
+		Content:   strings.Repeat("x", 256),
+		CreatedAt: time.Now(),
+	}
+}
+
+func TestDeliveryAsync(t *testing.T) {
+	k, producer := newAsyncSender(t)
+	rejected := sarama.ErrMessageSizeTooLarge
+
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(rejected)
+
+	ok := k.Publish(context.Background(), outboxMsg(1))
+	failed := k.Publish(context.Background(), outboxMsg(2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ok.Wait(ctx); err != nil {
+		t.Errorf("Wait for an acknowledged record = %v, want nil", err)
+	}
+	if ok.Offset() < 0 || ok.Partition() < 0 {
+		t.Errorf("acknowledged record at %d/%d, want a partition and an offset", ok.Partition(), ok.Offset())
+	}
+
+	if err := failed.Wait(ctx); !errors.Is(err, rejected) {
+		t.Errorf("Wait for a rejected record = %v, want %v", err, rejected)
+	}
+	select {
+	case <-failed.Done():
+	default:
+		t.Error("Done is open after the delivery failed")
+	}
+	if !errors.Is(failed.Err(), rejected) || failed.Offset() != -1 || failed.Partition() != -1 {
+		t.Errorf("rejected delivery = %v at %d/%d, want %v at -1/-1", failed.Err(), failed.Partition(), failed.Offset(), rejected)
+	}
+}
+
+func TestDeliverySync(t *testing.T) {
+	k, producer := newSyncSender(t)
+	rejected := sarama.ErrNotEnoughReplicas
+
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(rejected)
+
+	if d := k.Publish(context.Background(), outboxMsg(1)); d.Err() != nil || d.Offset() < 0 {
+		t.Errorf("sync delivery = %v at offset %d, want it acknowledged on return", d.Err(), d.Offset())
+	}
+
+	d := k.Publish(context.Background(), outboxMsg(2))
+	if err := d.Wait(context.Background()); !errors.Is(err, rejected) {
+		t.Errorf("Wait for a rejected record = %v, want %v", err, rejected)
+	}
+}
+
+func TestDeliveryWait(t *testing.T) {
+	d := newDelivery(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+
+	first := errors.New("first")
+	d.resolve(-1, -1, first)
+	d.resolve(3, 10, nil)
+
+	if err := d.Wait(context.Background()); !errors.Is(err, first) || d.Offset() != -1 {
+		t.Errorf("delivery = %v at offset %d, want the first resolution", err, d.Offset())
+	}
+}
+
+func TestSendMsgsAsyncPartialFailure(t *testing.T) {
+	k, producer := newAsyncSender(t)
+
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrMessageSizeTooLarge)
+	producer.ExpectInputAndSucceed()
+
+	failed, err := k.SendMsgs(context.Background(), []models.OutboxMsg{outboxMsg(1), outboxMsg(2), outboxMsg(3)})
+	if err != nil {
+		t.Fatalf("SendMsgs: %v", err)
+	}
+	if len(failed) != 1 || !errors.Is(failed[2], sarama.ErrMessageSizeTooLarge) {
+		t.Errorf("failed = %v, want only entry 2", failed)
+	}
+}
+
+// The benchmarks measure the sender's own overhead per record against mock
+// producers; broker round trips are not included.
+
+func BenchmarkProducerSync(b *testing.B) {
+	k, producer := newSyncSender(b)
+	for range b.N {
+		producer.ExpectSendMessageAndSucceed()
+	}
+
+	ctx := context.Background()
+	msg := outboxMsg(1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		if err := k.SendMsg(ctx, msg); err != nil {
+			b.Fatalf("SendMsg: %v", err)
+		}
+	}
+}
+
+func BenchmarkProducerSyncBatch(b *testing.B) {
+	const batchSize = 100
+
+	k, producer := newSyncSender(b)
+	for range b.N {
+		producer.ExpectSendMessageAndSucceed()
+	}
+
+	ctx := context.Background()
+	batch := make([]models.OutboxMsg, batchSize)
+	for i := range batch {
+		batch[i] = outboxMsg(int64(i + 1))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for sent := 0; sent < b.N; sent += batchSize {
+		entries := batch[:min(batchSize, b.N-sent)]
+		failed, err := k.SendMsgs(ctx, entries)
+		if err != nil || len(failed) > 0 {
+			b.Fatalf("SendMsgs = %v, %v", failed, err)
+		}
+	}
+}
+
+func BenchmarkProducerAsync(b *testing.B) {
+	k, producer := newAsyncSender(b)
+	for range b.N {
+		producer.ExpectInputAndSucceed()
+	}
+
+	ctx := context.Background()
+	msg := outboxMsg(1)
+	deliveries := make([]*Delivery, 0, b.N)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		deliveries = append(deliveries, k.Publish(ctx, msg))
+	}
+	for _, d := range deliveries {
+		if err := d.Wait(ctx); err != nil {
+			b.Fatalf("Wait: %v", err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package kafka
+
+import (
+	"context"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/storage"
+	"time"
+)
+
+type resultReq struct {
+	res   models.ProcessingResult
+	errCh chan error
+}
+
+// resultBatcher collects processing results from concurrent workers and
+// writes them with a single SaveResults call once size results are pending or
+// linger has passed since the first one.
+type resultBatcher struct {
+	store  storage.MsgUpdater
+	size   int
+	linger time.Duration
+	reqs   chan resultReq
+}
+
+func newResultBatcher(store storage.MsgUpdater, size int, linger time.Duration) *resultBatcher {
+	return &resultBatcher{
+		store:  store,
+		size:   size,
+		linger: linger,
+		reqs:   make(chan resultReq),
+	}
+}
+
+// Save writes res and waits until it is committed. Without batching it is
+// written right away.
+func (b *resultBatcher) Save(ctx context.Context, res models.ProcessingResult) error {
+	if b.size <= 1 {
+		return b.store.SaveResult(ctx, res)
+	}
+
+	req := resultReq{res: res, errCh: make(chan error, 1)}
+
+	select {
+	case b.reqs <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run flushes batches until ctx is done, and then flushes the last one.
+func (b *resultBatcher) Run(ctx context.Context) {
+	if b.size <= 1 {
+		return
+	}
+
+	var (
+		batch []resultReq
+		timer *time.Timer
+		fire  <-chan time.Time
+	)
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, fire = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		b.flush(ctx, batch)
+		batch = nil
+	}
+
+	for {
+		select {
+		case req := <-b.reqs:
+			// A message has at most one result per statement, so a second
+			// result for the same message starts a new batch.
+			for _, pending := range batch {
+				if pending.res.MsgID == req.res.MsgID {
+					flush()
+					break
+				}
+			}
+
+			batch = append(batch, req)
+			if len(batch) >= b.size {
+				flush()
+				continue
+			}
+
+			if timer == nil {
+				timer = time.NewTimer(b.linger)
+				fire = timer.C
+			}
+		case <-fire:
+			timer, fire = nil, nil
+			flush()
+		case <-ctx.Done():
+			// Results already handed over are still written, so that the
+			// work done for them is not lost on shutdown.
+			ctx = context.WithoutCancel(ctx)
+			flush()
+			return
+		}
+	}
+}
+
+func (b *resultBatcher) flush(ctx context.Context, batch []resultReq) {
+	results := make([]models.ProcessingResult, len(batch))
+	for i, req := range batch {
+		results[i] = req.res
+	}
+
+	errs, err := b.store.SaveResults(ctx, results)
+	for i, req := range batch {
+		if err != nil {
+			req.errCh <- err
+			continue
+		}
+		req.errCh <- errs[i]
+	}
+}
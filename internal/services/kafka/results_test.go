@@ -0,0 +1,259 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/storage"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resultStore records the batches written through it and fails the results
+// of the messages in failing.
+type resultStore struct {
+	storage.MsgUpdater
+
+	mu      sync.Mutex
+	batches [][]int64
+	single  []int64
+	failing map[int64]error
+	err     error
+}
+
+func (s *resultStore) SaveResult(_ context.Context, res models.ProcessingResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.single = append(s.single, res.MsgID)
+
+	return s.failing[res.MsgID]
+}
+
+func (s *resultStore) SaveResults(ctx context.Context, results []models.ProcessingResult) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(results))
+	errs := make([]error, len(results))
+	for i, res := range results {
+		ids[i] = res.MsgID
+		errs[i] = s.failing[res.MsgID]
+	}
+	s.batches = append(s.batches, ids)
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return errs, nil
+}
+
+// sortedBatches returns the written batches with the IDs of each batch
+// sorted, since concurrent saves arrive in any order.
+func (s *resultStore) sortedBatches() [][]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches := make([][]int64, len(s.batches))
+	for i, b := range s.batches {
+		batches[i] = append([]int64(nil), b...)
+		sort.Slice(batches[i], func(x, y int) bool { return batches[i][x] < batches[i][y] })
+	}
+
+	return batches
+}
+
+// startBatcher runs a batcher until the test ends or stop is called.
+func startBatcher(t *testing.T, store storage.MsgUpdater, size int, linger time.Duration) (b *resultBatcher, stop func()) {
+	b = newResultBatcher(store, size, linger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Run(ctx)
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	t.Cleanup(stop)
+
+	return b, stop
+}
+
+// saveAll saves a result for each of ids concurrently and returns their
+// errors by ID.
+func saveAll(b *resultBatcher, ids ...int64) map[int64]error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[int64]error)
+	)
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := b.Save(context.Background(), models.ProcessingResult{MsgID: id, Status: models.StatusCompleted})
+
+			mu.Lock()
+			defer mu.Unlock()
+			errs[id] = err
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func TestResultBatcherFlushOnSize(t *testing.T) {
+	store := &resultStore{}
+	b, _ := startBatcher(t, store, 3, time.Hour)
+
+	for id, err := range saveAll(b, 1, 2, 3) {
+		if err != nil {
+			t.Errorf("Save(%d) = %v, want nil", id, err)
+		}
+	}
+
+	if got, want := store.sortedBatches(), [][]int64{{1, 2, 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestResultBatcherFlushOnInterval(t *testing.T) {
+	const linger = 20 * time.Millisecond
+
+	store := &resultStore{}
+	b, _ := startBatcher(t, store, 100, linger)
+
+	start := time.Now()
+	for id, err := range saveAll(b, 1, 2) {
+		if err != nil {
+			t.Errorf("Save(%d) = %v, want nil", id, err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < linger {
+		t.Errorf("batch written after %v, want it to wait %v for more results", elapsed, linger)
+	}
+	if got, want := store.sortedBatches(), [][]int64{{1, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestResultBatcherFlushOnClose(t *testing.T) {
+	store := &resultStore{}
+	b, stop := startBatcher(t, store, 100, time.Hour)
+
+	// Requests are handed over unbuffered, so both are pending in the
+	// batcher once the sends return.
+	var reqs []resultReq
+	for _, id := range []int64{1, 2} {
+		req := resultReq{res: models.ProcessingResult{MsgID: id}, errCh: make(chan error, 1)}
+		b.reqs <- req
+		reqs = append(reqs, req)
+	}
+
+	stop()
+
+	for _, req := range reqs {
+		select {
+		case err := <-req.errCh:
+			if err != nil {
+				t.Errorf("result %d = %v, want it written on close", req.res.MsgID, err)
+			}
+		default:
+			t.Errorf("result %d was not written on close", req.res.MsgID)
+		}
+	}
+
+	if got, want := store.sortedBatches(), [][]int64{{1, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestResultBatcherErrors(t *testing.T) {
+	rejected := errors.New("illegal status transition")
+
+	t.Run("per result", func(t *testing.T) {
+		store := &resultStore{failing: map[int64]error{2: rejected}}
+		b, _ := startBatcher(t, store, 3, time.Hour)
+
+		errs := saveAll(b, 1, 2, 3)
+		want := map[int64]error{1: nil, 2: rejected, 3: nil}
+		if !reflect.DeepEqual(errs, want) {
+			t.Errorf("errors = %v, want %v", errs, want)
+		}
+	})
+
+	t.Run("whole batch", func(t *testing.T) {
+		down := errors.New("database is down")
+		store := &resultStore{err: down}
+		b, _ := startBatcher(t, store, 3, time.Hour)
+
+		for id, err := range saveAll(b, 1, 2, 3) {
+			if !errors.Is(err, down) {
+				t.Errorf("Save(%d) = %v, want %v", id, err, down)
+			}
+		}
+	})
+
+	t.Run("unbatched", func(t *testing.T) {
+		store := &resultStore{failing: map[int64]error{2: rejected}}
+		b := newResultBatcher(store, 1, 0)
+
+		if err := b.Save(context.Background(), models.ProcessingResult{MsgID: 2}); !errors.Is(err, rejected) {
+			t.Errorf("Save = %v, want %v", err, rejected)
+		}
+		if len(store.single) != 1 || len(store.batches) != 0 {
+			t.Errorf("results written as %v and batches %v, want one single write", store.single, store.batches)
+		}
+	})
+}
+
+func TestResultBatcherSameMessage(t *testing.T) {
+	store := &resultStore{}
+	b, _ := startBatcher(t, store, 2, 20*time.Millisecond)
+
+	ctx := context.Background()
+	errs := make(chan error, 2)
+	for _, status := range []string{models.StatusProcessing, models.StatusCompleted} {
+		go func() {
+			errs <- b.Save(ctx, models.ProcessingResult{MsgID: 1, Status: status})
+		}()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Errorf("Save = %v, want nil", err)
+		}
+	}
+
+	if got, want := store.sortedBatches(), [][]int64{{1}, {1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestResultBatcherSaveCancelled(t *testing.T) {
+	b, _ := startBatcher(t, &resultStore{}, 10, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := b.Save(ctx, models.ProcessingResult{MsgID: 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Save = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
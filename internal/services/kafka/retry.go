@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"log/slog"
+	"msgproc/internal/lib/backoff"
+	"strconv"
+	"time"
+)
+
+const (
+	// RetryModeInline retries a record in place, blocking its partition
+	// while waiting for the backoff.
+	RetryModeInline = "inline"
+	// RetryModeTopic republishes a failed record to the retry topic so the
+	// original partition keeps moving.
+	RetryModeTopic = "topic"
+)
+
+const HeaderRetryAfter = "x-retry-after"
+
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     backoff.Backoff
+	Mode        string
+	Topic       string
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return errors.New("retry max attempts must be at least 1")
+	}
+
+	switch p.Mode {
+	case RetryModeInline:
+	case RetryModeTopic:
+		if p.Topic == "" {
+			return errors.New("retry topic is required in topic retry mode")
+		}
+	default:
+		return fmt.Errorf("unknown retry mode %q", p.Mode)
+	}
+
+	return nil
+}
+
+// scheduleRetry republishes a record to the retry topic, to be processed
+// again once the delay has passed.
+func (k *Receiver) scheduleRetry(msg *sarama.ConsumerMessage, cause error, attempts int, delay time.Duration) error {
+	const op = "services.kafka.scheduleRetry"
+
+	retryAt := time.Now().Add(delay)
+
+	retryMsg := &sarama.ProducerMessage{
+		Topic: k.retry.Topic,
+		Value: sarama.ByteEncoder(msg.Value),
+		Headers: withHeaders(msg.Headers, append(originHeaders(msg),
+			header(HeaderError, cause.Error()),
+			header(HeaderAttempts, strconv.Itoa(attempts)),
+			header(HeaderRetryAfter, strconv.FormatInt(retryAt.UnixMilli(), 10)),
+		)...),
+	}
+	if msg.Key != nil {
+		retryMsg.Key = sarama.ByteEncoder(msg.Key)
+	}
+
+	partition, offset, err := k.producer.SendMessage(retryMsg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	k.log.Info("message scheduled for retry",
+		slog.String("op", op),
+		slog.String("topic", k.retry.Topic),
+		slog.Int("partition", int(partition)),
+		slog.Int64("offset", offset),
+		slog.Int("attempts", attempts),
+		slog.Time("retry_at", retryAt),
+	)
+
+	return nil
+}
+
+// attemptsDone returns the number of processing attempts recorded on a record
+// by earlier retries.
+func attemptsDone(msg *sarama.ConsumerMessage) int {
+	n, err := strconv.Atoi(headerValue(msg, HeaderAttempts))
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+func retryAfter(msg *sarama.ConsumerMessage) time.Time {
+	ms, err := strconv.ParseInt(headerValue(msg, HeaderRetryAfter), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,224 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hamba/avro/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"msgproc/api"
+	"msgproc/api/msgprocv1"
+	"msgproc/internal/lib/schemaregistry"
+	"sync"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+	FormatAvro     = "avro"
+)
+
+// Serializer converts envelopes to and from record values. The format is
+// written to the format header so that consumers can pick the matching
+// Serializer.
+type Serializer interface {
+	Format() string
+	Serialize(ctx context.Context, env Envelope) ([]byte, error)
+	Deserialize(ctx context.Context, data []byte) (Envelope, error)
+}
+
+// NewSerializer returns the serializer for format. Avro values are written in
+// the Confluent wire format, with the schema registered in registry under
+// subject.
+func NewSerializer(format string, registry schemaregistry.Client, subject string) (Serializer, error) {
+	const op = "services.kafka.NewSerializer"
+
+	switch format {
+	case FormatJSON:
+		return jsonSerializer{}, nil
+	case FormatProtobuf:
+		return protobufSerializer{}, nil
+	case FormatAvro:
+		if registry == nil {
+			return nil, fmt.Errorf("%s: avro requires a schema registry", op)
+		}
+
+		schema, err := avro.Parse(api.EnvelopeAvroSchema)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return &avroSerializer{
+			registry: registry,
+			subject:  subject,
+			schema:   schema,
+			writers:  make(map[int]avro.Schema),
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s: unknown format %q", op, format)
+	}
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Format() string {
+	return FormatJSON
+}
+
+func (jsonSerializer) Serialize(_ context.Context, env Envelope) ([]byte, error) {
+	return json.Marshal(env)
+}
+
+func (jsonSerializer) Deserialize(_ context.Context, data []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return env, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	return env, nil
+}
+
+// protobufSerializer encodes the msgproc.v1.Envelope message from
+// api/envelope.proto.
+type protobufSerializer struct{}
+
+func (protobufSerializer) Format() string {
+	return FormatProtobuf
+}
+
+func (protobufSerializer) Serialize(_ context.Context, env Envelope) ([]byte, error) {
+	return proto.Marshal(&msgprocv1.Envelope{
+		Version:     int32(env.Version),
+		MsgId:       env.MsgID,
+		Content:     env.Content,
+		CreatedAt:   timestamppb.New(env.CreatedAt),
+		ContentType: env.ContentType,
+	})
+}
+
+func (protobufSerializer) Deserialize(_ context.Context, data []byte) (Envelope, error) {
+	var msg msgprocv1.Envelope
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return Envelope{}, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	env := Envelope{
+		Version:     int(msg.GetVersion()),
+		MsgID:       msg.GetMsgId(),
+		Content:     msg.GetContent(),
+		ContentType: msg.GetContentType(),
+	}
+	if msg.CreatedAt != nil {
+		env.CreatedAt = msg.GetCreatedAt().AsTime()
+	}
+
+	return env, nil
+}
+
+// avroSerializer writes the Confluent wire format: a zero magic byte, the
+// big-endian schema ID and the Avro binary value. Values written with other
+// versions of the schema are resolved against api/envelope.avsc.
+type avroSerializer struct {
+	registry schemaregistry.Client
+	subject  string
+	schema   avro.Schema
+
+	mu      sync.Mutex
+	id      int
+	writers map[int]avro.Schema
+}
+
+const avroMagicByte = 0
+
+func (s *avroSerializer) Format() string {
+	return FormatAvro
+}
+
+func (s *avroSerializer) Serialize(ctx context.Context, env Envelope) ([]byte, error) {
+	id, err := s.schemaID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := avro.Marshal(s.schema, env)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 5, 5+len(value))
+	b[0] = avroMagicByte
+	binary.BigEndian.PutUint32(b[1:], uint32(id))
+
+	return append(b, value...), nil
+}
+
+func (s *avroSerializer) Deserialize(ctx context.Context, data []byte) (Envelope, error) {
+	var env Envelope
+
+	if len(data) < 5 || data[0] != avroMagicByte {
+		return env, errors.New("invalid avro wire format")
+	}
+
+	schema, err := s.readerSchema(ctx, int(binary.BigEndian.Uint32(data[1:5])))
+	if err != nil {
+		return env, err
+	}
+
+	if err := avro.Unmarshal(schema, data[5:], &env); err != nil {
+		return env, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	return env, nil
+}
+
+func (s *avroSerializer) schemaID(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.id != 0 {
+		return s.id, nil
+	}
+
+	id, err := s.registry.Register(ctx, s.subject, s.schema.String())
+	if err != nil {
+		return 0, fmt.Errorf("failed to register avro schema: %w", err)
+	}
+	s.id = id
+
+	return id, nil
+}
+
+// readerSchema returns the schema to decode values written with the schema
+// registered under id.
+func (s *avroSerializer) readerSchema(ctx context.Context, id int) (avro.Schema, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if schema, ok := s.writers[id]; ok {
+		return schema, nil
+	}
+
+	raw, err := s.registry.SchemaByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch avro schema %d: %w", id, err)
+	}
+
+	writer, err := avro.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse avro schema %d: %w", id, err)
+	}
+
+	schema := s.schema
+	if writer.Fingerprint() != s.schema.Fingerprint() {
+		schema, err = avro.NewSchemaCompatibility().Resolve(s.schema, writer)
+		if err != nil {
+			return nil, fmt.Errorf("incompatible avro schema %d: %w", id, err)
+		}
+	}
+	s.writers[id] = schema
+
+	return schema, nil
+}
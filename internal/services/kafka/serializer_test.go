@@ -0,0 +1,364 @@
+package kafka
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/hamba/avro/v2"
+	"msgproc/api"
+	"msgproc/internal/lib/schemaregistry"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testEnvelope = Envelope{
+	Version:     1,
+	MsgID:       42,
+	Content:     "hi",
+	CreatedAt:   time.Date(2024, 5, 1, 10, 0, 0, 5, time.UTC),
+	ContentType: ContentTypeText,
+}
+
+func TestProtobufGolden(t *testing.T) {
+	// Fields 1-5 of msgproc.v1.Envelope, with created_at as a nested
+	// google.protobuf.Timestamp {seconds: 1714557600, nanos: 5}.
+	const golden = "0801" + "102a" + "1a026869" + "2208" + "08a0a5c8b106" + "1005" + "2a0a746578742f706c61696e"
+
+	got, err := protobufSerializer{}.Serialize(context.Background(), testEnvelope)
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	if hex.EncodeToString(got) != golden {
+		t.Errorf("Serialize = %x, want %s", got, golden)
+	}
+
+	want, _ := hex.DecodeString(golden)
+	env, err := protobufSerializer{}.Deserialize(context.Background(), want)
+	if err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if env != testEnvelope {
+		t.Errorf("Deserialize = %+v, want %+v", env, testEnvelope)
+	}
+}
+
+func TestProtobufDeserialize(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Envelope
+		wantErr bool
+	}{
+		{name: "empty message", value: "", want: Envelope{}},
+		{name: "unknown field is skipped", value: "102a" + "3a03616263", want: Envelope{MsgID: 42}},
+		{name: "negative version", value: "08ffffffffffffffffff01", want: Envelope{Version: -1}},
+		{name: "truncated string", value: "1a0568", wantErr: true},
+		{name: "truncated varint", value: "10ff", wantErr: true},
+		{name: "wrong wire type", value: "1202", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := hex.DecodeString(tt.value)
+			if err != nil {
+				t.Fatalf("bad test value: %v", err)
+			}
+
+			got, err := protobufSerializer{}.Deserialize(context.Background(), data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Deserialize = %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Errorf("Deserialize = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	envelopes := []Envelope{
+		testEnvelope,
+		{Version: 1, MsgID: 9223372036854775807, Content: "max id", CreatedAt: time.Unix(1700000000, 0).UTC(), ContentType: "application/json"},
+		{Version: 1, MsgID: 1, Content: "юникод ✓", CreatedAt: time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC), ContentType: ContentTypeText},
+	}
+
+	for _, format := range []string{FormatJSON, FormatProtobuf, FormatAvro} {
+		t.Run(format, func(t *testing.T) {
+			s, err := NewSerializer(format, schemaregistry.NewMemory(), "msgs-value")
+			if err != nil {
+				t.Fatalf("NewSerializer: %v", err)
+			}
+			if s.Format() != format {
+				t.Errorf("Format = %q, want %q", s.Format(), format)
+			}
+
+			for _, want := range envelopes {
+				data, err := s.Serialize(context.Background(), want)
+				if err != nil {
+					t.Fatalf("Serialize: %v", err)
+				}
+
+				got, err := s.Deserialize(context.Background(), data)
+				if err != nil {
+					t.Fatalf("Deserialize: %v", err)
+				}
+
+				// Avro stores timestamp-millis.
+				if format == FormatAvro {
+					want.CreatedAt = want.CreatedAt.Truncate(time.Millisecond)
+				}
+				if !got.CreatedAt.Equal(want.CreatedAt) {
+					t.Errorf("created at = %v, want %v", got.CreatedAt, want.CreatedAt)
+				}
+				got.CreatedAt, want.CreatedAt = time.Time{}, time.Time{}
+				if got != want {
+					t.Errorf("round trip = %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+// testRegistry is a Confluent-compatible registry serving fixed schemas and
+// counting the requests it receives.
+type testRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	schemas   map[int]string
+	registers int
+	lookups   map[int]int
+}
+
+func newTestRegistry(t *testing.T, schemas map[int]string) *testRegistry {
+	r := &testRegistry{schemas: schemas, lookups: make(map[int]int)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /subjects/{subject}/versions", func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Schema string `json:"schema"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.registers++
+
+		id := 0
+		for k, v := range r.schemas {
+			if v == body.Schema {
+				id = k
+			}
+		}
+		if id == 0 {
+			id = 100 + len(r.schemas)
+			r.schemas[id] = body.Schema
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]int{"id": id})
+	})
+	mux.HandleFunc("GET /schemas/ids/{id}", func(w http.ResponseWriter, req *http.Request) {
+		id, _ := strconv.Atoi(req.PathValue("id"))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.lookups[id]++
+
+		schema, ok := r.schemas[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error_code": 40403, "message": "Schema not found"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"schema": schema})
+	})
+
+	r.Server = httptest.NewServer(mux)
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *testRegistry) counts(id int) (registers, lookups int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.registers, r.lookups[id]
+}
+
+func newAvroSerializer(t *testing.T, registry *testRegistry) Serializer {
+	t.Helper()
+
+	s, err := NewSerializer(FormatAvro, schemaregistry.NewHTTP(registry.URL, "", "", registry.Client()), "msgs-value")
+	if err != nil {
+		t.Fatalf("NewSerializer: %v", err)
+	}
+
+	return s
+}
+
+func TestAvroWireFormat(t *testing.T) {
+	ctx := context.Background()
+	registry := newTestRegistry(t, map[int]string{})
+
+	producer := newAvroSerializer(t, registry)
+
+	var values [][]byte
+	for range 3 {
+		data, err := producer.Serialize(ctx, testEnvelope)
+		if err != nil {
+			t.Fatalf("Serialize: %v", err)
+		}
+		values = append(values, data)
+	}
+
+	data := values[0]
+	if len(data) < 5 || data[0] != 0 {
+		t.Fatalf("value %x does not start with the magic byte", data)
+	}
+	id := int(binary.BigEndian.Uint32(data[1:5]))
+	if id != 100 {
+		t.Errorf("schema ID = %d, want the registered ID 100", id)
+	}
+
+	schema, err := avro.Parse(api.EnvelopeAvroSchema)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var env Envelope
+	if err := avro.Unmarshal(schema, data[5:], &env); err != nil {
+		t.Fatalf("payload after the header is not avro: %v", err)
+	}
+	if env.MsgID != testEnvelope.MsgID || env.Content != testEnvelope.Content {
+		t.Errorf("payload = %+v, want %+v", env, testEnvelope)
+	}
+
+	if registers, _ := registry.counts(id); registers != 1 {
+		t.Errorf("registered the schema %d times, want once", registers)
+	}
+
+	// A separate consumer has to fetch the schema, but only once.
+	consumer := newAvroSerializer(t, registry)
+	for _, data := range values {
+		if _, err := consumer.Deserialize(ctx, data); err != nil {
+			t.Fatalf("Deserialize: %v", err)
+		}
+	}
+	if _, lookups := registry.counts(id); lookups != 1 {
+		t.Errorf("fetched schema %d %d times, want once", id, lookups)
+	}
+
+	// The producer already knows the schema it registered.
+	if _, err := producer.Deserialize(ctx, data); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if _, lookups := registry.counts(id); lookups != 1 {
+		t.Errorf("fetched schema %d %d times, want once", id, lookups)
+	}
+}
+
+func TestAvroSchemaResolution(t *testing.T) {
+	// An earlier version of the schema, written before content_type existed.
+	const oldSchema = `{
+		"type": "record",
+		"name": "Envelope",
+		"namespace": "msgproc.v1",
+		"fields": [
+			{"name": "version", "type": "int"},
+			{"name": "msg_id", "type": "long"},
+			{"name": "content", "type": "string"},
+			{"name": "created_at", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+		]
+	}`
+	// A schema that cannot be read as the current one.
+	const incompatible = `{
+		"type": "record",
+		"name": "Envelope",
+		"namespace": "msgproc.v1",
+		"fields": [
+			{"name": "msg_id", "type": "string"}
+		]
+	}`
+
+	ctx := context.Background()
+	registry := newTestRegistry(t, map[int]string{3: oldSchema, 4: incompatible})
+	s := newAvroSerializer(t, registry)
+
+	frame := func(id int, schema string, v any) []byte {
+		t.Helper()
+
+		value, err := avro.Marshal(avro.MustParse(schema), v)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+
+		b := []byte{0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(id))
+		return append(b, value...)
+	}
+
+	old := frame(3, oldSchema, map[string]any{
+		"version":    1,
+		"msg_id":     int64(7),
+		"content":    "old",
+		"created_at": testEnvelope.CreatedAt,
+	})
+
+	env, err := s.Deserialize(ctx, old)
+	if err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if env.MsgID != 7 || env.Content != "old" || env.ContentType != ContentTypeText {
+		t.Errorf("Deserialize = %+v, want message 7 with the default content type", env)
+	}
+
+	if _, err := s.Deserialize(ctx, old); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if _, lookups := registry.counts(3); lookups != 1 {
+		t.Errorf("fetched schema 3 %d times, want once", lookups)
+	}
+
+	tests := []struct {
+		name  string
+		value []byte
+		want  string
+	}{
+		{"incompatible schema", frame(4, incompatible, map[string]any{"msg_id": "7"}), "incompatible avro schema 4"},
+		{"unknown schema", append([]byte{0, 0, 0, 0, 9}, old[5:]...), "failed to fetch avro schema 9"},
+		{"bad magic byte", append([]byte{1}, old[1:]...), "invalid avro wire format"},
+		{"short value", []byte{0, 0, 0}, "invalid avro wire format"},
+		{"truncated payload", old[:8], "failed to unmarshal message"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Deserialize(ctx, tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Deserialize error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	_, err = s.Deserialize(ctx, append([]byte{0, 0, 0, 0, 9}, old[5:]...))
+	if !errors.Is(err, schemaregistry.ErrSchemaNotFound) {
+		t.Errorf("Deserialize with an unknown schema = %v, want %v", err, schemaregistry.ErrSchemaNotFound)
+	}
+}
@@ -2,52 +2,47 @@ package msgproc
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"msgproc/internal/domain/models"
 	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/storage"
+	"time"
 )
 
 type MsgProc struct {
-	log       *slog.Logger
-	MsgSaver  MsgSaver
-	MsgSender MsgSender
-}
-
-type MsgSaver interface {
-	SaveMsg(
-		ctx context.Context,
-		msg string,
-	) (int64, error)
-}
-
-type MsgSender interface {
-	SendMsg(
-		ctx context.Context,
-		msg string,
-		msgID int64,
-	) error
+	log            *slog.Logger
+	MsgSaver       storage.MsgSaver
+	MsgProvider    storage.MsgProvider
+	idempotencyTTL time.Duration
 }
 
 func New(
 	log *slog.Logger,
-	msgSaver MsgSaver,
-	msgSender MsgSender,
+	msgSaver storage.MsgSaver,
+	msgProvider storage.MsgProvider,
+	idempotencyTTL time.Duration,
 ) *MsgProc {
 	return &MsgProc{
-		log:       log,
-		MsgSaver:  msgSaver,
-		MsgSender: msgSender,
+		log:            log,
+		MsgSaver:       msgSaver,
+		MsgProvider:    msgProvider,
+		idempotencyTTL: idempotencyTTL,
 	}
 }
 
 func (m *MsgProc) ProcessMsg(
 	ctx context.Context,
-	msg string,
+	msg models.NewMsg,
 ) (int64, error) {
 	const op = "services.msgproc.ProcessMsg"
 
 	log := m.log.With(
 		slog.String("op", op),
+		slog.String("request_id", msg.RequestID),
 	)
 
 	log.Info("processing new message")
@@ -58,14 +53,203 @@ func (m *MsgProc) ProcessMsg(
 
 		return 0, fmt.Errorf("%s: %w", op, err)
 	}
-	err = m.MsgSender.SendMsg(ctx, msg, msgID)
+
+	log.Info("message processed successfully")
+
+	return msgID, nil
+}
+
+// ProcessMsgs saves a batch of messages at once. The returned IDs are in the
+// order of msgs.
+func (m *MsgProc) ProcessMsgs(
+	ctx context.Context,
+	msgs []models.NewMsg,
+) ([]int64, error) {
+	const op = "services.msgproc.ProcessMsgs"
+
+	log := m.log.With(
+		slog.String("op", op),
+		slog.Int("count", len(msgs)),
+	)
+
+	log.Info("processing message batch")
+
+	msgIDs, err := m.MsgSaver.SaveMsgs(ctx, msgs)
 	if err != nil {
-		log.Error("failed to send message", sl.Err(err))
+		log.Error("failed to save messages", sl.Err(err))
 
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("message batch processed successfully")
+
+	return msgIDs, nil
+}
+
+// ProcessMsgIdempotent is ProcessMsg deduplicated by an idempotency key. If
+// the key was already used for the same message, the original msgID is
+// returned and replayed is true.
+func (m *MsgProc) ProcessMsgIdempotent(
+	ctx context.Context,
+	msg models.NewMsg,
+	key string,
+) (int64, bool, error) {
+	const op = "services.msgproc.ProcessMsgIdempotent"
+
+	log := m.log.With(
+		slog.String("op", op),
+		slog.String("request_id", msg.RequestID),
+		slog.String("idempotency_key", key),
+	)
+
+	log.Info("processing new message")
+
+	// The request ID differs between retries, so only the content is hashed,
+	// with the callback URL when there is one so that existing keys keep
+	// their hash.
+	request := msg.Content
+	if msg.CallbackURL != "" {
+		request += "\x00" + msg.CallbackURL
+	}
+	hash := sha256.Sum256([]byte(request))
+
+	msgID, replayed, err := m.MsgSaver.SaveMsgIdempotent(
+		ctx,
+		msg,
+		key,
+		hex.EncodeToString(hash[:]),
+		m.idempotencyTTL,
+	)
+	if err != nil {
+		if errors.Is(err, storage.ErrIdempotencyKeyReused) {
+			log.Warn("idempotency key reused with a different message")
+
+			return 0, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to save message", sl.Err(err))
+
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if replayed {
+		log.Info("idempotent request replayed", slog.Int64("msgID", msgID))
+
+		return msgID, true, nil
 	}
 
 	log.Info("message processed successfully")
 
-	return msgID, nil
+	return msgID, false, nil
+}
+
+// PurgeIdempotencyKeys periodically deletes expired idempotency keys until
+// ctx is cancelled.
+func (m *MsgProc) PurgeIdempotencyKeys(ctx context.Context, interval time.Duration) {
+	const op = "services.msgproc.PurgeIdempotencyKeys"
+
+	log := m.log.With(
+		slog.String("op", op),
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := m.MsgSaver.DeleteExpiredIdempotencyKeys(ctx)
+		if err != nil {
+			log.Error("failed to delete expired idempotency keys", sl.Err(err))
+			continue
+		}
+
+		if n > 0 {
+			log.Info("expired idempotency keys deleted", slog.Int64("count", n))
+		}
+	}
+}
+
+func (m *MsgProc) Msg(
+	ctx context.Context,
+	msgID int64,
+) (*models.Message, error) {
+	const op = "services.msgproc.Msg"
+
+	log := m.log.With(
+		slog.String("op", op),
+		slog.Int64("msgID", msgID),
+	)
+
+	log.Info("getting message")
+
+	msg, err := m.MsgProvider.Msg(ctx, msgID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMsgNotFound) {
+			log.Warn("message not found", sl.Err(err))
+
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to get message", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return msg, nil
+}
+
+func (m *MsgProc) ListMsgs(
+	ctx context.Context,
+	filter models.MsgFilter,
+) (*models.MsgPage, error) {
+	const op = "services.msgproc.ListMsgs"
+
+	log := m.log.With(
+		slog.String("op", op),
+	)
+
+	log.Info("listing messages")
+
+	page, err := m.MsgProvider.ListMsgs(ctx, filter)
+	if err != nil {
+		log.Error("failed to list messages", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return page, nil
+}
+
+func (m *MsgProc) MsgHistory(
+	ctx context.Context,
+	msgID int64,
+) ([]models.StatusHistoryEntry, error) {
+	const op = "services.msgproc.MsgHistory"
+
+	log := m.log.With(
+		slog.String("op", op),
+		slog.Int64("msgID", msgID),
+	)
+
+	log.Info("getting message history")
+
+	history, err := m.MsgProvider.MsgHistory(ctx, msgID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMsgNotFound) {
+			log.Warn("message not found", sl.Err(err))
+
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to get message history", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return history, nil
 }
@@ -6,72 +6,79 @@ import (
 	"log/slog"
 	"msgproc/internal/domain/models"
 	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/storage"
+	"time"
 )
 
 type StatisticsService struct {
 	log     *slog.Logger
-	MsgStat MsgStat
+	MsgStat storage.StatProvider
 }
 
-type MsgStat interface {
-	TotalMessages(ctx context.Context) (int64, error)
-	MessagesByStatus(ctx context.Context) (map[string]int64, error)
-	MessagesLastDay(ctx context.Context) (int64, error)
-	MessagesUpdatedLastDay(ctx context.Context) (int64, error)
-	AverageMessageLength(ctx context.Context) (float64, error)
-}
-
-func New(log *slog.Logger, msgStat MsgStat) *StatisticsService {
+func New(log *slog.Logger, msgStat storage.StatProvider) *StatisticsService {
 	return &StatisticsService{
 		log:     log,
 		MsgStat: msgStat,
 	}
 }
 
-func (s *StatisticsService) Stats(ctx context.Context) (*models.Statistics, error) {
-	const op = "services.msgstat.TotalMessages"
+// Stats returns the overall statistics, and the time series and processing
+// latency over the window of the filter.
+func (s *StatisticsService) Stats(ctx context.Context, filter models.SeriesFilter) (*models.Statistics, error) {
+	const op = "services.msgstat.Stats"
 
 	log := s.log.With(
 		slog.String("op", op),
 	)
 
-	log.Info("getting total messages")
-
-	total, err := s.MsgStat.TotalMessages(ctx)
-	if err != nil {
-		log.Error("failed to get total messages", sl.Err(err))
-
-		return nil, fmt.Errorf("%s: %w", op, err)
-	}
-	msgByStatus, err := s.MsgStat.MessagesByStatus(ctx)
-	if err != nil {
-		log.Error("failed to get messages by status", sl.Err(err))
-		return nil, fmt.Errorf("%s: %w", op, err)
-	}
+	log.Info("getting statistics")
 
-	msgLastDay, err := s.MsgStat.MessagesLastDay(ctx)
+	summary, err := s.MsgStat.StatsSummary(ctx)
 	if err != nil {
-		log.Error("failed to get messages last day", sl.Err(err))
+		log.Error("failed to get statistics summary", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	msgUpdatedLastDay, err := s.MsgStat.MessagesUpdatedLastDay(ctx)
+	series, err := s.MsgStat.MessageSeries(ctx, filter)
 	if err != nil {
-		log.Error("failed to get messages updated last day", sl.Err(err))
+		log.Error("failed to get message series", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	averageMessageLength, err := s.MsgStat.AverageMessageLength(ctx)
+	latency, err := s.MsgStat.ProcessingLatency(ctx, filter.From, filter.To)
 	if err != nil {
-		log.Error("failed to get average message length", sl.Err(err))
+		log.Error("failed to get processing latency", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
 	return &models.Statistics{
-		TotalMessages:          total,
-		MessagesByStatus:       msgByStatus,
-		MessagesLastDay:        msgLastDay,
-		MessagesUpdatedLastDay: msgUpdatedLastDay,
-		AverageMessageLength:   averageMessageLength,
+		StatsSummary: summary,
+		Series:       series,
+		Latency:      latency,
 	}, nil
 }
+
+// RefreshStats periodically folds recent message changes into the statistics
+// rollups until ctx is cancelled.
+func (s *StatisticsService) RefreshStats(ctx context.Context, interval time.Duration) {
+	const op = "services.msgstat.RefreshStats"
+
+	log := s.log.With(
+		slog.String("op", op),
+	)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.MsgStat.RefreshStats(ctx); err != nil {
+			log.Error("failed to refresh statistics", sl.Err(err))
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/storage"
+	"sync"
+	"time"
+)
+
+type Relay struct {
+	log          *slog.Logger
+	store        storage.Outbox
+	sender       MsgSender
+	pollInterval time.Duration
+	batchSize    int
+	lease        time.Duration
+
+	kick    chan struct{}
+	mu      sync.Mutex
+	waiters map[int64][]chan error
+}
+
+type MsgSender interface {
+	SendMsgs(ctx context.Context, entries []models.OutboxMsg) (map[int64]error, error)
+}
+
+func New(
+	log *slog.Logger,
+	store storage.Outbox,
+	sender MsgSender,
+	pollInterval time.Duration,
+	batchSize int,
+	lease time.Duration,
+) *Relay {
+	return &Relay{
+		log:          log,
+		store:        store,
+		sender:       sender,
+		pollInterval: pollInterval,
+		batchSize:    batchSize,
+		lease:        lease,
+		kick:         make(chan struct{}, 1),
+		waiters:      make(map[int64][]chan error),
+	}
+}
+
+// Run publishes pending outbox entries until ctx is cancelled. Entries stay
+// in the outbox until Kafka acknowledges them, so nothing is lost when the
+// process restarts mid-batch.
+func (r *Relay) Run(ctx context.Context) error {
+	const op = "services.outbox.Run"
+
+	log := r.log.With(
+		slog.String("op", op),
+	)
+
+	log.Info("outbox relay started")
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := r.relayBatch(ctx)
+			if err != nil {
+				log.Error("failed to relay outbox batch", sl.Err(err))
+				break
+			}
+			if n < r.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("outbox relay stopped")
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		case <-ticker.C:
+		case <-r.kick:
+		}
+	}
+}
+
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	const op = "services.outbox.relayBatch"
+
+	log := r.log.With(
+		slog.String("op", op),
+	)
+
+	entries, err := r.store.FetchOutbox(ctx, r.batchSize, r.lease)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	failed, err := r.sender.SendMsgs(ctx, entries)
+	if err != nil {
+		// Leases expire on their own, so the whole batch is retried later.
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sent := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		sendErr, ok := failed[e.ID]
+		if !ok {
+			sent = append(sent, e.ID)
+			continue
+		}
+
+		r.notify(e.MsgID, sendErr)
+
+		log.Error("failed to publish outbox entry",
+			slog.Int64("msgID", e.MsgID),
+			slog.Int("attempts", e.Attempts),
+			sl.Err(sendErr),
+		)
+
+		if err := r.store.FailOutbox(ctx, e.ID, sendErr.Error()); err != nil {
+			log.Error("failed to record outbox failure", sl.Err(err))
+		}
+	}
+
+	if len(sent) > 0 {
+		if err := r.store.MarkOutboxSent(ctx, sent); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	for _, e := range entries {
+		if _, ok := failed[e.ID]; !ok {
+			r.notify(e.MsgID, nil)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// Await triggers a relay pass and blocks until the message has been
+// published to Kafka, the first publish attempt failed, or ctx is done. A
+// message published by the relay of another instance is detected by polling
+// the outbox.
+func (r *Relay) Await(ctx context.Context, msgID int64) error {
+	const op = "services.outbox.Await"
+
+	ch := make(chan error, 1)
+
+	r.mu.Lock()
+	r.waiters[msgID] = append(r.waiters[msgID], ch)
+	r.mu.Unlock()
+
+	defer r.removeWaiter(msgID, ch)
+
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		pending, err := r.store.OutboxPending(ctx, msgID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if !pending {
+			return nil
+		}
+
+		select {
+		case err := <-ch:
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) notify(msgID int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ch := range r.waiters[msgID] {
+		select {
+		case ch <- err:
+		default:
+		}
+	}
+	delete(r.waiters, msgID)
+}
+
+func (r *Relay) removeWaiter(msgID int64, ch chan error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	waiters := r.waiters[msgID]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) == 0 {
+		delete(r.waiters, msgID)
+		return
+	}
+	r.waiters[msgID] = waiters
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func init() {
+	Register("trim_space", func(map[string]string) (Processor, error) {
+		return transform(strings.TrimSpace), nil
+	})
+	Register("lower", func(map[string]string) (Processor, error) {
+		return transform(strings.ToLower), nil
+	})
+	Register("upper", func(map[string]string) (Processor, error) {
+		return transform(strings.ToUpper), nil
+	})
+	Register("max_length", newMaxLength)
+	Register("skip_empty", newSkipEmpty)
+	Register("reject_regexp", newRejectRegexp)
+	Register("replace_regexp", newReplaceRegexp)
+}
+
+// DefaultStages is used when no pipeline is configured.
+var DefaultStages = []StageConfig{
+	{Name: "trim_space"},
+}
+
+func transform(fn func(string) string) Processor {
+	return ProcessorFunc(func(_ context.Context, content string) (Result, error) {
+		return Result{Content: fn(content)}, nil
+	})
+}
+
+func newMaxLength(params map[string]string) (Processor, error) {
+	limit, err := strconv.Atoi(params["max"])
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("param max must be a positive integer")
+	}
+
+	return ProcessorFunc(func(_ context.Context, content string) (Result, error) {
+		if n := utf8.RuneCountInString(content); n > limit {
+			return Result{
+				Action: ActionReject,
+				Reason: fmt.Sprintf("message length %d exceeds %d", n, limit),
+			}, nil
+		}
+
+		return Result{Content: content}, nil
+	}), nil
+}
+
+func newSkipEmpty(map[string]string) (Processor, error) {
+	return ProcessorFunc(func(_ context.Context, content string) (Result, error) {
+		if strings.TrimSpace(content) == "" {
+			return Result{
+				Action: ActionSkip,
+				Reason: "message is empty",
+			}, nil
+		}
+
+		return Result{Content: content}, nil
+	}), nil
+}
+
+func newRejectRegexp(params map[string]string) (Processor, error) {
+	if params["pattern"] == "" {
+		return nil, fmt.Errorf("param pattern is required")
+	}
+
+	re, err := regexp.Compile(params["pattern"])
+	if err != nil {
+		return nil, fmt.Errorf("param pattern: %w", err)
+	}
+
+	return ProcessorFunc(func(_ context.Context, content string) (Result, error) {
+		if re.MatchString(content) {
+			return Result{
+				Action: ActionReject,
+				Reason: "message matches " + re.String(),
+			}, nil
+		}
+
+		return Result{Content: content}, nil
+	}), nil
+}
+
+func newReplaceRegexp(params map[string]string) (Processor, error) {
+	if params["pattern"] == "" {
+		return nil, fmt.Errorf("param pattern is required")
+	}
+
+	re, err := regexp.Compile(params["pattern"])
+	if err != nil {
+		return nil, fmt.Errorf("param pattern: %w", err)
+	}
+	replacement := params["replacement"]
+
+	return ProcessorFunc(func(_ context.Context, content string) (Result, error) {
+		return Result{Content: re.ReplaceAllString(content, replacement)}, nil
+	}), nil
+}
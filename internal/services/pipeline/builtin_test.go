@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		name    string
+		stage   StageConfig
+		content string
+		want    Result
+	}{
+		{"trim_space", StageConfig{Name: "trim_space"}, "  hi \n", Result{Content: "hi"}},
+		{"lower", StageConfig{Name: "lower"}, "HeLLo", Result{Content: "hello"}},
+		{"upper", StageConfig{Name: "upper"}, "HeLLo", Result{Content: "HELLO"}},
+		{
+			"max_length within limit",
+			StageConfig{Name: "max_length", Params: map[string]string{"max": "3"}},
+			"héé",
+			Result{Content: "héé"},
+		},
+		{
+			"max_length over limit",
+			StageConfig{Name: "max_length", Params: map[string]string{"max": "3"}},
+			"four",
+			Result{Action: ActionReject, Reason: "max_length: message length 4 exceeds 3"},
+		},
+		{"skip_empty with content", StageConfig{Name: "skip_empty"}, " x ", Result{Content: " x "}},
+		{
+			"skip_empty blank",
+			StageConfig{Name: "skip_empty"},
+			" \t ",
+			Result{Content: " \t ", Action: ActionSkip, Reason: "skip_empty: message is empty"},
+		},
+		{
+			"reject_regexp no match",
+			StageConfig{Name: "reject_regexp", Params: map[string]string{"pattern": `^spam`}},
+			"ham",
+			Result{Content: "ham"},
+		},
+		{
+			"reject_regexp match",
+			StageConfig{Name: "reject_regexp", Params: map[string]string{"pattern": `^spam`}},
+			"spam and eggs",
+			Result{Action: ActionReject, Reason: "reject_regexp: message matches ^spam"},
+		},
+		{
+			"replace_regexp",
+			StageConfig{Name: "replace_regexp", Params: map[string]string{"pattern": `\d`, "replacement": "#"}},
+			"pin 1234",
+			Result{Content: "pin ####"},
+		},
+		{
+			"replace_regexp without replacement",
+			StageConfig{Name: "replace_regexp", Params: map[string]string{"pattern": `\s+`}},
+			"a b  c",
+			Result{Content: "abc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Build([]StageConfig{tt.stage})
+			if err != nil {
+				t.Fatalf("Build: %v", err)
+			}
+
+			got, err := p.Run(context.Background(), tt.content)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Run(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		stage StageConfig
+	}{
+		{"max_length missing", StageConfig{Name: "max_length"}},
+		{"max_length zero", StageConfig{Name: "max_length", Params: map[string]string{"max": "0"}}},
+		{"max_length not a number", StageConfig{Name: "max_length", Params: map[string]string{"max": "ten"}}},
+		{"reject_regexp missing", StageConfig{Name: "reject_regexp"}},
+		{"reject_regexp invalid", StageConfig{Name: "reject_regexp", Params: map[string]string{"pattern": "("}}},
+		{"replace_regexp missing", StageConfig{Name: "replace_regexp"}},
+		{"replace_regexp invalid", StageConfig{Name: "replace_regexp", Params: map[string]string{"pattern": "["}}},
+		{"unknown processor", StageConfig{Name: "reverse"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Build([]StageConfig{tt.stage}); err == nil {
+				t.Error("Build succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDefaultStages(t *testing.T) {
+	p, err := Build(DefaultStages)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	got, err := p.Run(context.Background(), "  hello  ")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got != (Result{Content: "hello"}) {
+		t.Errorf("Run = %+v, want trimmed content", got)
+	}
+}
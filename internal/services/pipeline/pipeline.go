@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type Action int
+
+const (
+	// ActionContinue passes the (possibly transformed) content to the next stage.
+	ActionContinue Action = iota
+	// ActionReject stops the pipeline and marks the message as failed.
+	ActionReject
+	// ActionSkip stops the pipeline and leaves the message content untouched.
+	ActionSkip
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionContinue:
+		return "continue"
+	case ActionReject:
+		return "reject"
+	case ActionSkip:
+		return "skip"
+	default:
+		return fmt.Sprintf("action(%d)", int(a))
+	}
+}
+
+type Result struct {
+	Content string
+	Action  Action
+	Reason  string
+}
+
+type Processor interface {
+	Process(ctx context.Context, content string) (Result, error)
+}
+
+type ProcessorFunc func(ctx context.Context, content string) (Result, error)
+
+func (f ProcessorFunc) Process(ctx context.Context, content string) (Result, error) {
+	return f(ctx, content)
+}
+
+// Factory builds a processor from the params of its config entry.
+type Factory func(params map[string]string) (Processor, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a processor available to pipelines under the given name.
+// It is meant to be called from init or main before Build and panics if the
+// name is already taken.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("pipeline: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("pipeline: Register called twice for processor " + name)
+	}
+
+	registry[name] = factory
+}
+
+// Processors returns the sorted names of the registered processors.
+func Processors() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+type StageConfig struct {
+	Name   string
+	Params map[string]string
+}
+
+type Stage struct {
+	Name      string
+	Processor Processor
+}
+
+type Pipeline struct {
+	stages []Stage
+}
+
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+func Build(configs []StageConfig) (*Pipeline, error) {
+	const op = "services.pipeline.Build"
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	stages := make([]Stage, 0, len(configs))
+	for _, cfg := range configs {
+		factory, ok := registry[cfg.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown processor %q", op, cfg.Name)
+		}
+
+		processor, err := factory(cfg.Params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: processor %q: %w", op, cfg.Name, err)
+		}
+
+		stages = append(stages, Stage{
+			Name:      cfg.Name,
+			Processor: processor,
+		})
+	}
+
+	return New(stages...), nil
+}
+
+// Run passes content through the stages in order. It stops at the first stage
+// that rejects or skips the message; the returned Reason is prefixed with the
+// name of that stage.
+func (p *Pipeline) Run(ctx context.Context, content string) (Result, error) {
+	const op = "services.pipeline.Run"
+
+	for _, stage := range p.stages {
+		res, err := stage.Processor.Process(ctx, content)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s: stage %q: %w", op, stage.Name, err)
+		}
+
+		if res.Action != ActionContinue {
+			res.Reason = stage.Name + ": " + res.Reason
+			if res.Action == ActionSkip {
+				res.Content = content
+			}
+
+			return res, nil
+		}
+
+		content = res.Content
+	}
+
+	return Result{
+		Content: content,
+		Action:  ActionContinue,
+	}, nil
+}
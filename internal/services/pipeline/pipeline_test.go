@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder returns a processor that appends its name to the content and to
+// calls.
+func recorder(name string, calls *[]string) Processor {
+	return ProcessorFunc(func(_ context.Context, content string) (Result, error) {
+		*calls = append(*calls, name)
+		return Result{Content: content + name}, nil
+	})
+}
+
+func TestRunOrder(t *testing.T) {
+	var calls []string
+	p := New(
+		Stage{Name: "a", Processor: recorder("a", &calls)},
+		Stage{Name: "b", Processor: recorder("b", &calls)},
+		Stage{Name: "c", Processor: recorder("c", &calls)},
+	)
+
+	got, err := p.Run(context.Background(), ">")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got != (Result{Content: ">abc", Action: ActionContinue}) {
+		t.Errorf("Run = %+v, want content >abc", got)
+	}
+	if !reflect.DeepEqual(calls, []string{"a", "b", "c"}) {
+		t.Errorf("stages ran as %v, want a, b, c", calls)
+	}
+}
+
+func TestRunBuiltOrder(t *testing.T) {
+	p, err := Build([]StageConfig{
+		{Name: "upper"},
+		{Name: "replace_regexp", Params: map[string]string{"pattern": "A", "replacement": "b"}},
+	})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	// Lowercase b is only left if upper ran first.
+	got, err := p.Run(context.Background(), "aaa")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got.Content != "bbb" {
+		t.Errorf("Run content = %q, want %q", got.Content, "bbb")
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	boom := errors.New("boom")
+
+	tests := []struct {
+		name    string
+		stop    Processor
+		want    Result
+		wantErr error
+	}{
+		{
+			name: "error",
+			stop: ProcessorFunc(func(context.Context, string) (Result, error) {
+				return Result{}, boom
+			}),
+			wantErr: boom,
+		},
+		{
+			name: "reject",
+			stop: ProcessorFunc(func(context.Context, string) (Result, error) {
+				return Result{Action: ActionReject, Reason: "bad"}, nil
+			}),
+			want: Result{Action: ActionReject, Reason: "stop: bad"},
+		},
+		{
+			name: "skip keeps the content the stage received",
+			stop: ProcessorFunc(func(context.Context, string) (Result, error) {
+				return Result{Content: "ignored", Action: ActionSkip, Reason: "later"}, nil
+			}),
+			want: Result{Content: ">a", Action: ActionSkip, Reason: "stop: later"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			p := New(
+				Stage{Name: "a", Processor: recorder("a", &calls)},
+				Stage{Name: "stop", Processor: tt.stop},
+				Stage{Name: "c", Processor: recorder("c", &calls)},
+			)
+
+			got, err := p.Run(context.Background(), ">")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !strings.Contains(err.Error(), `stage "stop"`) {
+					t.Errorf("Run error = %v, want %v from stage stop", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Run: %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("Run = %+v, want %+v", got, tt.want)
+				}
+			}
+
+			if !reflect.DeepEqual(calls, []string{"a"}) {
+				t.Errorf("stages ran as %v, want only a", calls)
+			}
+		})
+	}
+}
+
+func TestEmptyPipeline(t *testing.T) {
+	got, err := New().Run(context.Background(), "as is")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got != (Result{Content: "as is"}) {
+		t.Errorf("Run = %+v, want the content unchanged", got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	// The registry is global, so the name must differ between runs.
+	name := fmt.Sprintf("test_reverse_%d", time.Now().UnixNano())
+
+	Register(name, func(map[string]string) (Processor, error) {
+		return transform(func(s string) string {
+			r := []rune(s)
+			for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+				r[i], r[j] = r[j], r[i]
+			}
+			return string(r)
+		}), nil
+	})
+
+	found := false
+	for _, n := range Processors() {
+		if n == name {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Processors() = %v, want %s listed", Processors(), name)
+	}
+
+	p, err := Build([]StageConfig{{Name: name}})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	got, err := p.Run(context.Background(), "abc")
+	if err != nil || got.Content != "cba" {
+		t.Errorf("Run = %+v, %v, want cba", got, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	Register(name, func(map[string]string) (Processor, error) { return nil, nil })
+}
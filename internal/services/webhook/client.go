@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrForbiddenDestination = errors.New("webhook destination is not allowed")
+
+// deniedPrefixes are globally routable in form but reach internal or
+// translated addresses.
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// NewClient returns the client webhooks are sent with. Callback URLs come
+// from API clients, so it only connects to public unicast addresses,
+// checking the address a host resolves to rather than its name. When
+// allowedHosts is set, no other hosts are called. Redirects are not
+// followed; they count as failed attempts.
+func NewClient(timeout time.Duration, allowedHosts []string) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: checkAddress,
+	}
+
+	allowed := make(map[string]struct{}, len(allowedHosts))
+	for _, host := range allowedHosts {
+		allowed[strings.ToLower(host)] = struct{}{}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect on our behalf, out of reach of the checks.
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if len(allowed) > 0 {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := allowed[strings.ToLower(host)]; !ok {
+				return nil, fmt.Errorf("%w: host %s is not allowed", ErrForbiddenDestination, host)
+			}
+		}
+
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// checkAddress rejects connections to addresses that are internal to the
+// host or its network. It runs after the host name is resolved.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+
+	// Loopback, link-local, multicast and unspecified addresses are not
+	// global unicast.
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("%w: address %s is internal", ErrForbiddenDestination, ip)
+	}
+
+	for _, prefix := range deniedPrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: address %s is internal", ErrForbiddenDestination, ip)
+		}
+	}
+
+	return nil
+}
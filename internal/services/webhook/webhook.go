@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/backoff"
+	"msgproc/internal/lib/logger/sl"
+	"msgproc/internal/storage"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderSignature carries "sha256=" and the hex HMAC-SHA256 of the
+	// timestamp, a dot and the body, keyed with the shared secret.
+	HeaderSignature = "X-Msgproc-Signature"
+	// HeaderTimestamp is the Unix time the request was signed at, so
+	// receivers can reject replays.
+	HeaderTimestamp = "X-Msgproc-Timestamp"
+	// HeaderDelivery identifies the delivery. It is the same on every retry.
+	HeaderDelivery = "X-Msgproc-Delivery"
+)
+
+// maxResponseBytes is how much of a response body is read so the connection
+// can be reused.
+const maxResponseBytes = 64 << 10
+
+type Dispatcher struct {
+	log          *slog.Logger
+	store        storage.Webhooks
+	client       *http.Client
+	secret       []byte
+	pollInterval time.Duration
+	batchSize    int
+	lease        time.Duration
+	maxAttempts  int
+	backoff      backoff.Backoff
+}
+
+func New(
+	log *slog.Logger,
+	store storage.Webhooks,
+	client *http.Client,
+	secret string,
+	pollInterval time.Duration,
+	batchSize int,
+	lease time.Duration,
+	maxAttempts int,
+	retryBackoff backoff.Backoff,
+) *Dispatcher {
+	return &Dispatcher{
+		log:          log,
+		store:        store,
+		client:       client,
+		secret:       []byte(secret),
+		pollInterval: pollInterval,
+		batchSize:    batchSize,
+		lease:        lease,
+		maxAttempts:  maxAttempts,
+		backoff:      retryBackoff,
+	}
+}
+
+// Sign returns the signature header value of a request body sent at the
+// given Unix time.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run delivers due webhooks until ctx is cancelled. Attempts in flight when
+// it is cancelled are finished, within the client timeout, and recorded
+// before it returns. A delivery interrupted by a crash is retried once its
+// lease expires.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	const op = "services.webhook.Run"
+
+	log := d.log.With(
+		slog.String("op", op),
+	)
+
+	log.Info("webhook dispatcher started")
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			n, err := d.dispatchBatch(context.WithoutCancel(ctx))
+			if err != nil {
+				log.Error("failed to dispatch webhooks", sl.Err(err))
+				break
+			}
+			if n < d.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("webhook dispatcher stopped")
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dispatcher) dispatchBatch(ctx context.Context) (int, error) {
+	const op = "services.webhook.dispatchBatch"
+
+	webhooks, err := d.store.FetchWebhooks(ctx, d.batchSize, d.lease)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var wg sync.WaitGroup
+	for _, w := range webhooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.deliver(ctx, w)
+		}()
+	}
+	wg.Wait()
+
+	return len(webhooks), nil
+}
+
+// deliver makes one attempt at a webhook and records its outcome.
+func (d *Dispatcher) deliver(ctx context.Context, w models.Webhook) {
+	const op = "services.webhook.deliver"
+
+	log := d.log.With(
+		slog.String("op", op),
+		slog.Int64("delivery_id", w.ID),
+		slog.Int64("msgID", w.Payload.MsgID),
+	)
+
+	attempt := models.WebhookAttempt{
+		DeliveryID: w.ID,
+		Attempt:    w.Attempts + 1,
+	}
+
+	start := time.Now()
+	statusCode, err := d.send(ctx, w)
+	attempt.DurationMS = time.Since(start).Milliseconds()
+	attempt.StatusCode = statusCode
+
+	state := models.WebhookDelivered
+	var retryIn time.Duration
+	if err != nil {
+		attempt.Error = err.Error()
+
+		if attempt.Attempt >= d.maxAttempts {
+			state = models.WebhookFailed
+			log.Error("webhook delivery failed, giving up",
+				slog.Int("attempts", attempt.Attempt),
+				sl.Err(err),
+			)
+		} else {
+			state = models.WebhookPending
+			retryIn = d.backoff.Duration(attempt.Attempt)
+			log.Warn("webhook delivery failed, retrying",
+				slog.Int("attempts", attempt.Attempt),
+				slog.Duration("retry_in", retryIn),
+				sl.Err(err),
+			)
+		}
+	} else {
+		log.Info("webhook delivered", slog.Int("attempts", attempt.Attempt))
+	}
+
+	if err := d.store.RecordWebhookAttempt(ctx, attempt, state, retryIn); err != nil {
+		log.Error("failed to record webhook attempt", sl.Err(err))
+	}
+}
+
+// send posts the payload of a webhook and returns the response status code,
+// or 0 when no response was received.
+func (d *Dispatcher) send(ctx context.Context, w models.Webhook) (int, error) {
+	body, err := json.Marshal(w.Payload)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(w.ID, 10))
+	req.Header.Set(HeaderSignature, Sign(d.secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, maxResponseBytes))
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// WebhookDeliveries returns the deliveries of a message with their attempts.
+func (d *Dispatcher) WebhookDeliveries(
+	ctx context.Context,
+	msgID int64,
+) ([]models.WebhookDelivery, error) {
+	const op = "services.webhook.WebhookDeliveries"
+
+	log := d.log.With(
+		slog.String("op", op),
+		slog.Int64("msgID", msgID),
+	)
+
+	log.Info("getting webhook deliveries")
+
+	deliveries, err := d.store.WebhookDeliveries(ctx, msgID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMsgNotFound) {
+			log.Warn("message not found", sl.Err(err))
+
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to get webhook deliveries", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
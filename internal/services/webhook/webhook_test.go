@@ -0,0 +1,368 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/backoff"
+	"msgproc/internal/storage"
+	"msgproc/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+// retryStore records the delays the dispatcher asks for between attempts.
+type retryStore struct {
+	*memory.Storage
+
+	mu      sync.Mutex
+	retries []time.Duration
+}
+
+func (s *retryStore) RecordWebhookAttempt(
+	ctx context.Context,
+	attempt models.WebhookAttempt,
+	state string,
+	retryIn time.Duration,
+) error {
+	if state == models.WebhookPending {
+		s.mu.Lock()
+		s.retries = append(s.retries, retryIn)
+		s.mu.Unlock()
+	}
+
+	return s.Storage.RecordWebhookAttempt(ctx, attempt, state, retryIn)
+}
+
+func newTestDispatcher(store storage.Webhooks, client *http.Client, maxAttempts int) *Dispatcher {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return New(log, store, client, testSecret, time.Hour, 10, time.Minute, maxAttempts, backoff.Backoff{
+		Initial:    5 * time.Millisecond,
+		Max:        10 * time.Millisecond,
+		Multiplier: 2,
+	})
+}
+
+// completeMsg saves a message with a callback URL and completes it, which
+// queues its webhook.
+func completeMsg(t *testing.T, store *retryStore, url string) int64 {
+	t.Helper()
+
+	ctx := context.Background()
+
+	msgID, err := store.SaveMsg(ctx, models.NewMsg{Content: "hello", CallbackURL: url})
+	if err != nil {
+		t.Fatalf("SaveMsg: %v", err)
+	}
+
+	for _, status := range []string{models.StatusQueued, models.StatusProcessing, models.StatusCompleted} {
+		if err := store.UpdateMsgStatus(ctx, msgID, status, models.Change{Actor: "test"}); err != nil {
+			t.Fatalf("UpdateMsgStatus %s: %v", status, err)
+		}
+	}
+
+	return msgID
+}
+
+// dispatch runs the dispatcher until the webhook of the message is no
+// longer pending and returns its delivery.
+func dispatch(t *testing.T, d *Dispatcher, store *retryStore, msgID int64) models.WebhookDelivery {
+	t.Helper()
+
+	ctx := context.Background()
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if _, err := d.dispatchBatch(ctx); err != nil {
+			t.Fatalf("dispatchBatch: %v", err)
+		}
+
+		deliveries, err := store.WebhookDeliveries(ctx, msgID)
+		if err != nil {
+			t.Fatalf("WebhookDeliveries: %v", err)
+		}
+		if len(deliveries) != 1 {
+			t.Fatalf("got %d deliveries, want 1", len(deliveries))
+		}
+		if deliveries[0].State != models.WebhookPending {
+			return deliveries[0]
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("timed out waiting for the webhook")
+	return models.WebhookDelivery{}
+}
+
+func statusCodes(log []models.WebhookAttempt) []int {
+	codes := make([]int, 0, len(log))
+	for _, a := range log {
+		codes = append(codes, a.StatusCode)
+	}
+	return codes
+}
+
+func TestDeliverSigned(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header.Clone(), body: body}
+	}))
+	defer srv.Close()
+
+	store := &retryStore{Storage: memory.New()}
+	msgID := completeMsg(t, store, srv.URL)
+
+	delivery := dispatch(t, newTestDispatcher(store, srv.Client(), 3), store, msgID)
+
+	req := <-requests
+	timestamp, err := strconv.ParseInt(req.header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timestamp header %q", req.header.Get(HeaderTimestamp))
+	}
+	if got, want := req.header.Get(HeaderSignature), Sign([]byte(testSecret), timestamp, req.body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if got, want := req.header.Get(HeaderDelivery), strconv.FormatInt(delivery.ID, 10); got != want {
+		t.Errorf("delivery header = %q, want %q", got, want)
+	}
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type = %q, want application/json", got)
+	}
+
+	if delivery.State != models.WebhookDelivered || delivery.Attempts != 1 {
+		t.Errorf("delivery = %s after %d attempts, want delivered after 1", delivery.State, delivery.Attempts)
+	}
+	if len(delivery.Log) != 1 || delivery.Log[0].StatusCode != http.StatusOK || delivery.Log[0].Error != "" {
+		t.Errorf("log = %+v, want one successful attempt", delivery.Log)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// fail fails the first two attempts, counting from 1.
+		fail      func(w http.ResponseWriter, attempt int)
+		wantCodes []int
+	}{
+		{
+			name: "server errors",
+			fail: func(w http.ResponseWriter, attempt int) {
+				if attempt < 3 {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			},
+			wantCodes: []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK},
+		},
+		{
+			name: "timeouts",
+			fail: func(w http.ResponseWriter, attempt int) {
+				if attempt < 3 {
+					time.Sleep(200 * time.Millisecond)
+				}
+			},
+			wantCodes: []int{0, 0, http.StatusOK},
+		},
+		{
+			name: "redirect",
+			fail: func(w http.ResponseWriter, attempt int) {
+				if attempt < 3 {
+					w.Header().Set("Location", "/elsewhere")
+					w.WriteHeader(http.StatusFound)
+				}
+			},
+			wantCodes: []int{http.StatusFound, http.StatusFound, http.StatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/" {
+					t.Errorf("request to %s, want redirects not followed", r.URL.Path)
+				}
+				tt.fail(w, int(attempts.Add(1)))
+			}))
+			defer srv.Close()
+
+			client := NewClient(50*time.Millisecond, nil)
+			// The test server listens on loopback.
+			client.Transport = srv.Client().Transport
+
+			store := &retryStore{Storage: memory.New()}
+			msgID := completeMsg(t, store, srv.URL)
+
+			delivery := dispatch(t, newTestDispatcher(store, client, 5), store, msgID)
+
+			if delivery.State != models.WebhookDelivered || delivery.Attempts != 3 {
+				t.Errorf("delivery = %s after %d attempts, want delivered after 3", delivery.State, delivery.Attempts)
+			}
+			if got := statusCodes(delivery.Log); !slices.Equal(got, tt.wantCodes) {
+				t.Errorf("status codes = %v, want %v", got, tt.wantCodes)
+			}
+			for i, a := range delivery.Log {
+				if (a.Error != "") != (i < 2) {
+					t.Errorf("attempt %d error = %q", a.Attempt, a.Error)
+				}
+				if a.Attempt != i+1 {
+					t.Errorf("attempt %d logged as %d", i+1, a.Attempt)
+				}
+			}
+
+			if want := []time.Duration{5 * time.Millisecond, 10 * time.Millisecond}; !slices.Equal(store.retries, want) {
+				t.Errorf("retry delays = %v, want %v", store.retries, want)
+			}
+		})
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	store := &retryStore{Storage: memory.New()}
+	msgID := completeMsg(t, store, srv.URL)
+
+	delivery := dispatch(t, newTestDispatcher(store, srv.Client(), 3), store, msgID)
+
+	if delivery.State != models.WebhookFailed || delivery.Attempts != 3 {
+		t.Errorf("delivery = %s after %d attempts, want failed after 3", delivery.State, delivery.Attempts)
+	}
+	if got, want := statusCodes(delivery.Log), []int{503, 503, 503}; !slices.Equal(got, want) {
+		t.Errorf("status codes = %v, want %v", got, want)
+	}
+	if len(store.retries) != 2 {
+		t.Errorf("retried %d times, want 2", len(store.retries))
+	}
+
+	// A failed delivery is not attempted again.
+	if _, err := newTestDispatcher(store, srv.Client(), 3).dispatchBatch(context.Background()); err != nil {
+		t.Fatalf("dispatchBatch: %v", err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+}
+
+func TestClientDestinations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		allowedHosts []string
+	}{
+		{name: "loopback"},
+		{name: "loopback allowed by host", allowedHosts: []string{"127.0.0.1"}},
+		{name: "host not allowed", allowedHosts: []string{"example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := NewClient(time.Second, tt.allowedHosts).Post(srv.URL, "application/json", nil)
+			if err == nil {
+				res.Body.Close()
+			}
+			if !errors.Is(err, ErrForbiddenDestination) {
+				t.Errorf("error = %v, want %v", err, ErrForbiddenDestination)
+			}
+		})
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{address: "127.0.0.1:80"},
+		{address: "[::1]:80"},
+		{address: "10.1.2.3:80"},
+		{address: "172.16.0.1:80"},
+		{address: "192.168.1.1:443"},
+		{address: "169.254.169.254:80"},
+		{address: "[fe80::1]:80"},
+		{address: "[fd00::1]:80"},
+		{address: "0.0.0.0:80"},
+		{address: "[::]:80"},
+		{address: "[::ffff:127.0.0.1]:80"},
+		{address: "0.1.2.3:80"},
+		{address: "100.100.100.200:80"},
+		{address: "198.18.0.1:80"},
+		{address: "224.0.0.1:80"},
+		{address: "[ff02::1]:80"},
+		{address: "255.255.255.255:80"},
+		{address: "[64:ff9b::a9fe:a9fe]:80"},
+		{address: "93.184.216.34:443", allowed: true},
+		{address: "[2606:4700::1111]:443", allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := checkAddress("tcp", tt.address, nil)
+			if tt.allowed && err != nil {
+				t.Errorf("checkAddress: %v, want allowed", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrForbiddenDestination) {
+				t.Errorf("checkAddress = %v, want %v", err, ErrForbiddenDestination)
+			}
+		})
+	}
+}
+
+func TestRunFinishesInFlightAttempt(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer srv.Close()
+
+	store := &retryStore{Storage: memory.New()}
+	msgID := completeMsg(t, store, srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- newTestDispatcher(store, srv.Client(), 3).Run(ctx)
+	}()
+
+	<-started
+	cancel()
+	close(release)
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v, want %v", err, context.Canceled)
+	}
+
+	deliveries, err := store.WebhookDeliveries(context.Background(), msgID)
+	if err != nil {
+		t.Fatalf("WebhookDeliveries: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].State != models.WebhookDelivered || len(deliveries[0].Log) != 1 {
+		t.Errorf("deliveries = %+v, want one delivered with its attempt logged", deliveries)
+	}
+}
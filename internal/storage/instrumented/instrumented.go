@@ -0,0 +1,172 @@
+package instrumented
+
+import (
+	"context"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/metrics"
+	"msgproc/internal/storage"
+	"time"
+)
+
+// Storage records latency and error metrics for every call to the wrapped
+// backend, labelled by method name.
+type Storage struct {
+	next storage.Storage
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func New(next storage.Storage) *Storage {
+	return &Storage{next: next}
+}
+
+func (s *Storage) Ping(ctx context.Context) (err error) {
+	defer metrics.ObserveQuery("Ping", time.Now(), &err)
+	return s.next.Ping(ctx)
+}
+
+func (s *Storage) MigrationVersion(ctx context.Context, table string) (_ uint, _ bool, err error) {
+	defer metrics.ObserveQuery("MigrationVersion", time.Now(), &err)
+	return s.next.MigrationVersion(ctx, table)
+}
+
+func (s *Storage) SaveMsg(ctx context.Context, msg models.NewMsg) (_ int64, err error) {
+	defer metrics.ObserveQuery("SaveMsg", time.Now(), &err)
+	return s.next.SaveMsg(ctx, msg)
+}
+
+func (s *Storage) SaveMsgs(ctx context.Context, msgs []models.NewMsg) (_ []int64, err error) {
+	defer metrics.ObserveQuery("SaveMsgs", time.Now(), &err)
+	return s.next.SaveMsgs(ctx, msgs)
+}
+
+func (s *Storage) SaveMsgIdempotent(
+	ctx context.Context,
+	msg models.NewMsg,
+	key string,
+	requestHash string,
+	ttl time.Duration,
+) (_ int64, _ bool, err error) {
+	defer metrics.ObserveQuery("SaveMsgIdempotent", time.Now(), &err)
+	return s.next.SaveMsgIdempotent(ctx, msg, key, requestHash, ttl)
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context) (_ int64, err error) {
+	defer metrics.ObserveQuery("DeleteExpiredIdempotencyKeys", time.Now(), &err)
+	return s.next.DeleteExpiredIdempotencyKeys(ctx)
+}
+
+func (s *Storage) Msg(ctx context.Context, msgID int64) (_ *models.Message, err error) {
+	defer metrics.ObserveQuery("Msg", time.Now(), &err)
+	return s.next.Msg(ctx, msgID)
+}
+
+func (s *Storage) ListMsgs(ctx context.Context, filter models.MsgFilter) (_ *models.MsgPage, err error) {
+	defer metrics.ObserveQuery("ListMsgs", time.Now(), &err)
+	return s.next.ListMsgs(ctx, filter)
+}
+
+func (s *Storage) FetchOutbox(ctx context.Context, limit int, lease time.Duration) (_ []models.OutboxMsg, err error) {
+	defer metrics.ObserveQuery("FetchOutbox", time.Now(), &err)
+	return s.next.FetchOutbox(ctx, limit, lease)
+}
+
+func (s *Storage) MarkOutboxSent(ctx context.Context, ids []int64) (err error) {
+	defer metrics.ObserveQuery("MarkOutboxSent", time.Now(), &err)
+	return s.next.MarkOutboxSent(ctx, ids)
+}
+
+func (s *Storage) FailOutbox(ctx context.Context, id int64, reason string) (err error) {
+	defer metrics.ObserveQuery("FailOutbox", time.Now(), &err)
+	return s.next.FailOutbox(ctx, id, reason)
+}
+
+func (s *Storage) OutboxPending(ctx context.Context, msgID int64) (_ bool, err error) {
+	defer metrics.ObserveQuery("OutboxPending", time.Now(), &err)
+	return s.next.OutboxPending(ctx, msgID)
+}
+
+func (s *Storage) FetchWebhooks(ctx context.Context, limit int, lease time.Duration) (_ []models.Webhook, err error) {
+	defer metrics.ObserveQuery("FetchWebhooks", time.Now(), &err)
+	return s.next.FetchWebhooks(ctx, limit, lease)
+}
+
+func (s *Storage) RecordWebhookAttempt(
+	ctx context.Context,
+	attempt models.WebhookAttempt,
+	state string,
+	retryIn time.Duration,
+) (err error) {
+	defer metrics.ObserveQuery("RecordWebhookAttempt", time.Now(), &err)
+	return s.next.RecordWebhookAttempt(ctx, attempt, state, retryIn)
+}
+
+func (s *Storage) WebhookDeliveries(ctx context.Context, msgID int64) (_ []models.WebhookDelivery, err error) {
+	defer metrics.ObserveQuery("WebhookDeliveries", time.Now(), &err)
+	return s.next.WebhookDeliveries(ctx, msgID)
+}
+
+func (s *Storage) StatsSummary(ctx context.Context) (_ models.StatsSummary, err error) {
+	defer metrics.ObserveQuery("StatsSummary", time.Now(), &err)
+	return s.next.StatsSummary(ctx)
+}
+
+func (s *Storage) MessagesByStatus(ctx context.Context) (_ map[string]int64, err error) {
+	defer metrics.ObserveQuery("MessagesByStatus", time.Now(), &err)
+	return s.next.MessagesByStatus(ctx)
+}
+
+func (s *Storage) RefreshStats(ctx context.Context) (err error) {
+	defer metrics.ObserveQuery("RefreshStats", time.Now(), &err)
+	return s.next.RefreshStats(ctx)
+}
+
+func (s *Storage) RebuildStats(ctx context.Context) (err error) {
+	defer metrics.ObserveQuery("RebuildStats", time.Now(), &err)
+	return s.next.RebuildStats(ctx)
+}
+
+func (s *Storage) MessageSeries(ctx context.Context, filter models.SeriesFilter) (_ []models.SeriesPoint, err error) {
+	defer metrics.ObserveQuery("MessageSeries", time.Now(), &err)
+	return s.next.MessageSeries(ctx, filter)
+}
+
+func (s *Storage) ProcessingLatency(ctx context.Context, from, to time.Time) (_ models.ProcessingLatency, err error) {
+	defer metrics.ObserveQuery("ProcessingLatency", time.Now(), &err)
+	return s.next.ProcessingLatency(ctx, from, to)
+}
+
+func (s *Storage) MsgHistory(ctx context.Context, msgID int64) (_ []models.StatusHistoryEntry, err error) {
+	defer metrics.ObserveQuery("MsgHistory", time.Now(), &err)
+	return s.next.MsgHistory(ctx, msgID)
+}
+
+func (s *Storage) StatusEvents(ctx context.Context, filter models.EventFilter) (_ []models.StatusHistoryEntry, err error) {
+	defer metrics.ObserveQuery("StatusEvents", time.Now(), &err)
+	return s.next.StatusEvents(ctx, filter)
+}
+
+func (s *Storage) LastStatusEventID(ctx context.Context) (_ int64, err error) {
+	defer metrics.ObserveQuery("LastStatusEventID", time.Now(), &err)
+	return s.next.LastStatusEventID(ctx)
+}
+
+func (s *Storage) UpdateMsgStatus(ctx context.Context, msgID int64, status string, change models.Change) (err error) {
+	defer metrics.ObserveQuery("UpdateMsgStatus", time.Now(), &err)
+	return s.next.UpdateMsgStatus(ctx, msgID, status, change)
+}
+
+func (s *Storage) UpdateMsg(ctx context.Context, msgID int64, msg string, change models.Change) (err error) {
+	defer metrics.ObserveQuery("UpdateMsg", time.Now(), &err)
+	return s.next.UpdateMsg(ctx, msgID, msg, change)
+}
+
+func (s *Storage) SaveResult(ctx context.Context, res models.ProcessingResult) (err error) {
+	defer metrics.ObserveQuery("SaveResult", time.Now(), &err)
+	return s.next.SaveResult(ctx, res)
+}
+
+func (s *Storage) SaveResults(ctx context.Context, results []models.ProcessingResult) (_ []error, err error) {
+	defer metrics.ObserveQuery("SaveResults", time.Now(), &err)
+	return s.next.SaveResults(ctx, results)
+}
@@ -0,0 +1,53 @@
+package instrumented
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/metrics"
+	"msgproc/internal/storage"
+	"testing"
+)
+
+// listStorage serves ListMsgs only, failing with err when it is set.
+type listStorage struct {
+	storage.Storage
+	err error
+}
+
+var errBackend = errors.New("backend unavailable")
+
+func (s listStorage) ListMsgs(context.Context, models.MsgFilter) (*models.MsgPage, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return &models.MsgPage{}, nil
+}
+
+func TestErrorsCounted(t *testing.T) {
+	ctx := context.Background()
+	errorsTotal := metrics.StorageQueryErrors.WithLabelValues("ListMsgs")
+	filter := models.MsgFilter{Limit: 10}
+
+	before := testutil.ToFloat64(errorsTotal)
+	if _, err := New(listStorage{}).ListMsgs(ctx, filter); err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+	if got := testutil.ToFloat64(errorsTotal) - before; got != 0 {
+		t.Errorf("errors increased by %v after a successful call, want 0", got)
+	}
+
+	before = testutil.ToFloat64(errorsTotal)
+	if _, err := New(listStorage{err: errBackend}).ListMsgs(ctx, filter); !errors.Is(err, errBackend) {
+		t.Fatalf("ListMsgs error = %v, want %v", err, errBackend)
+	}
+	if got := testutil.ToFloat64(errorsTotal) - before; got != 1 {
+		t.Errorf("errors increased by %v after a failed call, want 1", got)
+	}
+
+	if n := testutil.CollectAndCount(metrics.StorageQueryDuration, "msgproc_storage_query_duration_seconds"); n == 0 {
+		t.Error("no query durations recorded")
+	}
+}
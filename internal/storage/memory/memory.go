@@ -0,0 +1,965 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/cursor"
+	"msgproc/internal/storage"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Storage keeps everything in process memory. It is meant for local and dev
+// runs without Postgres; all data is lost on restart.
+type Storage struct {
+	mu sync.Mutex
+
+	msgs      map[int64]*models.Message
+	lastMsgID int64
+
+	history       map[int64][]models.StatusHistoryEntry
+	lastHistoryID int64
+
+	outbox       map[int64]*outboxEntry
+	lastOutboxID int64
+
+	idempotencyKeys map[string]idempotencyKey
+
+	webhooks      map[int64]*webhookEntry
+	lastWebhookID int64
+
+	now func() time.Time
+}
+
+type outboxEntry struct {
+	models.OutboxMsg
+	lastError   string
+	lockedUntil time.Time
+}
+
+type webhookEntry struct {
+	models.WebhookDelivery
+	payload       models.WebhookPayload
+	nextAttemptAt time.Time
+	lockedUntil   time.Time
+}
+
+type idempotencyKey struct {
+	requestHash string
+	msgID       int64
+	expiresAt   time.Time
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func New() *Storage {
+	return &Storage{
+		msgs:            make(map[int64]*models.Message),
+		history:         make(map[int64][]models.StatusHistoryEntry),
+		outbox:          make(map[int64]*outboxEntry),
+		idempotencyKeys: make(map[string]idempotencyKey),
+		webhooks:        make(map[int64]*webhookEntry),
+		now: func() time.Time {
+			// Postgres keeps microseconds, so cursors compare the same way.
+			return time.Now().UTC().Truncate(time.Microsecond)
+		},
+	}
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// MigrationVersion always reports a clean schema: there is nothing to migrate.
+func (s *Storage) MigrationVersion(ctx context.Context, _ string) (uint, bool, error) {
+	return 0, false, ctx.Err()
+}
+
+func (s *Storage) SaveMsg(ctx context.Context, msg models.NewMsg) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertMsg(msg), nil
+}
+
+func (s *Storage) SaveMsgs(ctx context.Context, msgs []models.NewMsg) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, s.insertMsg(msg))
+	}
+
+	return ids, nil
+}
+
+func (s *Storage) SaveMsgIdempotent(
+	ctx context.Context,
+	msg models.NewMsg,
+	key string,
+	requestHash string,
+	ttl time.Duration,
+) (int64, bool, error) {
+	const op = "internal/storage/memory.SaveMsgIdempotent"
+
+	if err := ctx.Err(); err != nil {
+		return 0, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	if k, ok := s.idempotencyKeys[key]; ok && k.expiresAt.After(now) {
+		if k.requestHash != requestHash {
+			return 0, false, fmt.Errorf("%s: %w", op, storage.ErrIdempotencyKeyReused)
+		}
+
+		return k.msgID, true, nil
+	}
+
+	msgID := s.insertMsg(msg)
+	s.idempotencyKeys[key] = idempotencyKey{
+		requestHash: requestHash,
+		msgID:       msgID,
+		expiresAt:   now.Add(ttl),
+	}
+
+	return msgID, false, nil
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	var n int64
+	for key, k := range s.idempotencyKeys {
+		if !k.expiresAt.After(now) {
+			delete(s.idempotencyKeys, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// insertMsg stores a new message and its outbox entry. s.mu must be held.
+func (s *Storage) insertMsg(msg models.NewMsg) int64 {
+	now := s.now()
+
+	s.lastMsgID++
+	id := s.lastMsgID
+
+	s.msgs[id] = &models.Message{
+		ID:          id,
+		Content:     msg.Content,
+		Status:      models.StatusNew,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		CallbackURL: msg.CallbackURL,
+	}
+
+	s.addHistory(id, "", models.StatusNew, models.Change{Actor: models.ActorAPI})
+
+	s.lastOutboxID++
+	s.outbox[s.lastOutboxID] = &outboxEntry{
+		OutboxMsg: models.OutboxMsg{
+			ID:        s.lastOutboxID,
+			MsgID:     id,
+			Content:   msg.Content,
+			RequestID: msg.RequestID,
+			CreatedAt: now,
+		},
+	}
+
+	return id
+}
+
+func (s *Storage) addHistory(msgID int64, oldStatus, newStatus string, change models.Change) {
+	s.lastHistoryID++
+
+	entry := models.StatusHistoryEntry{
+		ID:        s.lastHistoryID,
+		MsgID:     msgID,
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		Actor:     change.Actor,
+		Reason:    change.Reason,
+		CreatedAt: s.now(),
+	}
+	if change.Partition != nil {
+		p := *change.Partition
+		entry.Partition = &p
+	}
+	if change.Offset != nil {
+		o := *change.Offset
+		entry.Offset = &o
+	}
+
+	s.history[msgID] = append(s.history[msgID], entry)
+}
+
+func (s *Storage) Msg(ctx context.Context, msgID int64) (*models.Message, error) {
+	const op = "internal/storage/memory.Msg"
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.msgs[msgID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+	}
+
+	m := *msg
+
+	return &m, nil
+}
+
+func (s *Storage) ListMsgs(ctx context.Context, filter models.MsgFilter) (*models.MsgPage, error) {
+	const op = "internal/storage/memory.ListMsgs"
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sortCol := models.SortByID
+	switch filter.SortBy {
+	case "", models.SortByID:
+	case models.SortByCreatedAt, models.SortByUpdatedAt:
+		sortCol = filter.SortBy
+	default:
+		return nil, fmt.Errorf("%s: unknown sort column %q", op, filter.SortBy)
+	}
+
+	var after *cursor.Cursor
+	if filter.Cursor != "" {
+		c, err := cursor.Decode(filter.Cursor)
+		if err != nil || c.SortBy != sortCol || c.Desc != filter.Desc {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrInvalidCursor)
+		}
+		after = &c
+	}
+
+	sortKey := func(m *models.Message) time.Time {
+		switch sortCol {
+		case models.SortByCreatedAt:
+			return m.CreatedAt
+		case models.SortByUpdatedAt:
+			return m.UpdatedAt
+		}
+		return time.Time{}
+	}
+
+	// less orders messages ascending by the sort column, then by id.
+	less := func(a *models.Message, aTime time.Time, bID int64, bTime time.Time) bool {
+		if !aTime.Equal(bTime) {
+			return aTime.Before(bTime)
+		}
+		return a.ID < bID
+	}
+
+	s.mu.Lock()
+
+	var msgs []*models.Message
+	for _, m := range s.msgs {
+		if !matches(m, filter) {
+			continue
+		}
+
+		if after != nil {
+			t := sortKey(m)
+			if filter.Desc && !less(m, t, after.ID, after.Time) {
+				continue
+			}
+			if !filter.Desc && (less(m, t, after.ID, after.Time) || m.ID == after.ID && t.Equal(after.Time)) {
+				continue
+			}
+		}
+
+		m := *m
+		msgs = append(msgs, &m)
+	}
+
+	s.mu.Unlock()
+
+	sort.Slice(msgs, func(i, j int) bool {
+		if filter.Desc {
+			i, j = j, i
+		}
+		return less(msgs[i], sortKey(msgs[i]), msgs[j].ID, sortKey(msgs[j]))
+	})
+
+	// One extra message tells whether there is a next page.
+	if len(msgs) > filter.Limit+1 {
+		msgs = msgs[:filter.Limit+1]
+	}
+
+	page := &models.MsgPage{
+		Msgs: make([]models.Message, 0, len(msgs)),
+	}
+	for _, m := range msgs {
+		page.Msgs = append(page.Msgs, *m)
+	}
+
+	if len(page.Msgs) > filter.Limit {
+		page.Msgs = page.Msgs[:filter.Limit]
+
+		last := page.Msgs[len(page.Msgs)-1]
+		next := cursor.Cursor{
+			SortBy: sortCol,
+			Desc:   filter.Desc,
+			ID:     last.ID,
+		}
+		switch sortCol {
+		case models.SortByCreatedAt:
+			next.Time = last.CreatedAt
+		case models.SortByUpdatedAt:
+			next.Time = last.UpdatedAt
+		}
+		page.NextCursor = cursor.Encode(next)
+	}
+
+	return page, nil
+}
+
+func matches(m *models.Message, filter models.MsgFilter) bool {
+	if len(filter.Statuses) > 0 {
+		found := false
+		for _, status := range filter.Statuses {
+			if m.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	switch {
+	case filter.CreatedFrom != nil && m.CreatedAt.Before(*filter.CreatedFrom),
+		filter.CreatedTo != nil && !m.CreatedAt.Before(*filter.CreatedTo),
+		filter.UpdatedFrom != nil && m.UpdatedAt.Before(*filter.UpdatedFrom),
+		filter.UpdatedTo != nil && !m.UpdatedAt.Before(*filter.UpdatedTo):
+		return false
+	}
+
+	return strings.HasPrefix(m.Content, filter.ContentPrefix)
+}
+
+func (s *Storage) MsgHistory(ctx context.Context, msgID int64) ([]models.StatusHistoryEntry, error) {
+	const op = "internal/storage/memory.MsgHistory"
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.msgs[msgID]; !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+	}
+
+	return append(make([]models.StatusHistoryEntry, 0, len(s.history[msgID])), s.history[msgID]...), nil
+}
+
+func (s *Storage) StatusEvents(ctx context.Context, filter models.EventFilter) ([]models.StatusHistoryEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]models.StatusHistoryEntry, 0)
+	for _, history := range s.history {
+		for _, e := range history {
+			if e.ID <= filter.AfterID || !filter.Matches(e) {
+				continue
+			}
+			if len(filter.IDs) > 0 && !slices.Contains(filter.IDs, e.ID) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	return entries, nil
+}
+
+func (s *Storage) LastStatusEventID(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastHistoryID, nil
+}
+
+func (s *Storage) UpdateMsgStatus(ctx context.Context, msgID int64, status string, change models.Change) error {
+	const op = "internal/storage/memory.UpdateMsgStatus"
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.transition(msgID, status, change, nil); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateMsg(ctx context.Context, msgID int64, msg string, change models.Change) error {
+	const op = "internal/storage/memory.UpdateMsg"
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.msgs[msgID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+	}
+
+	m.Content = msg
+	m.UpdatedAt = s.now()
+
+	if change.Reason == "" {
+		change.Reason = "content updated"
+	}
+	s.addHistory(msgID, m.Status, m.Status, change)
+
+	return nil
+}
+
+func (s *Storage) SaveResult(ctx context.Context, res models.ProcessingResult) error {
+	const op = "internal/storage/memory.SaveResult"
+
+	errs, err := s.SaveResults(ctx, []models.ProcessingResult{res})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if errs[0] != nil {
+		return fmt.Errorf("%s: %w", op, errs[0])
+	}
+
+	return nil
+}
+
+func (s *Storage) SaveResults(ctx context.Context, results []models.ProcessingResult) ([]error, error) {
+	const op = "internal/storage/memory.SaveResults"
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]struct{}, len(results))
+	for _, res := range results {
+		if !models.IsValidStatus(res.Status) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrInvalidStatus)
+		}
+		if _, ok := seen[res.MsgID]; ok {
+			return nil, fmt.Errorf("%s: duplicate result for message %d", op, res.MsgID)
+		}
+		seen[res.MsgID] = struct{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(results))
+	for i, res := range results {
+		_, err := s.transition(res.MsgID, res.Status, res.Change, func(m *models.Message) {
+			if res.Content != nil {
+				m.Content = *res.Content
+			}
+			m.Attempts = res.Attempts
+			m.LastError = res.Error
+		})
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+	}
+
+	return errs, nil
+}
+
+// transition moves a message to status if its lifecycle allows it, applies
+// set to it when set is not nil and records the change. s.mu must be held.
+func (s *Storage) transition(
+	msgID int64,
+	status string,
+	change models.Change,
+	set func(m *models.Message),
+) (*models.Message, error) {
+	if !models.IsValidStatus(status) {
+		return nil, storage.ErrInvalidStatus
+	}
+
+	m, ok := s.msgs[msgID]
+	if !ok {
+		return nil, storage.ErrMsgNotFound
+	}
+
+	if !models.CanTransition(m.Status, status) {
+		return nil, fmt.Errorf("%w: %s -> %s", storage.ErrInvalidTransition, m.Status, status)
+	}
+
+	s.addHistory(msgID, m.Status, status, change)
+
+	now := s.now()
+
+	switch {
+	case status == models.StatusProcessing && m.Status != models.StatusProcessing:
+		m.ProcessingStartedAt = &now
+	case status == models.StatusCompleted || status == models.StatusFailed:
+		m.ProcessedAt = &now
+	}
+
+	if set != nil {
+		set(m)
+	}
+
+	oldStatus := m.Status
+	m.Status = status
+	m.UpdatedAt = now
+
+	if m.CallbackURL != "" && oldStatus != status &&
+		(status == models.StatusCompleted || status == models.StatusFailed) {
+		s.enqueueWebhook(m)
+	}
+
+	return m, nil
+}
+
+// enqueueWebhook queues a delivery of the message as it is now to its
+// callback URL. s.mu must be held.
+func (s *Storage) enqueueWebhook(m *models.Message) {
+	now := s.now()
+
+	s.lastWebhookID++
+	id := s.lastWebhookID
+
+	payload := models.WebhookPayload{
+		DeliveryID: id,
+		MsgID:      m.ID,
+		Status:     m.Status,
+		Content:    m.Content,
+		LastError:  m.LastError,
+	}
+	if m.ProcessedAt != nil {
+		processedAt := *m.ProcessedAt
+		payload.ProcessedAt = &processedAt
+	}
+
+	s.webhooks[id] = &webhookEntry{
+		WebhookDelivery: models.WebhookDelivery{
+			ID:        id,
+			MsgID:     m.ID,
+			URL:       m.CallbackURL,
+			Status:    m.Status,
+			State:     models.WebhookPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		payload:       payload,
+		nextAttemptAt: now,
+	}
+}
+
+func (s *Storage) FetchOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMsg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	ids := make([]int64, 0, len(s.outbox))
+	for id, e := range s.outbox {
+		if e.lockedUntil.IsZero() || e.lockedUntil.Before(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	entries := make([]models.OutboxMsg, 0, len(ids))
+	for _, id := range ids {
+		e := s.outbox[id]
+		e.lockedUntil = now.Add(lease)
+		e.Attempts++
+		entries = append(entries, e.OutboxMsg)
+	}
+
+	return entries, nil
+}
+
+func (s *Storage) MarkOutboxSent(ctx context.Context, ids []int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		e, ok := s.outbox[id]
+		if !ok {
+			continue
+		}
+		delete(s.outbox, id)
+
+		if m, ok := s.msgs[e.MsgID]; ok && m.Status == models.StatusNew {
+			s.addHistory(m.ID, models.StatusNew, models.StatusQueued, models.Change{Actor: models.ActorRelay})
+
+			m.Status = models.StatusQueued
+			m.UpdatedAt = s.now()
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) FailOutbox(ctx context.Context, id int64, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.outbox[id]; ok {
+		e.lastError = reason
+	}
+
+	return nil
+}
+
+func (s *Storage) OutboxPending(ctx context.Context, msgID int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.outbox {
+		if e.MsgID == msgID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *Storage) FetchWebhooks(ctx context.Context, limit int, lease time.Duration) ([]models.Webhook, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	ids := make([]int64, 0, len(s.webhooks))
+	for id, w := range s.webhooks {
+		if w.State != models.WebhookPending || w.nextAttemptAt.After(now) {
+			continue
+		}
+		if w.lockedUntil.IsZero() || w.lockedUntil.Before(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	webhooks := make([]models.Webhook, 0, len(ids))
+	for _, id := range ids {
+		w := s.webhooks[id]
+		w.lockedUntil = now.Add(lease)
+		webhooks = append(webhooks, models.Webhook{
+			ID:       w.ID,
+			URL:      w.URL,
+			Attempts: w.Attempts,
+			Payload:  w.payload,
+		})
+	}
+
+	return webhooks, nil
+}
+
+func (s *Storage) RecordWebhookAttempt(
+	ctx context.Context,
+	attempt models.WebhookAttempt,
+	state string,
+	retryIn time.Duration,
+) error {
+	const op = "internal/storage/memory.RecordWebhookAttempt"
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.webhooks[attempt.DeliveryID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+	}
+
+	now := s.now()
+
+	attempt.CreatedAt = now
+	w.Log = append(w.Log, attempt)
+	w.Attempts = attempt.Attempt
+	w.State = state
+	if state == models.WebhookPending {
+		w.nextAttemptAt = now.Add(retryIn)
+	}
+	w.lockedUntil = time.Time{}
+	w.UpdatedAt = now
+
+	return nil
+}
+
+func (s *Storage) WebhookDeliveries(ctx context.Context, msgID int64) ([]models.WebhookDelivery, error) {
+	const op = "internal/storage/memory.WebhookDeliveries"
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.msgs[msgID]; !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for _, w := range s.webhooks {
+		if w.MsgID != msgID {
+			continue
+		}
+
+		d := w.WebhookDelivery
+		d.Log = append(make([]models.WebhookAttempt, 0, len(w.Log)), w.Log...)
+		if d.State == models.WebhookPending {
+			nextAttemptAt := w.nextAttemptAt
+			d.NextAttemptAt = &nextAttemptAt
+		}
+		deliveries = append(deliveries, d)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID < deliveries[j].ID
+	})
+
+	return deliveries, nil
+}
+
+// StatsSummary computes the statistics from the messages on every call:
+// the memory backend keeps no rollups, so they are always fresh.
+func (s *Storage) StatsSummary(ctx context.Context) (models.StatsSummary, error) {
+	if err := ctx.Err(); err != nil {
+		return models.StatsSummary{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	since := now.Add(-24 * time.Hour)
+
+	summary := models.StatsSummary{
+		TotalMessages:    int64(len(s.msgs)),
+		MessagesByStatus: make(map[string]int64),
+		RefreshedAt:      now,
+	}
+
+	var contentLength int
+	for _, m := range s.msgs {
+		summary.MessagesByStatus[m.Status]++
+		contentLength += utf8.RuneCountInString(m.Content)
+
+		if !m.CreatedAt.Before(since) {
+			summary.MessagesLastDay++
+		}
+		if !m.UpdatedAt.Before(since) {
+			summary.MessagesUpdatedLastDay++
+		}
+	}
+
+	if len(s.msgs) > 0 {
+		summary.AverageMessageLength = float64(contentLength) / float64(len(s.msgs))
+	}
+
+	return summary, nil
+}
+
+func (s *Storage) MessagesByStatus(ctx context.Context) (map[string]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int64)
+	for _, m := range s.msgs {
+		counts[m.Status]++
+	}
+
+	return counts, nil
+}
+
+func (s *Storage) RefreshStats(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *Storage) RebuildStats(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *Storage) MessageSeries(ctx context.Context, filter models.SeriesFilter) ([]models.SeriesPoint, error) {
+	const op = "internal/storage/memory.MessageSeries"
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	size := models.BucketDuration(filter.Bucket)
+	if size == 0 {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrInvalidBucket)
+	}
+
+	start := filter.From.UTC().Truncate(size)
+	end := filter.To.UTC()
+
+	series := make([]models.SeriesPoint, 0, filter.Buckets())
+	for t := start; t.Before(end); t = t.Add(size) {
+		series = append(series, models.SeriesPoint{Time: t})
+	}
+
+	// bucket returns the point t falls into, if it is inside the window.
+	bucket := func(t time.Time) *models.SeriesPoint {
+		if t.Before(start) || !t.Before(end) {
+			return nil
+		}
+		return &series[t.Sub(start)/size]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.msgs {
+		if p := bucket(m.CreatedAt); p != nil {
+			p.Created++
+		}
+	}
+
+	for _, history := range s.history {
+		for _, e := range history {
+			p := bucket(e.CreatedAt)
+			if p == nil {
+				continue
+			}
+
+			switch e.NewStatus {
+			case models.StatusCompleted:
+				p.Completed++
+			case models.StatusFailed:
+				p.Failed++
+			}
+		}
+	}
+
+	return series, nil
+}
+
+func (s *Storage) ProcessingLatency(ctx context.Context, from, to time.Time) (models.ProcessingLatency, error) {
+	if err := ctx.Err(); err != nil {
+		return models.ProcessingLatency{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var e2e, consumer []float64
+	for _, m := range s.msgs {
+		if m.Status != models.StatusCompleted || m.ProcessedAt == nil {
+			continue
+		}
+		if m.ProcessedAt.Before(from) || !m.ProcessedAt.Before(to) {
+			continue
+		}
+
+		e2e = append(e2e, m.ProcessedAt.Sub(m.CreatedAt).Seconds())
+		if m.ProcessingStartedAt != nil {
+			consumer = append(consumer, m.ProcessedAt.Sub(*m.ProcessingStartedAt).Seconds())
+		}
+	}
+
+	return models.ProcessingLatency{
+		EndToEnd: models.NewLatency(e2e),
+		Consumer: models.NewLatency(consumer),
+	}, nil
+}
@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"msgproc/internal/storage"
+	"msgproc/internal/storage/storagetest"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return New()
+	})
+}
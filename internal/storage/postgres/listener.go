@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// statusChannel is notified with the ID of every new status history entry.
+const statusChannel = "msg_status_history"
+
+// Listener receives the IDs of new status history entries, written by any
+// instance, through LISTEN/NOTIFY.
+type Listener struct {
+	listener *pq.Listener
+	ids      chan int64
+	// done stops forwarding once nobody reads the notifications anymore.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewListener(user, pass, name, host string) (*Listener, error) {
+	const op = "internal/storage/postgres.NewListener"
+
+	listener := pq.NewListener(connString(user, pass, name, host), time.Second, time.Minute, nil)
+	if err := listener.Listen(statusChannel); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	l := &Listener{
+		listener: listener,
+		ids:      make(chan int64, 1024),
+		done:     make(chan struct{}),
+	}
+	go l.forward()
+
+	return l, nil
+}
+
+// Notifications delivers the ID of every status history entry as it is
+// committed, and 0 after the connection was re-established, when
+// notifications may have been missed. It is closed by Close.
+func (l *Listener) Notifications() <-chan int64 {
+	return l.ids
+}
+
+func (l *Listener) Close() error {
+	const op = "internal/storage/postgres.Listener.Close"
+
+	l.closeOnce.Do(func() { close(l.done) })
+
+	if err := l.listener.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (l *Listener) forward() {
+	defer close(l.ids)
+
+	// A connection that silently died is only noticed when it is used.
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case n, ok := <-l.listener.Notify:
+			if !ok {
+				return
+			}
+
+			var id int64
+			if n != nil {
+				id, _ = strconv.ParseInt(n.Extra, 10, 64)
+			}
+			select {
+			case l.ids <- id:
+			case <-l.done:
+				return
+			}
+		case <-ping.C:
+			go func() {
+				_ = l.listener.Ping()
+			}()
+		}
+	}
+}
@@ -3,116 +3,1100 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"log"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/cursor"
+	"msgproc/internal/storage"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Storage struct {
 	db *sql.DB
 }
 
+var _ storage.Storage = (*Storage)(nil)
+
 func NewStorage(user, pass, name, host string) (*Storage, error) {
 	const op = "internal/storage/postgres.NewStorage"
 
-	db, err := sql.Open("postgres",
-		fmt.Sprintf(
-			"user=%s"+
-				" password=%s"+
-				" dbname=%s"+
-				" host=%s"+
-				" sslmode=disable",
-			user,
-			pass,
-			name,
-			host,
-		),
+	db, err := sql.Open("postgres", connString(user, pass, name, host))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+func connString(user, pass, name, host string) string {
+	return fmt.Sprintf(
+		"user=%s"+
+			" password=%s"+
+			" dbname=%s"+
+			" host=%s"+
+			" sslmode=disable",
+		user,
+		pass,
+		name,
+		host,
 	)
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "internal/storage/postgres.Ping"
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MigrationVersion reads the schema version recorded by golang-migrate in the
+// given migrations table.
+func (s *Storage) MigrationVersion(ctx context.Context, table string) (uint, bool, error) {
+	const op = "internal/storage/postgres.MigrationVersion"
+
+	var (
+		version int64
+		dirty   bool
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+		    version, dirty
+		FROM
+		    `+pq.QuoteIdentifier(table)+`
+		LIMIT 1
+	`).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return uint(version), dirty, nil
+}
+
+func (s *Storage) SaveMsg(
+	ctx context.Context,
+	msg models.NewMsg,
+) (msgID int64, finalErr error) {
+	const op = "internal/storage/postgres.SaveMsg"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				msgID = 0
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	msgID, err = insertMsg(ctx, tx, msg)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return msgID, nil
+}
+
+// SaveMsgs saves a batch of messages and their outbox entries in a single
+// statement. The returned IDs are in the order of msgs.
+func (s *Storage) SaveMsgs(ctx context.Context, msgs []models.NewMsg) ([]int64, error) {
+	const op = "internal/storage/postgres.SaveMsgs"
+
+	contents := make([]string, len(msgs))
+	requestIDs := make([]string, len(msgs))
+	callbackURLs := make([]string, len(msgs))
+	for i, msg := range msgs {
+		contents[i] = msg.Content
+		requestIDs[i] = msg.RequestID
+		callbackURLs[i] = msg.CallbackURL
+	}
+
+	// IDs are allocated per input position before the insert, so each
+	// request ID goes with its own message whatever order the rows are
+	// written in.
+	rows, err := s.db.QueryContext(ctx, `
+		WITH batch AS (
+		    SELECT
+		        nextval(pg_get_serial_sequence('messages', 'id')) AS id,
+		        content,
+		        NULLIF(callback_url, '') AS callback_url,
+		        NULLIF(request_id, '') AS request_id,
+		        n
+		    FROM
+		        unnest($1::text[], $5::text[], $2::text[]) WITH ORDINALITY AS input (content, callback_url, request_id, n)
+		), inserted AS (
+		    INSERT INTO messages
+		        (id, content, callback_url)
+		    SELECT
+		        id, content, callback_url
+		    FROM
+		        batch
+		    RETURNING id
+		), history AS (
+		    INSERT INTO msg_status_history
+		        (msg_id, new_status, actor)
+		    SELECT
+		        id, $3, $4
+		    FROM
+		        inserted
+		), queued AS (
+		    INSERT INTO outbox
+		        (msg_id, content, request_id)
+		    SELECT
+		        batch.id, batch.content, batch.request_id
+		    FROM
+		        batch
+		        JOIN inserted USING (id)
+		    RETURNING msg_id
+		)
+		SELECT
+		    batch.n, batch.id
+		FROM
+		    batch
+		    JOIN queued ON queued.msg_id = batch.id
+	`, pq.Array(contents), pq.Array(requestIDs), models.StatusNew, models.ActorAPI, pq.Array(callbackURLs))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	ids := make([]int64, len(msgs))
+	for rows.Next() {
+		var n, id int64
+		if err := rows.Scan(&n, &id); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if n < 1 || n > int64(len(ids)) {
+			return nil, fmt.Errorf("%s: unexpected batch position %d", op, n)
+		}
+		ids[n-1] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// SaveMsgIdempotent saves a message unless the idempotency key has already
+// been used. A replay with the same request hash returns the original msgID
+// with replayed set; a different hash yields storage.ErrIdempotencyKeyReused.
+func (s *Storage) SaveMsgIdempotent(
+	ctx context.Context,
+	msg models.NewMsg,
+	key string,
+	requestHash string,
+	ttl time.Duration,
+) (msgID int64, replayed bool, finalErr error) {
+	const op = "internal/storage/postgres.SaveMsgIdempotent"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				msgID, replayed = 0, false
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM
+		    idempotency_keys
+		WHERE
+		    key = $1 AND expires_at <= CURRENT_TIMESTAMP
+	`, key)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// A concurrent request with the same key blocks here until the other
+	// transaction finishes, then sees the key as taken.
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO idempotency_keys
+		    (key, request_hash, expires_at)
+		VALUES
+		    ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO NOTHING
+	`, key, requestHash, ttl.Milliseconds())
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if inserted == 0 {
+		var storedHash string
+		err = tx.QueryRowContext(ctx, `
+			SELECT
+			    request_hash, msg_id
+			FROM
+			    idempotency_keys
+			WHERE
+			    key = $1
+		`, key).Scan(&storedHash, &msgID)
+		if err != nil {
+			return 0, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if storedHash != requestHash {
+			return 0, false, fmt.Errorf("%s: %w", op, storage.ErrIdempotencyKeyReused)
+		}
+
+		return msgID, true, nil
+	}
+
+	msgID, err = insertMsg(ctx, tx, msg)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE
+		    idempotency_keys
+		SET
+		    msg_id = $1
+		WHERE
+		    key = $2
+	`, msgID, key)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return msgID, false, nil
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	const op = "internal/storage/postgres.DeleteExpiredIdempotencyKeys"
+
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM
+		    idempotency_keys
+		WHERE
+		    expires_at <= CURRENT_TIMESTAMP
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// insertMsg stores a new message and its outbox entry within tx.
+func insertMsg(ctx context.Context, tx *sql.Tx, msg models.NewMsg) (int64, error) {
+	var msgID int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO messages
+		    (content, callback_url)
+		VALUES
+		    ($1, NULLIF($2, ''))
+		RETURNING id
+	`, msg.Content, msg.CallbackURL).Scan(&msgID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertHistory(ctx, tx, msgID, "", models.StatusNew, models.Change{Actor: models.ActorAPI})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO outbox
+		    (msg_id, content, request_id)
+		VALUES
+		    ($1, $2, NULLIF($3, ''))
+	`, msgID, msg.Content, msg.RequestID)
+	if err != nil {
+		return 0, err
+	}
+
+	return msgID, nil
+}
+
+func (s *Storage) Msg(ctx context.Context, msgID int64) (*models.Message, error) {
+	const op = "internal/storage/postgres.Msg"
+
+	var msg models.Message
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+		    id, content, status, attempts, COALESCE(last_error, ''), created_at, updated_at,
+		    processing_started_at, processed_at, COALESCE(callback_url, '')
+		FROM
+		    messages
+		WHERE
+		    id = $1
+	`, msgID).Scan(
+		&msg.ID,
+		&msg.Content,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.LastError,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+		&msg.ProcessingStartedAt,
+		&msg.ProcessedAt,
+		&msg.CallbackURL,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+		}
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &msg, nil
+}
+
+func (s *Storage) ListMsgs(ctx context.Context, filter models.MsgFilter) (*models.MsgPage, error) {
+	const op = "internal/storage/postgres.ListMsgs"
+
+	sortCol := models.SortByID
+	switch filter.SortBy {
+	case "", models.SortByID:
+	case models.SortByCreatedAt, models.SortByUpdatedAt:
+		sortCol = filter.SortBy
+	default:
+		return nil, fmt.Errorf("%s: unknown sort column %q", op, filter.SortBy)
+	}
+
+	var (
+		conds []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(filter.Statuses) > 0 {
+		conds = append(conds, "status = ANY("+arg(pq.Array(filter.Statuses))+")")
+	}
+	if filter.CreatedFrom != nil {
+		conds = append(conds, "created_at >= "+arg(filter.CreatedFrom.UTC()))
+	}
+	if filter.CreatedTo != nil {
+		conds = append(conds, "created_at < "+arg(filter.CreatedTo.UTC()))
+	}
+	if filter.UpdatedFrom != nil {
+		conds = append(conds, "updated_at >= "+arg(filter.UpdatedFrom.UTC()))
+	}
+	if filter.UpdatedTo != nil {
+		conds = append(conds, "updated_at < "+arg(filter.UpdatedTo.UTC()))
+	}
+	if filter.ContentPrefix != "" {
+		conds = append(conds, "content LIKE "+arg(escapeLike(filter.ContentPrefix)+"%")+` ESCAPE '\'`)
+	}
+
+	cmp, dir := ">", "ASC"
+	if filter.Desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if filter.Cursor != "" {
+		c, err := cursor.Decode(filter.Cursor)
+		if err != nil || c.SortBy != sortCol || c.Desc != filter.Desc {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrInvalidCursor)
+		}
+
+		if sortCol == models.SortByID {
+			conds = append(conds, "id "+cmp+" "+arg(c.ID))
+		} else {
+			conds = append(conds, "("+sortCol+", id) "+cmp+" ("+arg(c.Time)+", "+arg(c.ID)+")")
+		}
+	}
+
+	query := `
+		SELECT
+		    id, content, status, attempts, COALESCE(last_error, ''), created_at, updated_at,
+		    processing_started_at, processed_at, COALESCE(callback_url, '')
+		FROM
+		    messages`
+	if len(conds) > 0 {
+		query += `
+		WHERE
+		    ` + strings.Join(conds, " AND ")
+	}
+	query += `
+		ORDER BY ` + sortCol + ` ` + dir
+	if sortCol != models.SortByID {
+		query += `, id ` + dir
+	}
+	// One extra row tells whether there is a next page.
+	query += `
+		LIMIT ` + arg(filter.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	page := &models.MsgPage{
+		Msgs: make([]models.Message, 0, filter.Limit),
+	}
+	for rows.Next() {
+		var msg models.Message
+		if err := rows.Scan(
+			&msg.ID,
+			&msg.Content,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.LastError,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+			&msg.ProcessingStartedAt,
+			&msg.ProcessedAt,
+			&msg.CallbackURL,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		page.Msgs = append(page.Msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(page.Msgs) > filter.Limit {
+		page.Msgs = page.Msgs[:filter.Limit]
+
+		last := page.Msgs[len(page.Msgs)-1]
+		next := cursor.Cursor{
+			SortBy: sortCol,
+			Desc:   filter.Desc,
+			ID:     last.ID,
+		}
+		switch sortCol {
+		case models.SortByCreatedAt:
+			next.Time = last.CreatedAt
+		case models.SortByUpdatedAt:
+			next.Time = last.UpdatedAt
+		}
+		page.NextCursor = cursor.Encode(next)
+	}
+
+	return page, nil
+}
+
+// MsgHistory returns the status changes of a message, oldest first.
+func (s *Storage) MsgHistory(ctx context.Context, msgID int64) ([]models.StatusHistoryEntry, error) {
+	const op = "internal/storage/postgres.MsgHistory"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+		    h.id, m.id, COALESCE(h.old_status, ''), h.new_status, h.actor,
+		    COALESCE(h.reason, ''), h.kafka_partition, h.kafka_offset, h.created_at
+		FROM
+		    messages m
+		    LEFT JOIN msg_status_history h ON h.msg_id = m.id
+		WHERE
+		    m.id = $1
+		ORDER BY h.id
+	`, msgID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	found := false
+	entries := make([]models.StatusHistoryEntry, 0)
+	for rows.Next() {
+		found = true
+
+		var (
+			id        sql.NullInt64
+			entry     models.StatusHistoryEntry
+			newStatus sql.NullString
+			actor     sql.NullString
+			createdAt sql.NullTime
+		)
+		if err := rows.Scan(
+			&id,
+			&entry.MsgID,
+			&entry.OldStatus,
+			&newStatus,
+			&actor,
+			&entry.Reason,
+			&entry.Partition,
+			&entry.Offset,
+			&createdAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		// A message without history yields a single row of NULLs.
+		if !id.Valid {
+			continue
+		}
+
+		entry.ID = id.Int64
+		entry.NewStatus = newStatus.String
+		entry.Actor = actor.String
+		entry.CreatedAt = createdAt.Time
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+	}
+
+	return entries, nil
+}
+
+func (s *Storage) StatusEvents(ctx context.Context, filter models.EventFilter) ([]models.StatusHistoryEntry, error) {
+	const op = "internal/storage/postgres.StatusEvents"
+
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	conds := []string{"id > " + arg(filter.AfterID)}
+	if len(filter.IDs) > 0 {
+		conds = append(conds, "id = ANY("+arg(pq.Array(filter.IDs))+")")
+	}
+	if len(filter.MsgIDs) > 0 {
+		conds = append(conds, "msg_id = ANY("+arg(pq.Array(filter.MsgIDs))+")")
+	}
+	if len(filter.Statuses) > 0 {
+		conds = append(conds, "new_status = ANY("+arg(pq.Array(filter.Statuses))+")")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+		    id, msg_id, COALESCE(old_status, ''), new_status, actor,
+		    COALESCE(reason, ''), kafka_partition, kafka_offset, created_at
+		FROM
+		    msg_status_history
+		WHERE
+		    `+strings.Join(conds, " AND ")+`
+		ORDER BY id
+		LIMIT `+arg(filter.Limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	entries := make([]models.StatusHistoryEntry, 0)
+	for rows.Next() {
+		var entry models.StatusHistoryEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.MsgID,
+			&entry.OldStatus,
+			&entry.NewStatus,
+			&entry.Actor,
+			&entry.Reason,
+			&entry.Partition,
+			&entry.Offset,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+func (s *Storage) LastStatusEventID(ctx context.Context) (int64, error) {
+	const op = "internal/storage/postgres.LastStatusEventID"
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+		    COALESCE(MAX(id), 0)
+		FROM
+		    msg_status_history
+	`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// FetchOutbox leases up to limit unsent outbox entries for the given duration.
+// Entries leased by another relay are skipped, so several instances can
+// drain the outbox concurrently.
+func (s *Storage) FetchOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMsg, error) {
+	const op = "internal/storage/postgres.FetchOutbox"
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE
+		    outbox
+		SET
+		    locked_until = CURRENT_TIMESTAMP + $2 * INTERVAL '1 millisecond',
+		    attempts = attempts + 1
+		WHERE id IN (
+		    SELECT
+		        id
+		    FROM
+		        outbox
+		    WHERE
+		        locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP
+		    ORDER BY id
+		    LIMIT $1
+		    FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, msg_id, content, COALESCE(request_id, ''), attempts, created_at
+	`, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	var entries []models.OutboxMsg
+	for rows.Next() {
+		var e models.OutboxMsg
+		if err := rows.Scan(&e.ID, &e.MsgID, &e.Content, &e.RequestID, &e.Attempts, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, nil
+}
+
+// MarkOutboxSent removes outbox entries once they have been published and
+// moves their messages from new to queued.
+func (s *Storage) MarkOutboxSent(ctx context.Context, ids []int64) error {
+	const op = "internal/storage/postgres.MarkOutboxSent"
+
+	_, err := s.db.ExecContext(ctx, `
+		WITH sent AS (
+		    DELETE FROM
+		        outbox
+		    WHERE
+		        id = ANY($1)
+		    RETURNING msg_id
+		), queued AS (
+		    UPDATE
+		        messages
+		    SET
+		        status = $2,
+		        updated_at = CURRENT_TIMESTAMP
+		    WHERE
+		        id IN (SELECT msg_id FROM sent) AND status = $3
+		    RETURNING id
+		)
+		INSERT INTO msg_status_history
+		    (msg_id, old_status, new_status, actor)
+		SELECT
+		    id, $3, $2, $4
+		FROM
+		    queued
+	`, pq.Array(ids), models.StatusQueued, models.StatusNew, models.ActorRelay)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// FailOutbox records a failed publish attempt. The lease is kept so the
+// entry is retried only after it expires.
+func (s *Storage) FailOutbox(ctx context.Context, id int64, reason string) error {
+	const op = "internal/storage/postgres.FailOutbox"
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE
+		    outbox
+		SET
+		    last_error = $1
+		WHERE
+		    id = $2
+	`, reason, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// OutboxPending reports whether the message still has an unpublished outbox
+// entry.
+func (s *Storage) OutboxPending(ctx context.Context, msgID int64) (bool, error) {
+	const op = "internal/storage/postgres.OutboxPending"
+
+	var pending bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+		    SELECT
+		        1
+		    FROM
+		        outbox
+		    WHERE
+		        msg_id = $1
+		)
+	`, msgID).Scan(&pending)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pending, nil
+}
+
+// FetchWebhooks leases up to limit due webhook deliveries for the given
+// duration. Deliveries leased by another dispatcher are skipped.
+func (s *Storage) FetchWebhooks(ctx context.Context, limit int, lease time.Duration) ([]models.Webhook, error) {
+	const op = "internal/storage/postgres.FetchWebhooks"
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE
+		    webhook_deliveries
+		SET
+		    locked_until = CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond'
+		WHERE id IN (
+		    SELECT
+		        id
+		    FROM
+		        webhook_deliveries
+		    WHERE
+		        state = $1
+		        AND next_attempt_at <= CURRENT_TIMESTAMP
+		        AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)
+		    ORDER BY id
+		    LIMIT $2
+		    FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, url, attempts, msg_id, status, content, COALESCE(last_error, ''), processed_at
+	`, models.WebhookPending, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		var w models.Webhook
+		if err := rows.Scan(
+			&w.ID,
+			&w.URL,
+			&w.Attempts,
+			&w.Payload.MsgID,
+			&w.Payload.Status,
+			&w.Payload.Content,
+			&w.Payload.LastError,
+			&w.Payload.ProcessedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		w.Payload.DeliveryID = w.ID
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	return webhooks, nil
+}
+
+func (s *Storage) RecordWebhookAttempt(
+	ctx context.Context,
+	attempt models.WebhookAttempt,
+	state string,
+	retryIn time.Duration,
+) error {
+	const op = "internal/storage/postgres.RecordWebhookAttempt"
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+		WITH delivery AS (
+		    UPDATE
+		        webhook_deliveries
+		    SET
+		        state = $6,
+		        attempts = $2,
+		        next_attempt_at = CASE
+		            WHEN $6::text = $7::text THEN CURRENT_TIMESTAMP + $8 * INTERVAL '1 millisecond'
+		            ELSE next_attempt_at
+		        END,
+		        locked_until = NULL,
+		        updated_at = CURRENT_TIMESTAMP
+		    WHERE
+		        id = $1
+		    RETURNING id
+		)
+		INSERT INTO webhook_attempts
+		    (delivery_id, attempt, status_code, error, duration_ms)
+		SELECT
+		    id, $2, NULLIF($3, 0), NULLIF($4, ''), $5
+		FROM
+		    delivery
+		RETURNING delivery_id
+	`,
+		attempt.DeliveryID,
+		attempt.Attempt,
+		attempt.StatusCode,
+		attempt.Error,
+		attempt.DurationMS,
+		state,
+		models.WebhookPending,
+		retryIn.Milliseconds(),
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
-	return &Storage{db: db}, nil
+	return nil
 }
 
-func (s *Storage) SaveMsg(
-	ctx context.Context,
-	msg string,
-) (int64, error) {
-	const op = "internal/storage/postgres.SaveMsg"
+func (s *Storage) WebhookDeliveries(ctx context.Context, msgID int64) ([]models.WebhookDelivery, error) {
+	const op = "internal/storage/postgres.WebhookDeliveries"
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1)
+	`, msgID).Scan(&exists)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
 	}
 
-	var finalErr error
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+		    id, msg_id, url, status, state, attempts,
+		    CASE WHEN state = $2 THEN next_attempt_at END, created_at, updated_at
+		FROM
+		    webhook_deliveries
+		WHERE
+		    msg_id = $1
+		ORDER BY id
+	`, msgID, models.WebhookPending)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 	defer func() {
-		if p := recover(); p != nil {
-			rollbackErr := tx.Rollback()
-			if rollbackErr != nil {
-				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
-			}
-			panic(p)
-		} else if finalErr != nil {
-			rollbackErr := tx.Rollback()
-			if rollbackErr != nil {
-				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
-			}
-		} else {
-			commitErr := tx.Commit()
-			if commitErr != nil {
-				finalErr = fmt.Errorf("%s: %w", op, commitErr)
-			}
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
 		}
 	}()
 
-	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO messages
-		    (content)
-		VALUES
-		    ($1)
-		RETURNING id
-	`)
+	deliveries := make([]models.WebhookDelivery, 0)
+	byID := make(map[int64]int)
+	for rows.Next() {
+		d := models.WebhookDelivery{Log: make([]models.WebhookAttempt, 0)}
+		if err := rows.Scan(
+			&d.ID,
+			&d.MsgID,
+			&d.URL,
+			&d.Status,
+			&d.State,
+			&d.Attempts,
+			&d.NextAttemptAt,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		byID[d.ID] = len(deliveries)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	attempts, err := s.db.QueryContext(ctx, `
+		SELECT
+		    a.delivery_id, a.attempt, COALESCE(a.status_code, 0), COALESCE(a.error, ''),
+		    a.duration_ms, a.created_at
+		FROM
+		    webhook_attempts a
+		    JOIN webhook_deliveries d ON d.id = a.delivery_id
+		WHERE
+		    d.msg_id = $1
+		ORDER BY a.id
+	`, msgID)
 	if err != nil {
-		finalErr = fmt.Errorf("%s: %w", op, err)
-		return 0, finalErr
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	defer func() {
-		stmtErr := stmt.Close()
-		if stmtErr != nil {
-			finalErr = fmt.Errorf("%s: %w", op, stmtErr)
+		rowsErr := attempts.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
 		}
 	}()
 
-	var msgID int64
-	err = stmt.QueryRowContext(
-		ctx,
-		msg,
-	).Scan(&msgID)
-	if err != nil {
-		finalErr = fmt.Errorf("%s: %w", op, err)
-		return 0, finalErr
+	for attempts.Next() {
+		var a models.WebhookAttempt
+		if err := attempts.Scan(
+			&a.DeliveryID,
+			&a.Attempt,
+			&a.StatusCode,
+			&a.Error,
+			&a.DurationMS,
+			&a.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if i, ok := byID[a.DeliveryID]; ok {
+			deliveries[i].Log = append(deliveries[i].Log, a)
+		}
+	}
+	if err := attempts.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return msgID, nil
+	return deliveries, nil
 }
 
-func (s *Storage) TotalMessages(ctx context.Context) (int64, error) {
-	const op = "internal/storage/postgres.TotalMessages"
+// StatsSummary reads the rollups. The last day counts are kept per minute,
+// so they may include up to a minute more than a day.
+func (s *Storage) StatsSummary(ctx context.Context) (models.StatsSummary, error) {
+	const op = "internal/storage/postgres.StatsSummary"
 
-	var total int64
+	var summary models.StatsSummary
+	var contentLength int64
 	err := s.db.QueryRowContext(ctx, `
 		SELECT
-		    COUNT(*)
+		    (SELECT COALESCE(SUM(messages), 0) FROM msg_stats_by_status),
+		    (SELECT COALESCE(SUM(content_length), 0) FROM msg_stats_by_status),
+		    COALESCE(SUM(created), 0),
+		    COALESCE(SUM(updated), 0),
+		    (SELECT refreshed_at FROM msg_stats_refresh)
 		FROM
-		    messages
-	`).Scan(&total)
+		    msg_stats_by_minute
+		WHERE
+		    bucket >= date_trunc('minute', CURRENT_TIMESTAMP - INTERVAL '1 day')
+	`).Scan(
+		&summary.TotalMessages,
+		&contentLength,
+		&summary.MessagesLastDay,
+		&summary.MessagesUpdatedLastDay,
+		&summary.RefreshedAt,
+	)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return models.StatsSummary{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if summary.TotalMessages > 0 {
+		summary.AverageMessageLength = float64(contentLength) / float64(summary.TotalMessages)
+	}
+
+	summary.MessagesByStatus, err = s.MessagesByStatus(ctx)
+	if err != nil {
+		return models.StatsSummary{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return total, nil
+	return summary, nil
 }
 
 func (s *Storage) MessagesByStatus(ctx context.Context) (map[string]int64, error) {
@@ -120,10 +1104,11 @@ func (s *Storage) MessagesByStatus(ctx context.Context) (map[string]int64, error
 
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT
-		    status, COUNT(*)
+		    status, messages
 		FROM
-		    messages
-		GROUP BY status
+		    msg_stats_by_status
+		WHERE
+		    messages <> 0
 	`)
 
 	if err != nil {
@@ -145,72 +1130,115 @@ func (s *Storage) MessagesByStatus(ctx context.Context) (map[string]int64, error
 		}
 		statusCounts[status] = count
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
 	return statusCounts, nil
 }
 
-func (s *Storage) MessagesLastDay(ctx context.Context) (int64, error) {
-	const op = "internal/storage/postgres.MessagesLastDay"
+// RefreshStats moves the changes recorded by the messages triggers into the
+// rollups. Concurrent refreshes do not count a change twice: the one that
+// deleted it first wins and the other skips it.
+func (s *Storage) RefreshStats(ctx context.Context) (finalErr error) {
+	const op = "internal/storage/postgres.RefreshStats"
 
-	var count int64
-	err := s.db.QueryRowContext(ctx, `
-		SELECT
-		    COUNT(*)
-		FROM
-		    messages
-		WHERE 
-		    created_at >= CURRENT_TIMESTAMP - INTERVAL '1 day'
-	`).Scan(&count)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	return count, nil
-}
-
-func (s *Storage) MessagesUpdatedLastDay(ctx context.Context) (int64, error) {
-	const op = "internal/storage/postgres.MessagesUpdatedLastDay"
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
 
-	var count int64
-	err := s.db.QueryRowContext(ctx, `
-		SELECT
-		    COUNT(*)
-		FROM
-		    messages
-		WHERE
-		    updated_at >= CURRENT_TIMESTAMP - INTERVAL '1 day'
-	`).Scan(&count)
+	_, err = tx.ExecContext(ctx, `
+		WITH changes AS (
+		    DELETE FROM
+		        msg_stats_changes
+		    RETURNING
+		        status, messages, content_length, created_bucket, created, updated_bucket, updated
+		), by_status AS (
+		    INSERT INTO msg_stats_by_status
+		        (status, messages, content_length)
+		    SELECT
+		        status, SUM(messages), SUM(content_length)
+		    FROM
+		        changes
+		    GROUP BY status
+		    ON CONFLICT (status) DO UPDATE SET
+		        messages = msg_stats_by_status.messages + EXCLUDED.messages,
+		        content_length = msg_stats_by_status.content_length + EXCLUDED.content_length
+		), by_minute AS (
+		    INSERT INTO msg_stats_by_minute
+		        (bucket, created, updated)
+		    SELECT
+		        bucket, SUM(created), SUM(updated)
+		    FROM (
+		        SELECT created_bucket AS bucket, created, 0 AS updated FROM changes WHERE created_bucket IS NOT NULL
+		        UNION ALL
+		        SELECT updated_bucket, 0, updated FROM changes WHERE updated_bucket IS NOT NULL
+		    ) buckets
+		    WHERE
+		        bucket >= date_trunc('minute', CURRENT_TIMESTAMP - INTERVAL '1 day')
+		    GROUP BY bucket
+		    ON CONFLICT (bucket) DO UPDATE SET
+		        created = msg_stats_by_minute.created + EXCLUDED.created,
+		        updated = msg_stats_by_minute.updated + EXCLUDED.updated
+		)
+		INSERT INTO msg_stats_refresh
+		    (refreshed_at)
+		VALUES
+		    (CURRENT_TIMESTAMP)
+		ON CONFLICT (id) DO UPDATE SET
+		    refreshed_at = EXCLUDED.refreshed_at
+	`)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
-	return count, nil
-}
-
-func (s *Storage) AverageMessageLength(ctx context.Context) (float64, error) {
-	const op = "internal/storage/postgres.AverageMessageLength"
 
-	var avgLength float64
-	err := s.db.QueryRowContext(ctx, `
-		SELECT
-		    AVG(LENGTH(content))
-		FROM
-		    messages
-	`).Scan(&avgLength)
+	// Only the last day is ever read from the minute buckets.
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM
+		    msg_stats_by_minute
+		WHERE
+		    bucket < date_trunc('minute', CURRENT_TIMESTAMP - INTERVAL '1 day')
+	`)
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
-	return avgLength, nil
+
+	return nil
 }
 
-func (s *Storage) UpdateMsgStatus(ctx context.Context, msgID int64, status string) error {
-	const op = "internal/storage/postgres.UpdateMsgStatus"
+// RebuildStats recomputes the rollups from a snapshot of the messages.
+// Changes committed after the snapshot stay recorded for the next refresh,
+// and a refresh running at the same time makes the rebuild fail with a
+// serialization error rather than count its changes twice.
+func (s *Storage) RebuildStats(ctx context.Context) (finalErr error) {
+	const op = "internal/storage/postgres.RebuildStats"
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	var finalErr error
 	defer func() {
 		if p := recover(); p != nil {
 			rollbackErr := tx.Rollback()
@@ -231,41 +1259,181 @@ func (s *Storage) UpdateMsgStatus(ctx context.Context, msgID int64, status strin
 		}
 	}()
 
-	stmt, err := tx.PrepareContext(ctx, `
-		UPDATE
+	statements := []string{
+		`DELETE FROM msg_stats_changes`,
+		`DELETE FROM msg_stats_by_status`,
+		`DELETE FROM msg_stats_by_minute`,
+		`
+		INSERT INTO msg_stats_by_status
+		    (status, messages, content_length)
+		SELECT
+		    status, COUNT(*), COALESCE(SUM(LENGTH(content)), 0)
+		FROM
 		    messages
-		SET 
-			status = $1,
-			updated_at = CURRENT_TIMESTAMP
-		WHERE 
-		    id = $2
-	`)
+		GROUP BY status
+		`,
+		`
+		INSERT INTO msg_stats_by_minute
+		    (bucket, created, updated)
+		SELECT
+		    bucket, SUM(created), SUM(updated)
+		FROM (
+		    SELECT date_trunc('minute', created_at) AS bucket, 1 AS created, 0 AS updated FROM messages
+		    UNION ALL
+		    SELECT date_trunc('minute', updated_at), 0, 1 FROM messages
+		) buckets
+		WHERE
+		    bucket >= date_trunc('minute', CURRENT_TIMESTAMP - INTERVAL '1 day')
+		GROUP BY bucket
+		`,
+		`
+		INSERT INTO msg_stats_refresh
+		    (refreshed_at)
+		VALUES
+		    (CURRENT_TIMESTAMP)
+		ON CONFLICT (id) DO UPDATE SET
+		    refreshed_at = EXCLUDED.refreshed_at
+		`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// MessageSeries counts messages per bucket with date_trunc and fills the
+// buckets without any with zeros from generate_series. Completed and failed
+// messages are counted when they reached that status, from the history.
+func (s *Storage) MessageSeries(ctx context.Context, filter models.SeriesFilter) ([]models.SeriesPoint, error) {
+	const op = "internal/storage/postgres.MessageSeries"
+
+	if models.BucketDuration(filter.Bucket) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrInvalidBucket)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH buckets AS (
+		    SELECT
+		        generate_series(
+		            date_trunc($3::text, $1::timestamp),
+		            $2::timestamp - INTERVAL '1 microsecond',
+		            ('1 ' || $3::text)::interval
+		        ) AS bucket
+		), created AS (
+		    SELECT
+		        date_trunc($3::text, created_at) AS bucket, COUNT(*) AS n
+		    FROM
+		        messages
+		    WHERE
+		        created_at >= date_trunc($3::text, $1::timestamp) AND created_at < $2::timestamp
+		    GROUP BY 1
+		), finished AS (
+		    SELECT
+		        date_trunc($3::text, created_at) AS bucket,
+		        COUNT(*) FILTER (WHERE new_status = $4) AS completed,
+		        COUNT(*) FILTER (WHERE new_status = $5) AS failed
+		    FROM
+		        msg_status_history
+		    WHERE
+		        new_status IN ($4, $5)
+		        AND created_at >= date_trunc($3::text, $1::timestamp) AND created_at < $2::timestamp
+		    GROUP BY 1
+		)
+		SELECT
+		    buckets.bucket, COALESCE(created.n, 0), COALESCE(finished.completed, 0), COALESCE(finished.failed, 0)
+		FROM
+		    buckets
+		    LEFT JOIN created ON created.bucket = buckets.bucket
+		    LEFT JOIN finished ON finished.bucket = buckets.bucket
+		ORDER BY buckets.bucket
+	`, filter.From.UTC(), filter.To.UTC(), filter.Bucket, models.StatusCompleted, models.StatusFailed)
 	if err != nil {
-		finalErr = fmt.Errorf("%s: %w", op, err)
-		return finalErr
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	defer func() {
-		stmtErr := stmt.Close()
-		if stmtErr != nil {
-			finalErr = fmt.Errorf("%s: %w", op, stmtErr)
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
 		}
 	}()
 
-	_, err = stmt.ExecContext(
-		ctx,
-		status,
-		msgID,
+	series := make([]models.SeriesPoint, 0, filter.Buckets())
+	for rows.Next() {
+		var p models.SeriesPoint
+		if err := rows.Scan(&p.Time, &p.Created, &p.Completed, &p.Failed); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		p.Time = p.Time.UTC()
+		series = append(series, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return series, nil
+}
+
+func (s *Storage) ProcessingLatency(ctx context.Context, from, to time.Time) (models.ProcessingLatency, error) {
+	const op = "internal/storage/postgres.ProcessingLatency"
+
+	var (
+		e2e      models.Latency
+		consumer models.Latency
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+		    COUNT(end_to_end),
+		    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY end_to_end), 0),
+		    COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY end_to_end), 0),
+		    COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY end_to_end), 0),
+		    COALESCE(MAX(end_to_end), 0),
+		    COUNT(consumer),
+		    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY consumer), 0),
+		    COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY consumer), 0),
+		    COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY consumer), 0),
+		    COALESCE(MAX(consumer), 0)
+		FROM (
+		    SELECT
+		        EXTRACT(EPOCH FROM processed_at - created_at)::float8 AS end_to_end,
+		        EXTRACT(EPOCH FROM processed_at - processing_started_at)::float8 AS consumer
+		    FROM
+		        messages
+		    WHERE
+		        status = $1 AND processed_at >= $2 AND processed_at < $3
+		) latencies
+	`, models.StatusCompleted, from.UTC(), to.UTC()).Scan(
+		&e2e.Count, &e2e.P50, &e2e.P90, &e2e.P99, &e2e.Max,
+		&consumer.Count, &consumer.P50, &consumer.P90, &consumer.P99, &consumer.Max,
 	)
 	if err != nil {
-		finalErr = fmt.Errorf("%s: %w", op, err)
+		return models.ProcessingLatency{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.ProcessingLatency{
+		EndToEnd: e2e,
+		Consumer: consumer,
+	}, nil
+}
+
+// UpdateMsgStatus moves a message to the given status if the lifecycle
+// allows it and records the change in the status history. It returns
+// storage.ErrInvalidTransition when the current status cannot move to the new
+// one.
+func (s *Storage) UpdateMsgStatus(ctx context.Context, msgID int64, status string, change models.Change) error {
+	const op = "internal/storage/postgres.UpdateMsgStatus"
 
-		return finalErr
+	if err := s.transition(ctx, msgID, status, change, ""); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
 
-func (s *Storage) UpdateMsg(ctx context.Context, msgID int64, msg string) error {
+func (s *Storage) UpdateMsg(ctx context.Context, msgID int64, msg string, change models.Change) (finalErr error) {
 	const op = "internal/storage/postgres.UpdateMsg"
 
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -273,7 +1441,6 @@ func (s *Storage) UpdateMsg(ctx context.Context, msgID int64, msg string) error
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	var finalErr error
 	defer func() {
 		if p := recover(); p != nil {
 			rollbackErr := tx.Rollback()
@@ -294,36 +1461,317 @@ func (s *Storage) UpdateMsg(ctx context.Context, msgID int64, msg string) error
 		}
 	}()
 
-	stmt, err := tx.PrepareContext(ctx, `
+	var status string
+	err = tx.QueryRowContext(ctx, `
 		UPDATE
 		    messages
-		SET 
-			content = $1,
-			updated_at = CURRENT_TIMESTAMP
-		WHERE 
+		SET
+		    content = $1,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE
 		    id = $2
-	`)
+		RETURNING status
+	`, msg, msgID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if change.Reason == "" {
+		change.Reason = "content updated"
+	}
+
+	err = insertHistory(ctx, tx, msgID, status, status, change)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveResult writes the outcome of processing a message: its status,
+// content, attempt count and last error, together with the status history
+// entry, in a single statement.
+func (s *Storage) SaveResult(ctx context.Context, res models.ProcessingResult) error {
+	const op = "internal/storage/postgres.SaveResult"
+
+	errs, err := s.saveResults(ctx, []models.ProcessingResult{res})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if errs[0] != nil {
+		return fmt.Errorf("%s: %w", op, errs[0])
+	}
+
+	return nil
+}
+
+// SaveResults writes many processing results in a single statement. Results
+// are applied independently: the returned slice holds, in the order of
+// results, storage.ErrMsgNotFound or storage.ErrInvalidTransition for those
+// that were not applied and nil for the rest.
+func (s *Storage) SaveResults(ctx context.Context, results []models.ProcessingResult) ([]error, error) {
+	const op = "internal/storage/postgres.SaveResults"
+
+	errs, err := s.saveResults(ctx, results)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return errs, nil
+}
+
+func (s *Storage) saveResults(ctx context.Context, results []models.ProcessingResult) ([]error, error) {
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	var (
+		ids        = make([]int64, len(results))
+		statuses   = make([]string, len(results))
+		contents   = make([]sql.NullString, len(results))
+		attempts   = make([]int64, len(results))
+		lastErrors = make([]string, len(results))
+		actors     = make([]string, len(results))
+		reasons    = make([]string, len(results))
+		partitions = make([]sql.NullInt64, len(results))
+		offsets    = make([]sql.NullInt64, len(results))
+	)
+
+	seen := make(map[int64]struct{}, len(results))
+	for i, res := range results {
+		if !models.IsValidStatus(res.Status) {
+			return nil, storage.ErrInvalidStatus
+		}
+		if _, ok := seen[res.MsgID]; ok {
+			return nil, fmt.Errorf("duplicate result for message %d", res.MsgID)
+		}
+		seen[res.MsgID] = struct{}{}
+
+		ids[i] = res.MsgID
+		statuses[i] = res.Status
+		if res.Content != nil {
+			contents[i] = sql.NullString{String: *res.Content, Valid: true}
+		}
+		attempts[i] = int64(res.Attempts)
+		lastErrors[i] = res.Error
+		actors[i] = res.Change.Actor
+		reasons[i] = res.Change.Reason
+		if res.Change.Partition != nil {
+			partitions[i] = sql.NullInt64{Int64: int64(*res.Change.Partition), Valid: true}
+		}
+		if res.Change.Offset != nil {
+			offsets[i] = sql.NullInt64{Int64: *res.Change.Offset, Valid: true}
+		}
+	}
+
+	from, to := models.TransitionPairs()
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH input AS (
+		    SELECT
+		        *
+		    FROM
+		        unnest(
+		            $1::bigint[], $2::text[], $3::text[], $4::int[], $5::text[],
+		            $6::text[], $7::text[], $8::int[], $9::bigint[]
+		        ) WITH ORDINALITY AS r
+		            (msg_id, status, content, attempts, last_error,
+		             actor, reason, kafka_partition, kafka_offset, n)
+		), allowed AS (
+		    SELECT
+		        *
+		    FROM
+		        unnest($10::text[], $11::text[]) AS t (from_status, to_status)
+		), prev AS (
+		    SELECT
+		        m.id, m.status
+		    FROM
+		        messages m
+		        JOIN input ON input.msg_id = m.id
+		    ORDER BY m.id
+		    FOR UPDATE OF m
+		), changed AS (
+		    UPDATE
+		        messages m
+		    SET`+stampProcessing("input.status", "prev.status")+`
+		        status = input.status,
+		        content = COALESCE(input.content, m.content),
+		        attempts = input.attempts,
+		        last_error = NULLIF(input.last_error, ''),
+		        updated_at = CURRENT_TIMESTAMP
+		    FROM
+		        input
+		        JOIN prev ON prev.id = input.msg_id
+		        JOIN allowed ON allowed.from_status = prev.status AND allowed.to_status = input.status
+		    WHERE
+		        m.id = input.msg_id
+		    RETURNING m.id, prev.status AS old_status
+		), history AS (
+		    INSERT INTO msg_status_history
+		        (msg_id, old_status, new_status, actor, reason, kafka_partition, kafka_offset)
+		    SELECT
+		        changed.id, changed.old_status, input.status, input.actor,
+		        NULLIF(input.reason, ''), input.kafka_partition, input.kafka_offset
+		    FROM
+		        changed
+		        JOIN input ON input.msg_id = changed.id
+		)
+		SELECT
+		    input.n, prev.status, changed.id IS NOT NULL
+		FROM
+		    input
+		    LEFT JOIN prev ON prev.id = input.msg_id
+		    LEFT JOIN changed ON changed.id = input.msg_id
+		ORDER BY input.n
+	`,
+		pq.Array(ids),
+		pq.Array(statuses),
+		pq.Array(contents),
+		pq.Array(attempts),
+		pq.Array(lastErrors),
+		pq.Array(actors),
+		pq.Array(reasons),
+		pq.Array(partitions),
+		pq.Array(offsets),
+		pq.Array(from),
+		pq.Array(to),
+	)
 	if err != nil {
-		finalErr = fmt.Errorf("%s: %w", op, err)
-		return finalErr
+		return nil, err
 	}
 	defer func() {
-		stmtErr := stmt.Close()
-		if stmtErr != nil {
-			finalErr = fmt.Errorf("%s: %w", op, stmtErr)
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
 		}
 	}()
 
-	_, err = stmt.ExecContext(
-		ctx,
-		msg,
-		msgID,
+	errs := make([]error, len(results))
+	for rows.Next() {
+		var (
+			n       int
+			current sql.NullString
+			updated bool
+		)
+		if err := rows.Scan(&n, &current, &updated); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !current.Valid:
+			errs[n-1] = storage.ErrMsgNotFound
+		case !updated:
+			errs[n-1] = fmt.Errorf("%w: %s -> %s", storage.ErrInvalidTransition, current.String, results[n-1].Status)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// transition sets the status of a message with a single conditional UPDATE
+// that only matches rows in a status allowed to move to the new one, and
+// records the change in the status history in the same statement. Extra SET
+// assignments may use placeholders from $8 on, bound to args.
+func (s *Storage) transition(
+	ctx context.Context,
+	msgID int64,
+	status string,
+	change models.Change,
+	set string,
+	args ...any,
+) error {
+	if !models.IsValidStatus(status) {
+		return storage.ErrInvalidStatus
+	}
+
+	var (
+		current sql.NullString
+		updated bool
 	)
+	err := s.db.QueryRowContext(ctx, `
+		WITH prev AS (
+		    SELECT
+		        id, status
+		    FROM
+		        messages
+		    WHERE
+		        id = $2
+		    FOR UPDATE
+		), changed AS (
+		    UPDATE
+		        messages m
+		    SET`+set+stampProcessing("$1", "prev.status")+`
+		        status = $1,
+		        updated_at = CURRENT_TIMESTAMP
+		    FROM
+		        prev
+		    WHERE
+		        m.id = prev.id AND prev.status = ANY($3)
+		    RETURNING m.id, prev.status AS old_status
+		), history AS (
+		    INSERT INTO msg_status_history
+		        (msg_id, old_status, new_status, actor, reason, kafka_partition, kafka_offset)
+		    SELECT
+		        id, old_status, $1, $4, NULLIF($5, ''), $6, $7
+		    FROM
+		        changed
+		)
+		SELECT
+		    (SELECT status FROM prev),
+		    EXISTS (SELECT 1 FROM changed)
+	`, append([]any{
+		status,
+		msgID,
+		pq.Array(models.TransitionSources(status)),
+		change.Actor,
+		change.Reason,
+		change.Partition,
+		change.Offset,
+	}, args...)...).Scan(&current, &updated)
 	if err != nil {
-		finalErr = fmt.Errorf("%s: %w", op, err)
+		return err
+	}
 
-		return finalErr
+	if !current.Valid {
+		return storage.ErrMsgNotFound
+	}
+	if !updated {
+		return fmt.Errorf("%w: %s -> %s", storage.ErrInvalidTransition, current.String, status)
 	}
 
 	return nil
 }
+
+// stampProcessing returns SET assignments that record when a message moves
+// into processing and when it is completed or failed. newStatus and
+// oldStatus are SQL expressions of the statuses around the change.
+func stampProcessing(newStatus, oldStatus string) string {
+	return `
+		        processing_started_at = CASE
+		            WHEN ` + newStatus + ` = '` + models.StatusProcessing + `' AND ` + oldStatus + ` <> '` + models.StatusProcessing + `'
+		            THEN CURRENT_TIMESTAMP ELSE m.processing_started_at
+		        END,
+		        processed_at = CASE
+		            WHEN ` + newStatus + ` IN ('` + models.StatusCompleted + `', '` + models.StatusFailed + `')
+		            THEN CURRENT_TIMESTAMP ELSE m.processed_at
+		        END,`
+}
+
+// insertHistory records a status change of a message within tx.
+func insertHistory(ctx context.Context, tx *sql.Tx, msgID int64, oldStatus, newStatus string, change models.Change) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO msg_status_history
+		    (msg_id, old_status, new_status, actor, reason, kafka_partition, kafka_offset)
+		VALUES
+		    ($1, NULLIF($2, ''), $3, $4, NULLIF($5, ''), $6, $7)
+	`, msgID, oldStatus, newStatus, change.Actor, change.Reason, change.Partition, change.Offset)
+
+	return err
+}
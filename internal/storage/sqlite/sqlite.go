@@ -0,0 +1,1771 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/lib/cursor"
+	"msgproc/internal/storage"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	_ "modernc.org/sqlite"
+)
+
+// timeFormat is the layout timestamps are stored in. It sorts as text and
+// matches what strftime('%Y-%m-%d %H:%M:%f') produces.
+const timeFormat = "2006-01-02 15:04:05.000"
+
+const now = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+
+type Storage struct {
+	db *sql.DB
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func NewStorage(path string) (*Storage, error) {
+	const op = "internal/storage/sqlite.NewStorage"
+
+	db, err := sql.Open("sqlite",
+		"file:"+path+
+			"?_pragma=foreign_keys(1)"+
+			"&_pragma=busy_timeout(5000)"+
+			"&_pragma=journal_mode(WAL)"+
+			"&_txlock=immediate",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// SQLite has a single writer. One connection serialises writes in the
+	// pool instead of failing them with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "internal/storage/sqlite.Ping"
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MigrationVersion reads the schema version recorded by golang-migrate in the
+// given migrations table.
+func (s *Storage) MigrationVersion(ctx context.Context, table string) (uint, bool, error) {
+	const op = "internal/storage/sqlite.MigrationVersion"
+
+	var (
+		version int64
+		dirty   bool
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+		    version, dirty
+		FROM
+		    `+quoteIdentifier(table)+`
+		LIMIT 1
+	`).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return uint(version), dirty, nil
+}
+
+func (s *Storage) SaveMsg(
+	ctx context.Context,
+	msg models.NewMsg,
+) (msgID int64, finalErr error) {
+	const op = "internal/storage/sqlite.SaveMsg"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				msgID = 0
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	msgID, err = insertMsg(ctx, tx, msg)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return msgID, nil
+}
+
+// SaveMsgs saves a batch of messages and their outbox entries in a single
+// transaction. The returned IDs are in the order of msgs.
+func (s *Storage) SaveMsgs(ctx context.Context, msgs []models.NewMsg) (ids []int64, finalErr error) {
+	const op = "internal/storage/sqlite.SaveMsgs"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				ids = nil
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	ids = make([]int64, 0, len(msgs))
+	for _, msg := range msgs {
+		id, err := insertMsg(ctx, tx, msg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// SaveMsgIdempotent saves a message unless the idempotency key has already
+// been used. A replay with the same request hash returns the original msgID
+// with replayed set; a different hash yields storage.ErrIdempotencyKeyReused.
+func (s *Storage) SaveMsgIdempotent(
+	ctx context.Context,
+	msg models.NewMsg,
+	key string,
+	requestHash string,
+	ttl time.Duration,
+) (msgID int64, replayed bool, finalErr error) {
+	const op = "internal/storage/sqlite.SaveMsgIdempotent"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				msgID, replayed = 0, false
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM
+		    idempotency_keys
+		WHERE
+		    key = ? AND expires_at <= `+now+`
+	`, key)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Transactions take the write lock up front, so a concurrent request
+	// with the same key waits here and then sees the key as taken.
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO idempotency_keys
+		    (key, request_hash, expires_at)
+		VALUES
+		    (?, ?, strftime('%Y-%m-%d %H:%M:%f', 'now', ?))
+		ON CONFLICT (key) DO NOTHING
+	`, key, requestHash, fmt.Sprintf("%+.3f seconds", ttl.Seconds()))
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if inserted == 0 {
+		var storedHash string
+		err = tx.QueryRowContext(ctx, `
+			SELECT
+			    request_hash, msg_id
+			FROM
+			    idempotency_keys
+			WHERE
+			    key = ?
+		`, key).Scan(&storedHash, &msgID)
+		if err != nil {
+			return 0, false, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if storedHash != requestHash {
+			return 0, false, fmt.Errorf("%s: %w", op, storage.ErrIdempotencyKeyReused)
+		}
+
+		return msgID, true, nil
+	}
+
+	msgID, err = insertMsg(ctx, tx, msg)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE
+		    idempotency_keys
+		SET
+		    msg_id = ?
+		WHERE
+		    key = ?
+	`, msgID, key)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return msgID, false, nil
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	const op = "internal/storage/sqlite.DeleteExpiredIdempotencyKeys"
+
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM
+		    idempotency_keys
+		WHERE
+		    expires_at <= `+now)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return n, nil
+}
+
+// insertMsg stores a new message and its outbox entry within tx.
+func insertMsg(ctx context.Context, tx *sql.Tx, msg models.NewMsg) (int64, error) {
+	var msgID int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO messages
+		    (content, callback_url)
+		VALUES
+		    (?, NULLIF(?, ''))
+		RETURNING id
+	`, msg.Content, msg.CallbackURL).Scan(&msgID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertHistory(ctx, tx, msgID, "", models.StatusNew, models.Change{Actor: models.ActorAPI})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO outbox
+		    (msg_id, content, request_id)
+		VALUES
+		    (?, ?, NULLIF(?, ''))
+	`, msgID, msg.Content, msg.RequestID)
+	if err != nil {
+		return 0, err
+	}
+
+	return msgID, nil
+}
+
+func (s *Storage) Msg(ctx context.Context, msgID int64) (*models.Message, error) {
+	const op = "internal/storage/sqlite.Msg"
+
+	var msg models.Message
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+		    id, content, status, attempts, COALESCE(last_error, ''), created_at, updated_at,
+		    processing_started_at, processed_at, COALESCE(callback_url, '')
+		FROM
+		    messages
+		WHERE
+		    id = ?
+	`, msgID).Scan(
+		&msg.ID,
+		&msg.Content,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.LastError,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+		&msg.ProcessingStartedAt,
+		&msg.ProcessedAt,
+		&msg.CallbackURL,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+		}
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &msg, nil
+}
+
+func (s *Storage) ListMsgs(ctx context.Context, filter models.MsgFilter) (*models.MsgPage, error) {
+	const op = "internal/storage/sqlite.ListMsgs"
+
+	sortCol := models.SortByID
+	switch filter.SortBy {
+	case "", models.SortByID:
+	case models.SortByCreatedAt, models.SortByUpdatedAt:
+		sortCol = filter.SortBy
+	default:
+		return nil, fmt.Errorf("%s: unknown sort column %q", op, filter.SortBy)
+	}
+
+	var (
+		conds []string
+		args  []any
+	)
+
+	if len(filter.Statuses) > 0 {
+		statuses, err := json.Marshal(filter.Statuses)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		conds = append(conds, "status IN (SELECT value FROM json_each(?))")
+		args = append(args, string(statuses))
+	}
+	if filter.CreatedFrom != nil {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, formatTime(*filter.CreatedFrom))
+	}
+	if filter.CreatedTo != nil {
+		conds = append(conds, "created_at < ?")
+		args = append(args, formatTime(*filter.CreatedTo))
+	}
+	if filter.UpdatedFrom != nil {
+		conds = append(conds, "updated_at >= ?")
+		args = append(args, formatTime(*filter.UpdatedFrom))
+	}
+	if filter.UpdatedTo != nil {
+		conds = append(conds, "updated_at < ?")
+		args = append(args, formatTime(*filter.UpdatedTo))
+	}
+	if filter.ContentPrefix != "" {
+		// LIKE ignores case in SQLite, so the prefix is compared directly.
+		conds = append(conds, "substr(content, 1, ?) = ?")
+		args = append(args, utf8.RuneCountInString(filter.ContentPrefix), filter.ContentPrefix)
+	}
+
+	cmp, dir := ">", "ASC"
+	if filter.Desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if filter.Cursor != "" {
+		c, err := cursor.Decode(filter.Cursor)
+		if err != nil || c.SortBy != sortCol || c.Desc != filter.Desc {
+			return nil, fmt.Errorf("%s: %w", op, storage.ErrInvalidCursor)
+		}
+
+		if sortCol == models.SortByID {
+			conds = append(conds, "id "+cmp+" ?")
+			args = append(args, c.ID)
+		} else {
+			conds = append(conds, "("+sortCol+", id) "+cmp+" (?, ?)")
+			args = append(args, formatTime(c.Time), c.ID)
+		}
+	}
+
+	query := `
+		SELECT
+		    id, content, status, attempts, COALESCE(last_error, ''), created_at, updated_at,
+		    processing_started_at, processed_at, COALESCE(callback_url, '')
+		FROM
+		    messages`
+	if len(conds) > 0 {
+		query += `
+		WHERE
+		    ` + strings.Join(conds, " AND ")
+	}
+	query += `
+		ORDER BY ` + sortCol + ` ` + dir
+	if sortCol != models.SortByID {
+		query += `, id ` + dir
+	}
+	// One extra row tells whether there is a next page.
+	query += `
+		LIMIT ?`
+	args = append(args, filter.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	page := &models.MsgPage{
+		Msgs: make([]models.Message, 0, filter.Limit),
+	}
+	for rows.Next() {
+		var msg models.Message
+		if err := rows.Scan(
+			&msg.ID,
+			&msg.Content,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.LastError,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+			&msg.ProcessingStartedAt,
+			&msg.ProcessedAt,
+			&msg.CallbackURL,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		page.Msgs = append(page.Msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(page.Msgs) > filter.Limit {
+		page.Msgs = page.Msgs[:filter.Limit]
+
+		last := page.Msgs[len(page.Msgs)-1]
+		next := cursor.Cursor{
+			SortBy: sortCol,
+			Desc:   filter.Desc,
+			ID:     last.ID,
+		}
+		switch sortCol {
+		case models.SortByCreatedAt:
+			next.Time = last.CreatedAt
+		case models.SortByUpdatedAt:
+			next.Time = last.UpdatedAt
+		}
+		page.NextCursor = cursor.Encode(next)
+	}
+
+	return page, nil
+}
+
+// MsgHistory returns the status changes of a message, oldest first.
+func (s *Storage) MsgHistory(ctx context.Context, msgID int64) ([]models.StatusHistoryEntry, error) {
+	const op = "internal/storage/sqlite.MsgHistory"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+		    h.id, m.id, COALESCE(h.old_status, ''), h.new_status, h.actor,
+		    COALESCE(h.reason, ''), h.kafka_partition, h.kafka_offset, h.created_at
+		FROM
+		    messages m
+		    LEFT JOIN msg_status_history h ON h.msg_id = m.id
+		WHERE
+		    m.id = ?
+		ORDER BY h.id
+	`, msgID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	found := false
+	entries := make([]models.StatusHistoryEntry, 0)
+	for rows.Next() {
+		found = true
+
+		var (
+			id        sql.NullInt64
+			entry     models.StatusHistoryEntry
+			newStatus sql.NullString
+			actor     sql.NullString
+			createdAt sql.NullTime
+		)
+		if err := rows.Scan(
+			&id,
+			&entry.MsgID,
+			&entry.OldStatus,
+			&newStatus,
+			&actor,
+			&entry.Reason,
+			&entry.Partition,
+			&entry.Offset,
+			&createdAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		// A message without history yields a single row of NULLs.
+		if !id.Valid {
+			continue
+		}
+
+		entry.ID = id.Int64
+		entry.NewStatus = newStatus.String
+		entry.Actor = actor.String
+		entry.CreatedAt = createdAt.Time
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+	}
+
+	return entries, nil
+}
+
+func (s *Storage) StatusEvents(ctx context.Context, filter models.EventFilter) ([]models.StatusHistoryEntry, error) {
+	const op = "internal/storage/sqlite.StatusEvents"
+
+	conds := []string{"id > ?"}
+	args := []any{filter.AfterID}
+
+	// in matches the column against a list, passed as a JSON array.
+	in := func(col string, values any) error {
+		list, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		conds = append(conds, col+" IN (SELECT value FROM json_each(?))")
+		args = append(args, string(list))
+		return nil
+	}
+
+	if len(filter.IDs) > 0 {
+		if err := in("id", filter.IDs); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if len(filter.MsgIDs) > 0 {
+		if err := in("msg_id", filter.MsgIDs); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if len(filter.Statuses) > 0 {
+		if err := in("new_status", filter.Statuses); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+		    id, msg_id, COALESCE(old_status, ''), new_status, actor,
+		    COALESCE(reason, ''), kafka_partition, kafka_offset, created_at
+		FROM
+		    msg_status_history
+		WHERE
+		    `+strings.Join(conds, " AND ")+`
+		ORDER BY id
+		LIMIT ?`, append(args, filter.Limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	entries := make([]models.StatusHistoryEntry, 0)
+	for rows.Next() {
+		var entry models.StatusHistoryEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.MsgID,
+			&entry.OldStatus,
+			&entry.NewStatus,
+			&entry.Actor,
+			&entry.Reason,
+			&entry.Partition,
+			&entry.Offset,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+func (s *Storage) LastStatusEventID(ctx context.Context) (int64, error) {
+	const op = "internal/storage/sqlite.LastStatusEventID"
+
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+		    COALESCE(MAX(id), 0)
+		FROM
+		    msg_status_history
+	`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// FetchOutbox leases up to limit unsent outbox entries for the given duration.
+func (s *Storage) FetchOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMsg, error) {
+	const op = "internal/storage/sqlite.FetchOutbox"
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE
+		    outbox
+		SET
+		    locked_until = strftime('%Y-%m-%d %H:%M:%f', 'now', ?),
+		    attempts = attempts + 1
+		WHERE id IN (
+		    SELECT
+		        id
+		    FROM
+		        outbox
+		    WHERE
+		        locked_until IS NULL OR locked_until < `+now+`
+		    ORDER BY id
+		    LIMIT ?
+		)
+		RETURNING id, msg_id, content, COALESCE(request_id, ''), attempts, created_at
+	`, fmt.Sprintf("%+.3f seconds", lease.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	var entries []models.OutboxMsg
+	for rows.Next() {
+		var e models.OutboxMsg
+		if err := rows.Scan(&e.ID, &e.MsgID, &e.Content, &e.RequestID, &e.Attempts, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, nil
+}
+
+// MarkOutboxSent removes outbox entries once they have been published and
+// moves their messages from new to queued.
+func (s *Storage) MarkOutboxSent(ctx context.Context, ids []int64) (finalErr error) {
+	const op = "internal/storage/sqlite.MarkOutboxSent"
+
+	idList, err := json.Marshal(ids)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	const sent = `
+		    SELECT
+		        msg_id
+		    FROM
+		        outbox
+		    WHERE
+		        id IN (SELECT value FROM json_each(?))`
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO msg_status_history
+		    (msg_id, old_status, new_status, actor)
+		SELECT
+		    id, status, ?, ?
+		FROM
+		    messages
+		WHERE
+		    status = ? AND id IN (`+sent+`
+		    )
+	`, models.StatusQueued, models.ActorRelay, models.StatusNew, string(idList))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE
+		    messages
+		SET
+		    status = ?,
+		    updated_at = `+now+`
+		WHERE
+		    status = ? AND id IN (`+sent+`
+		    )
+	`, models.StatusQueued, models.StatusNew, string(idList))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM
+		    outbox
+		WHERE
+		    id IN (SELECT value FROM json_each(?))
+	`, string(idList))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// FailOutbox records a failed publish attempt. The lease is kept so the
+// entry is retried only after it expires.
+func (s *Storage) FailOutbox(ctx context.Context, id int64, reason string) error {
+	const op = "internal/storage/sqlite.FailOutbox"
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE
+		    outbox
+		SET
+		    last_error = ?
+		WHERE
+		    id = ?
+	`, reason, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// OutboxPending reports whether the message still has an unpublished outbox
+// entry.
+func (s *Storage) OutboxPending(ctx context.Context, msgID int64) (bool, error) {
+	const op = "internal/storage/sqlite.OutboxPending"
+
+	var pending bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+		    SELECT
+		        1
+		    FROM
+		        outbox
+		    WHERE
+		        msg_id = ?
+		)
+	`, msgID).Scan(&pending)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return pending, nil
+}
+
+// FetchWebhooks leases up to limit due webhook deliveries for the given
+// duration.
+func (s *Storage) FetchWebhooks(ctx context.Context, limit int, lease time.Duration) ([]models.Webhook, error) {
+	const op = "internal/storage/sqlite.FetchWebhooks"
+
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE
+		    webhook_deliveries
+		SET
+		    locked_until = strftime('%Y-%m-%d %H:%M:%f', 'now', ?)
+		WHERE id IN (
+		    SELECT
+		        id
+		    FROM
+		        webhook_deliveries
+		    WHERE
+		        state = ?
+		        AND next_attempt_at <= `+now+`
+		        AND (locked_until IS NULL OR locked_until < `+now+`)
+		    ORDER BY id
+		    LIMIT ?
+		)
+		RETURNING id, url, attempts, msg_id, status, content, COALESCE(last_error, ''), processed_at
+	`, fmt.Sprintf("%+.3f seconds", lease.Seconds()), models.WebhookPending, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		var w models.Webhook
+		if err := rows.Scan(
+			&w.ID,
+			&w.URL,
+			&w.Attempts,
+			&w.Payload.MsgID,
+			&w.Payload.Status,
+			&w.Payload.Content,
+			&w.Payload.LastError,
+			&w.Payload.ProcessedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		w.Payload.DeliveryID = w.ID
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	return webhooks, nil
+}
+
+func (s *Storage) RecordWebhookAttempt(
+	ctx context.Context,
+	attempt models.WebhookAttempt,
+	state string,
+	retryIn time.Duration,
+) (finalErr error) {
+	const op = "internal/storage/sqlite.RecordWebhookAttempt"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE
+		    webhook_deliveries
+		SET
+		    state = ?1,
+		    attempts = ?2,
+		    next_attempt_at = CASE
+		        WHEN ?1 = ?3 THEN strftime('%Y-%m-%d %H:%M:%f', 'now', ?4)
+		        ELSE next_attempt_at
+		    END,
+		    locked_until = NULL,
+		    updated_at = `+now+`
+		WHERE
+		    id = ?5
+	`, state, attempt.Attempt, models.WebhookPending, fmt.Sprintf("%+.3f seconds", retryIn.Seconds()), attempt.DeliveryID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrWebhookNotFound)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_attempts
+		    (delivery_id, attempt, status_code, error, duration_ms)
+		VALUES
+		    (?, ?, NULLIF(?, 0), NULLIF(?, ''), ?)
+	`, attempt.DeliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMS)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) WebhookDeliveries(ctx context.Context, msgID int64) ([]models.WebhookDelivery, error) {
+	const op = "internal/storage/sqlite.WebhookDeliveries"
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM messages WHERE id = ?)
+	`, msgID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+		    id, msg_id, url, status, state, attempts,
+		    next_attempt_at, created_at, updated_at
+		FROM
+		    webhook_deliveries
+		WHERE
+		    msg_id = ?
+		ORDER BY id
+	`, msgID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	byID := make(map[int64]int)
+	for rows.Next() {
+		var nextAttemptAt time.Time
+		d := models.WebhookDelivery{Log: make([]models.WebhookAttempt, 0)}
+		if err := rows.Scan(
+			&d.ID,
+			&d.MsgID,
+			&d.URL,
+			&d.Status,
+			&d.State,
+			&d.Attempts,
+			&nextAttemptAt,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if d.State == models.WebhookPending {
+			d.NextAttemptAt = &nextAttemptAt
+		}
+		byID[d.ID] = len(deliveries)
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	attempts, err := s.db.QueryContext(ctx, `
+		SELECT
+		    a.delivery_id, a.attempt, COALESCE(a.status_code, 0), COALESCE(a.error, ''),
+		    a.duration_ms, a.created_at
+		FROM
+		    webhook_attempts a
+		    JOIN webhook_deliveries d ON d.id = a.delivery_id
+		WHERE
+		    d.msg_id = ?
+		ORDER BY a.id
+	`, msgID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := attempts.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	for attempts.Next() {
+		var a models.WebhookAttempt
+		if err := attempts.Scan(
+			&a.DeliveryID,
+			&a.Attempt,
+			&a.StatusCode,
+			&a.Error,
+			&a.DurationMS,
+			&a.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if i, ok := byID[a.DeliveryID]; ok {
+			deliveries[i].Log = append(deliveries[i].Log, a)
+		}
+	}
+	if err := attempts.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+// lastDayBucket is the first minute bucket counted in the last day.
+const lastDayBucket = `strftime('%Y-%m-%d %H:%M:00.000', 'now', '-1 day')`
+
+// StatsSummary reads the rollups. The last day counts are kept per minute,
+// so they may include up to a minute more than a day.
+func (s *Storage) StatsSummary(ctx context.Context) (models.StatsSummary, error) {
+	const op = "internal/storage/sqlite.StatsSummary"
+
+	var summary models.StatsSummary
+	var contentLength int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+		    (SELECT COALESCE(SUM(messages), 0) FROM msg_stats_by_status),
+		    (SELECT COALESCE(SUM(content_length), 0) FROM msg_stats_by_status),
+		    (SELECT COALESCE(SUM(created), 0) FROM msg_stats_by_minute WHERE bucket >= `+lastDayBucket+`),
+		    (SELECT COALESCE(SUM(updated), 0) FROM msg_stats_by_minute WHERE bucket >= `+lastDayBucket+`),
+		    refreshed_at
+		FROM
+		    msg_stats_refresh
+	`).Scan(
+		&summary.TotalMessages,
+		&contentLength,
+		&summary.MessagesLastDay,
+		&summary.MessagesUpdatedLastDay,
+		&summary.RefreshedAt,
+	)
+	if err != nil {
+		return models.StatsSummary{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if summary.TotalMessages > 0 {
+		summary.AverageMessageLength = float64(contentLength) / float64(summary.TotalMessages)
+	}
+
+	summary.MessagesByStatus, err = s.MessagesByStatus(ctx)
+	if err != nil {
+		return models.StatsSummary{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return summary, nil
+}
+
+func (s *Storage) MessagesByStatus(ctx context.Context) (map[string]int64, error) {
+	const op = "internal/storage/sqlite.MessagesByStatus"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+		    status, messages
+		FROM
+		    msg_stats_by_status
+		WHERE
+		    messages <> 0
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	statusCounts := make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		statusCounts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return statusCounts, nil
+}
+
+// RefreshStats moves the changes recorded by the messages triggers into the
+// rollups. Writes are serialized, so no change is recorded while it runs.
+func (s *Storage) RefreshStats(ctx context.Context) (finalErr error) {
+	const op = "internal/storage/sqlite.RefreshStats"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	statements := []string{
+		`
+		INSERT INTO msg_stats_by_status
+		    (status, messages, content_length)
+		SELECT
+		    status, SUM(messages), SUM(content_length)
+		FROM
+		    msg_stats_changes
+		WHERE
+		    true
+		GROUP BY status
+		ON CONFLICT (status) DO UPDATE SET
+		    messages = messages + excluded.messages,
+		    content_length = content_length + excluded.content_length
+		`,
+		`
+		INSERT INTO msg_stats_by_minute
+		    (bucket, created, updated)
+		SELECT
+		    bucket, SUM(created), SUM(updated)
+		FROM (
+		    SELECT created_bucket AS bucket, created, 0 AS updated FROM msg_stats_changes WHERE created_bucket IS NOT NULL
+		    UNION ALL
+		    SELECT updated_bucket, 0, updated FROM msg_stats_changes WHERE updated_bucket IS NOT NULL
+		)
+		WHERE
+		    bucket >= ` + lastDayBucket + `
+		GROUP BY bucket
+		ON CONFLICT (bucket) DO UPDATE SET
+		    created = created + excluded.created,
+		    updated = updated + excluded.updated
+		`,
+		`DELETE FROM msg_stats_changes`,
+		// Only the last day is ever read from the minute buckets.
+		`DELETE FROM msg_stats_by_minute WHERE bucket < ` + lastDayBucket,
+		`
+		INSERT INTO msg_stats_refresh
+		    (id, refreshed_at)
+		VALUES
+		    (1, ` + now + `)
+		ON CONFLICT (id) DO UPDATE SET
+		    refreshed_at = excluded.refreshed_at
+		`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// RebuildStats recomputes the rollups from the messages.
+func (s *Storage) RebuildStats(ctx context.Context) (finalErr error) {
+	const op = "internal/storage/sqlite.RebuildStats"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	statements := []string{
+		`DELETE FROM msg_stats_changes`,
+		`DELETE FROM msg_stats_by_status`,
+		`DELETE FROM msg_stats_by_minute`,
+		`
+		INSERT INTO msg_stats_by_status
+		    (status, messages, content_length)
+		SELECT
+		    status, COUNT(*), COALESCE(SUM(LENGTH(content)), 0)
+		FROM
+		    messages
+		GROUP BY status
+		`,
+		`
+		INSERT INTO msg_stats_by_minute
+		    (bucket, created, updated)
+		SELECT
+		    bucket, SUM(created), SUM(updated)
+		FROM (
+		    SELECT strftime('%Y-%m-%d %H:%M:00.000', created_at) AS bucket, 1 AS created, 0 AS updated FROM messages
+		    UNION ALL
+		    SELECT strftime('%Y-%m-%d %H:%M:00.000', updated_at), 0, 1 FROM messages
+		)
+		WHERE
+		    bucket >= ` + lastDayBucket + `
+		GROUP BY bucket
+		`,
+		`
+		INSERT INTO msg_stats_refresh
+		    (id, refreshed_at)
+		VALUES
+		    (1, ` + now + `)
+		ON CONFLICT (id) DO UPDATE SET
+		    refreshed_at = excluded.refreshed_at
+		`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// bucketFormats truncate a timestamp to the start of its bucket with strftime.
+var bucketFormats = map[string]string{
+	models.BucketMinute: "%Y-%m-%d %H:%M:00.000",
+	models.BucketHour:   "%Y-%m-%d %H:00:00.000",
+	models.BucketDay:    "%Y-%m-%d 00:00:00.000",
+}
+
+// MessageSeries counts messages per bucket. SQLite has no generate_series,
+// so the buckets come from a recursive CTE. Completed and failed messages
+// are counted when they reached that status, from the history.
+func (s *Storage) MessageSeries(ctx context.Context, filter models.SeriesFilter) ([]models.SeriesPoint, error) {
+	const op = "internal/storage/sqlite.MessageSeries"
+
+	format, ok := bucketFormats[filter.Bucket]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, storage.ErrInvalidBucket)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH RECURSIVE buckets (bucket) AS (
+		    SELECT
+		        strftime(?3, ?1)
+		    WHERE
+		        ?1 < ?2
+		    UNION ALL
+		    SELECT
+		        strftime('%Y-%m-%d %H:%M:%f', bucket, ?4)
+		    FROM
+		        buckets
+		    WHERE
+		        strftime('%Y-%m-%d %H:%M:%f', bucket, ?4) < ?2
+		), created AS (
+		    SELECT
+		        strftime(?3, created_at) AS bucket, COUNT(*) AS n
+		    FROM
+		        messages
+		    WHERE
+		        created_at >= strftime(?3, ?1) AND created_at < ?2
+		    GROUP BY 1
+		), finished AS (
+		    SELECT
+		        strftime(?3, created_at) AS bucket,
+		        SUM(new_status = ?5) AS completed,
+		        SUM(new_status = ?6) AS failed
+		    FROM
+		        msg_status_history
+		    WHERE
+		        new_status IN (?5, ?6)
+		        AND created_at >= strftime(?3, ?1) AND created_at < ?2
+		    GROUP BY 1
+		)
+		SELECT
+		    buckets.bucket, COALESCE(created.n, 0), COALESCE(finished.completed, 0), COALESCE(finished.failed, 0)
+		FROM
+		    buckets
+		    LEFT JOIN created ON created.bucket = buckets.bucket
+		    LEFT JOIN finished ON finished.bucket = buckets.bucket
+		ORDER BY buckets.bucket
+	`,
+		formatTime(filter.From),
+		formatTime(filter.To),
+		format,
+		"+1 "+filter.Bucket,
+		models.StatusCompleted,
+		models.StatusFailed,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	series := make([]models.SeriesPoint, 0, filter.Buckets())
+	for rows.Next() {
+		var (
+			p      models.SeriesPoint
+			bucket string
+		)
+		if err := rows.Scan(&bucket, &p.Created, &p.Completed, &p.Failed); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		p.Time, err = time.Parse(timeFormat, bucket)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		series = append(series, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return series, nil
+}
+
+// ProcessingLatency reads the latencies and computes the percentiles in Go,
+// as SQLite has no percentile_cont.
+func (s *Storage) ProcessingLatency(ctx context.Context, from, to time.Time) (models.ProcessingLatency, error) {
+	const op = "internal/storage/sqlite.ProcessingLatency"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+		    (julianday(processed_at) - julianday(created_at)) * 86400,
+		    (julianday(processed_at) - julianday(processing_started_at)) * 86400
+		FROM
+		    messages
+		WHERE
+		    status = ? AND processed_at >= ? AND processed_at < ?
+	`, models.StatusCompleted, formatTime(from), formatTime(to))
+	if err != nil {
+		return models.ProcessingLatency{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() {
+		rowsErr := rows.Close()
+		if rowsErr != nil {
+			log.Println(rowsErr)
+		}
+	}()
+
+	var e2e, consumer []float64
+	for rows.Next() {
+		var (
+			total  float64
+			picked sql.NullFloat64
+		)
+		if err := rows.Scan(&total, &picked); err != nil {
+			return models.ProcessingLatency{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		e2e = append(e2e, total)
+		if picked.Valid {
+			consumer = append(consumer, picked.Float64)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return models.ProcessingLatency{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.ProcessingLatency{
+		EndToEnd: models.NewLatency(e2e),
+		Consumer: models.NewLatency(consumer),
+	}, nil
+}
+
+// UpdateMsgStatus moves a message to the given status if the lifecycle
+// allows it and records the change in the status history. It returns
+// storage.ErrInvalidTransition when the current status cannot move to the new
+// one.
+func (s *Storage) UpdateMsgStatus(ctx context.Context, msgID int64, status string, change models.Change) (finalErr error) {
+	const op = "internal/storage/sqlite.UpdateMsgStatus"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	if err := transition(ctx, tx, msgID, status, change, ""); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateMsg(ctx context.Context, msgID int64, msg string, change models.Change) (finalErr error) {
+	const op = "internal/storage/sqlite.UpdateMsg"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, rollbackErr)
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				finalErr = fmt.Errorf("%s: %w", op, commitErr)
+			}
+		}
+	}()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE
+		    messages
+		SET
+		    content = ?,
+		    updated_at = `+now+`
+		WHERE
+		    id = ?
+		RETURNING status
+	`, msg, msgID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrMsgNotFound)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if change.Reason == "" {
+		change.Reason = "content updated"
+	}
+
+	err = insertHistory(ctx, tx, msgID, status, status, change)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveResult writes the outcome of processing a message: its status,
+// content, attempt count and last error, together with the status history
+// entry, in a single transaction.
+func (s *Storage) SaveResult(ctx context.Context, res models.ProcessingResult) error {
+	const op = "internal/storage/sqlite.SaveResult"
+
+	errs, err := s.saveResults(ctx, []models.ProcessingResult{res})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if errs[0] != nil {
+		return fmt.Errorf("%s: %w", op, errs[0])
+	}
+
+	return nil
+}
+
+// SaveResults writes many processing results in a single transaction.
+// Results are applied independently: the returned slice holds, in the order
+// of results, storage.ErrMsgNotFound or storage.ErrInvalidTransition for
+// those that were not applied and nil for the rest.
+func (s *Storage) SaveResults(ctx context.Context, results []models.ProcessingResult) ([]error, error) {
+	const op = "internal/storage/sqlite.SaveResults"
+
+	errs, err := s.saveResults(ctx, results)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return errs, nil
+}
+
+func (s *Storage) saveResults(ctx context.Context, results []models.ProcessingResult) (errs []error, finalErr error) {
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[int64]struct{}, len(results))
+	for _, res := range results {
+		if !models.IsValidStatus(res.Status) {
+			return nil, storage.ErrInvalidStatus
+		}
+		if _, ok := seen[res.MsgID]; ok {
+			return nil, fmt.Errorf("duplicate result for message %d", res.MsgID)
+		}
+		seen[res.MsgID] = struct{}{}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		} else if finalErr != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				finalErr = rollbackErr
+			}
+		} else {
+			commitErr := tx.Commit()
+			if commitErr != nil {
+				errs, finalErr = nil, commitErr
+			}
+		}
+	}()
+
+	errs = make([]error, len(results))
+	for i, res := range results {
+		var content sql.NullString
+		if res.Content != nil {
+			content = sql.NullString{String: *res.Content, Valid: true}
+		}
+
+		err := transition(ctx, tx, res.MsgID, res.Status, res.Change, `
+		    content = COALESCE(?, content),
+		    attempts = ?,
+		    last_error = NULLIF(?, ''),`,
+			content, res.Attempts, res.Error,
+		)
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrConflict) {
+			errs[i] = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return errs, nil
+}
+
+// transition sets the status of a message within tx if the lifecycle allows
+// it and records the change in the status history. Extra SET assignments may
+// use placeholders, bound to args. Transactions hold the write lock, so the
+// status cannot change between the read and the update.
+func transition(
+	ctx context.Context,
+	tx *sql.Tx,
+	msgID int64,
+	status string,
+	change models.Change,
+	set string,
+	args ...any,
+) error {
+	if !models.IsValidStatus(status) {
+		return storage.ErrInvalidStatus
+	}
+
+	var current string
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+		    status
+		FROM
+		    messages
+		WHERE
+		    id = ?
+	`, msgID).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrMsgNotFound
+		}
+
+		return err
+	}
+
+	if !models.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s -> %s", storage.ErrInvalidTransition, current, status)
+	}
+
+	// Stamp when the consumer picks the message up and when it is done
+	// with it.
+	switch {
+	case status == models.StatusProcessing && current != models.StatusProcessing:
+		set += `
+		    processing_started_at = ` + now + `,`
+	case status == models.StatusCompleted || status == models.StatusFailed:
+		set += `
+		    processed_at = ` + now + `,`
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE
+		    messages
+		SET`+set+`
+		    status = ?,
+		    updated_at = `+now+`
+		WHERE
+		    id = ?
+	`, append(args, status, msgID)...)
+	if err != nil {
+		return err
+	}
+
+	return insertHistory(ctx, tx, msgID, current, status, change)
+}
+
+// insertHistory records a status change of a message within tx.
+func insertHistory(ctx context.Context, tx *sql.Tx, msgID int64, oldStatus, newStatus string, change models.Change) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO msg_status_history
+		    (msg_id, old_status, new_status, actor, reason, kafka_partition, kafka_offset)
+		VALUES
+		    (?, NULLIF(?, ''), ?, ?, NULLIF(?, ''), ?, ?)
+	`, msgID, oldStatus, newStatus, change.Actor, change.Reason, change.Partition, change.Offset)
+
+	return err
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,49 @@
+package sqlite
+
+import (
+	"errors"
+	"msgproc/internal/storage"
+	"msgproc/internal/storage/storagetest"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// newTestStorage opens a database of its own in a temporary directory, with
+// all migrations applied.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "msgproc.db")
+
+	m, err := migrate.New("file://../../../migrations/sqlite", "sqlite://"+path+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("failed to create migrate instance: %v", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	srcErr, dbErr := m.Close()
+	if err := errors.Join(srcErr, dbErr); err != nil {
+		t.Fatalf("failed to close migrate instance: %v", err)
+	}
+
+	s, err := NewStorage(path)
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.db.Close()
+	})
+
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return newTestStorage(t)
+	})
+}
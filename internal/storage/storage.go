@@ -1 +1,131 @@
 package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"msgproc/internal/domain/models"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+)
+
+var (
+	ErrMsgNotFound          = fmt.Errorf("message %w", ErrNotFound)
+	ErrInvalidCursor        = errors.New("invalid cursor")
+	ErrIdempotencyKeyReused = fmt.Errorf("%w: idempotency key reused with a different request", ErrConflict)
+	ErrInvalidStatus        = errors.New("invalid message status")
+	ErrInvalidTransition    = fmt.Errorf("%w: invalid message status transition", ErrConflict)
+	ErrInvalidBucket        = errors.New("invalid statistics bucket")
+	ErrWebhookNotFound      = fmt.Errorf("webhook delivery %w", ErrNotFound)
+)
+
+// Storage is the contract every storage backend implements. Backends report
+// failures with the errors above, so callers can tell a missing message or a
+// conflicting write from a broken backend whatever the driver.
+type Storage interface {
+	HealthChecker
+	MsgSaver
+	MsgProvider
+	MsgUpdater
+	Outbox
+	StatProvider
+	EventProvider
+	Webhooks
+}
+
+type HealthChecker interface {
+	Ping(ctx context.Context) error
+	// MigrationVersion returns the applied schema version and whether the
+	// last migration failed half-way.
+	MigrationVersion(ctx context.Context, table string) (uint, bool, error)
+}
+
+// MsgSaver persists new messages together with their outbox entries.
+type MsgSaver interface {
+	SaveMsg(ctx context.Context, msg models.NewMsg) (int64, error)
+	// SaveMsgs returns the IDs in the order of msgs.
+	SaveMsgs(ctx context.Context, msgs []models.NewMsg) ([]int64, error)
+	// SaveMsgIdempotent reports whether the key was already used for the
+	// same request, in which case the original message ID is returned.
+	SaveMsgIdempotent(
+		ctx context.Context,
+		msg models.NewMsg,
+		key string,
+		requestHash string,
+		ttl time.Duration,
+	) (int64, bool, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+type MsgProvider interface {
+	Msg(ctx context.Context, msgID int64) (*models.Message, error)
+	ListMsgs(ctx context.Context, filter models.MsgFilter) (*models.MsgPage, error)
+	MsgHistory(ctx context.Context, msgID int64) ([]models.StatusHistoryEntry, error)
+}
+
+// MsgUpdater changes existing messages. Status changes follow the message
+// lifecycle and are recorded in the status history.
+type MsgUpdater interface {
+	UpdateMsgStatus(ctx context.Context, msgID int64, status string, change models.Change) error
+	UpdateMsg(ctx context.Context, msgID int64, msg string, change models.Change) error
+	SaveResult(ctx context.Context, res models.ProcessingResult) error
+	// SaveResults returns a per-result error, in the order of results, for
+	// results that were not applied.
+	SaveResults(ctx context.Context, results []models.ProcessingResult) ([]error, error)
+}
+
+type Outbox interface {
+	// FetchOutbox leases up to limit unsent entries, oldest first.
+	FetchOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMsg, error)
+	// MarkOutboxSent removes the entries and moves their messages from new
+	// to queued.
+	MarkOutboxSent(ctx context.Context, ids []int64) error
+	FailOutbox(ctx context.Context, id int64, reason string) error
+	OutboxPending(ctx context.Context, msgID int64) (bool, error)
+}
+
+// StatProvider reads the statistics. The overall counts come from rollups
+// that are kept up to date by RefreshStats rather than scanning messages.
+type StatProvider interface {
+	StatsSummary(ctx context.Context) (models.StatsSummary, error)
+	MessagesByStatus(ctx context.Context) (map[string]int64, error)
+	// RefreshStats folds the message changes recorded since the last refresh
+	// into the rollups.
+	RefreshStats(ctx context.Context) error
+	// RebuildStats recomputes the rollups from the messages, for when they
+	// have drifted or were never seeded.
+	RebuildStats(ctx context.Context) error
+	// MessageSeries counts the messages created, completed and failed in
+	// every bucket of the window, oldest first, including empty buckets.
+	MessageSeries(ctx context.Context, filter models.SeriesFilter) ([]models.SeriesPoint, error)
+	// ProcessingLatency summarises the latency of the messages completed in
+	// [from, to).
+	ProcessingLatency(ctx context.Context, from, to time.Time) (models.ProcessingLatency, error)
+}
+
+// EventProvider reads the status history as a stream of events, identified
+// by the ID of their history entry.
+type EventProvider interface {
+	StatusEvents(ctx context.Context, filter models.EventFilter) ([]models.StatusHistoryEntry, error)
+	// LastStatusEventID returns the ID of the latest history entry, or 0.
+	LastStatusEventID(ctx context.Context) (int64, error)
+}
+
+// Webhooks delivers the results of messages to their callback URLs. A
+// delivery is queued when a message with a callback URL completes or fails.
+type Webhooks interface {
+	// FetchWebhooks leases up to limit pending deliveries that are due,
+	// oldest first.
+	FetchWebhooks(ctx context.Context, limit int, lease time.Duration) ([]models.Webhook, error)
+	// RecordWebhookAttempt logs an attempt and moves its delivery to state,
+	// releasing its lease. A pending delivery is attempted again after
+	// retryIn.
+	RecordWebhookAttempt(ctx context.Context, attempt models.WebhookAttempt, state string, retryIn time.Duration) error
+	// WebhookDeliveries returns the deliveries of a message with their
+	// attempts, oldest first.
+	WebhookDeliveries(ctx context.Context, msgID int64) ([]models.WebhookDelivery, error)
+}
@@ -0,0 +1,1078 @@
+// Package storagetest is a conformance suite for storage backends. Every
+// backend must pass it, so the service behaves the same whatever the driver.
+//
+// The suite does not assume an empty store: it filters by unique content and
+// checks counters as deltas, so it can run against a shared test database.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"msgproc/internal/domain/models"
+	"msgproc/internal/storage"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Run runs the suite. newStorage is called once per test.
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"SaveAndGet", testSaveAndGet},
+		{"SaveBatch", testSaveBatch},
+		{"Idempotency", testIdempotency},
+		{"List", testList},
+		{"ListPagination", testListPagination},
+		{"Transitions", testTransitions},
+		{"UpdateContent", testUpdateContent},
+		{"Outbox", testOutbox},
+		{"SaveResults", testSaveResults},
+		{"Stats", testStats},
+		{"Series", testSeries},
+		{"Latency", testLatency},
+		{"Events", testEvents},
+		{"Webhooks", testWebhooks},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+// unique returns a content prefix no other test run uses.
+func unique(t *testing.T) string {
+	return fmt.Sprintf("%s-%d-", t.Name(), time.Now().UnixNano())
+}
+
+func testSaveAndGet(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	content := unique(t) + "héllo"
+
+	id, err := s.SaveMsg(ctx, models.NewMsg{Content: content, RequestID: "req-1"})
+	if err != nil {
+		t.Fatalf("SaveMsg: %v", err)
+	}
+
+	msg, err := s.Msg(ctx, id)
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.ID != id || msg.Content != content || msg.Status != models.StatusNew {
+		t.Errorf("Msg = %+v, want id %d, content %q, status %q", msg, id, content, models.StatusNew)
+	}
+	if msg.CreatedAt.IsZero() || !msg.UpdatedAt.Equal(msg.CreatedAt) {
+		t.Errorf("Msg timestamps = %v, %v, want equal and set", msg.CreatedAt, msg.UpdatedAt)
+	}
+
+	history, err := s.MsgHistory(ctx, id)
+	if err != nil {
+		t.Fatalf("MsgHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].NewStatus != models.StatusNew || history[0].Actor != models.ActorAPI {
+		t.Errorf("MsgHistory = %+v, want a single new entry by %q", history, models.ActorAPI)
+	}
+
+	if _, err := s.Msg(ctx, id+1_000_000); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Msg of a missing message: err = %v, want %v", err, storage.ErrNotFound)
+	}
+	if _, err := s.MsgHistory(ctx, id+1_000_000); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("MsgHistory of a missing message: err = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testSaveBatch(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	prefix := unique(t)
+
+	msgs := make([]models.NewMsg, 5)
+	for i := range msgs {
+		msgs[i] = models.NewMsg{Content: prefix + strconv.Itoa(i)}
+	}
+
+	ids, err := s.SaveMsgs(ctx, msgs)
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+	if len(ids) != len(msgs) {
+		t.Fatalf("SaveMsgs returned %d ids, want %d", len(ids), len(msgs))
+	}
+
+	for i, id := range ids {
+		msg, err := s.Msg(ctx, id)
+		if err != nil {
+			t.Fatalf("Msg(%d): %v", id, err)
+		}
+		if msg.Content != msgs[i].Content {
+			t.Errorf("Msg(%d).Content = %q, want %q", id, msg.Content, msgs[i].Content)
+		}
+	}
+}
+
+func testIdempotency(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	key := unique(t)
+	msg := models.NewMsg{Content: key + "content"}
+
+	id, replayed, err := s.SaveMsgIdempotent(ctx, msg, key, "hash-1", time.Hour)
+	if err != nil || replayed {
+		t.Fatalf("first SaveMsgIdempotent = %d, %v, %v, want a new message", id, replayed, err)
+	}
+
+	again, replayed, err := s.SaveMsgIdempotent(ctx, msg, key, "hash-1", time.Hour)
+	if err != nil || !replayed || again != id {
+		t.Errorf("repeated SaveMsgIdempotent = %d, %v, %v, want %d replayed", again, replayed, err, id)
+	}
+
+	_, _, err = s.SaveMsgIdempotent(ctx, msg, key, "hash-2", time.Hour)
+	if !errors.Is(err, storage.ErrConflict) || !errors.Is(err, storage.ErrIdempotencyKeyReused) {
+		t.Errorf("SaveMsgIdempotent with another request: err = %v, want %v", err, storage.ErrIdempotencyKeyReused)
+	}
+
+	expired := key + "expired"
+	if _, _, err := s.SaveMsgIdempotent(ctx, msg, expired, "hash-1", -time.Second); err != nil {
+		t.Fatalf("SaveMsgIdempotent: %v", err)
+	}
+
+	n, err := s.DeleteExpiredIdempotencyKeys(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpiredIdempotencyKeys: %v", err)
+	}
+	if n < 1 {
+		t.Errorf("DeleteExpiredIdempotencyKeys = %d, want at least 1", n)
+	}
+
+	_, replayed, err = s.SaveMsgIdempotent(ctx, msg, expired, "hash-2", time.Hour)
+	if err != nil || replayed {
+		t.Errorf("SaveMsgIdempotent with an expired key = %v, %v, want a new message", replayed, err)
+	}
+}
+
+func testList(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	prefix := unique(t)
+
+	ids, err := s.SaveMsgs(ctx, []models.NewMsg{
+		{Content: prefix + "a"},
+		{Content: prefix + "b"},
+		{Content: prefix + "c"},
+		{Content: prefix + "%_"},
+	})
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+
+	cancelled := models.Change{Actor: models.ActorAdmin}
+	if err := s.UpdateMsgStatus(ctx, ids[1], models.StatusCancelled, cancelled); err != nil {
+		t.Fatalf("UpdateMsgStatus: %v", err)
+	}
+
+	page, err := s.ListMsgs(ctx, models.MsgFilter{ContentPrefix: prefix, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+	assertIDs(t, page, ids...)
+
+	page, err = s.ListMsgs(ctx, models.MsgFilter{ContentPrefix: prefix, Desc: true, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+	assertIDs(t, page, ids[3], ids[2], ids[1], ids[0])
+
+	page, err = s.ListMsgs(ctx, models.MsgFilter{
+		ContentPrefix: prefix,
+		Statuses:      []string{models.StatusCancelled},
+		Limit:         10,
+	})
+	if err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+	assertIDs(t, page, ids[1])
+
+	// LIKE wildcards in the prefix are matched literally.
+	page, err = s.ListMsgs(ctx, models.MsgFilter{ContentPrefix: prefix + "%", Limit: 10})
+	if err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+	assertIDs(t, page, ids[3])
+
+	future := time.Now().Add(time.Hour)
+	page, err = s.ListMsgs(ctx, models.MsgFilter{ContentPrefix: prefix, CreatedFrom: &future, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+	assertIDs(t, page)
+
+	if _, err := s.ListMsgs(ctx, models.MsgFilter{SortBy: "content", Limit: 10}); err == nil {
+		t.Error("ListMsgs with an unknown sort column succeeded")
+	}
+}
+
+func testListPagination(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	prefix := unique(t)
+
+	msgs := make([]models.NewMsg, 7)
+	for i := range msgs {
+		msgs[i] = models.NewMsg{Content: prefix + strconv.Itoa(i)}
+	}
+
+	ids, err := s.SaveMsgs(ctx, msgs)
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+
+	for _, sortBy := range []string{models.SortByID, models.SortByCreatedAt, models.SortByUpdatedAt} {
+		for _, desc := range []bool{false, true} {
+			filter := models.MsgFilter{ContentPrefix: prefix, SortBy: sortBy, Desc: desc, Limit: 3}
+
+			var got []int64
+			for pages := 0; ; pages++ {
+				if pages > len(ids) {
+					t.Fatalf("ListMsgs(%s, desc %v) does not stop paging", sortBy, desc)
+				}
+
+				page, err := s.ListMsgs(ctx, filter)
+				if err != nil {
+					t.Fatalf("ListMsgs(%s, desc %v): %v", sortBy, desc, err)
+				}
+				for _, m := range page.Msgs {
+					got = append(got, m.ID)
+				}
+
+				if page.NextCursor == "" {
+					break
+				}
+				filter.Cursor = page.NextCursor
+			}
+
+			if len(got) != len(ids) {
+				t.Errorf("ListMsgs(%s, desc %v) returned %v, want %d messages", sortBy, desc, got, len(ids))
+				continue
+			}
+
+			seen := make(map[int64]bool, len(got))
+			for _, id := range got {
+				if seen[id] {
+					t.Errorf("ListMsgs(%s, desc %v) returned %d twice", sortBy, desc, id)
+				}
+				seen[id] = true
+			}
+
+			if sortBy == models.SortByID {
+				for i, id := range got {
+					want := ids[i]
+					if desc {
+						want = ids[len(ids)-1-i]
+					}
+					if id != want {
+						t.Errorf("ListMsgs(id, desc %v) = %v, want ids in order", desc, got)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	_, err = s.ListMsgs(ctx, models.MsgFilter{ContentPrefix: prefix, Limit: 3, Cursor: "not a cursor"})
+	if !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Errorf("ListMsgs with a broken cursor: err = %v, want %v", err, storage.ErrInvalidCursor)
+	}
+
+	page, err := s.ListMsgs(ctx, models.MsgFilter{ContentPrefix: prefix, Limit: 3})
+	if err != nil {
+		t.Fatalf("ListMsgs: %v", err)
+	}
+	_, err = s.ListMsgs(ctx, models.MsgFilter{ContentPrefix: prefix, Limit: 3, Desc: true, Cursor: page.NextCursor})
+	if !errors.Is(err, storage.ErrInvalidCursor) {
+		t.Errorf("ListMsgs with a cursor of another order: err = %v, want %v", err, storage.ErrInvalidCursor)
+	}
+}
+
+func testTransitions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.SaveMsg(ctx, models.NewMsg{Content: unique(t)})
+	if err != nil {
+		t.Fatalf("SaveMsg: %v", err)
+	}
+
+	partition, offset := int32(3), int64(42)
+	steps := []struct {
+		status string
+		change models.Change
+	}{
+		{models.StatusQueued, models.Change{Actor: models.ActorRelay}},
+		{models.StatusProcessing, models.Change{Actor: models.ActorConsumer, Partition: &partition, Offset: &offset}},
+		{models.StatusCompleted, models.Change{Actor: models.ActorConsumer, Reason: "done"}},
+	}
+	for _, step := range steps {
+		if err := s.UpdateMsgStatus(ctx, id, step.status, step.change); err != nil {
+			t.Fatalf("UpdateMsgStatus(%s): %v", step.status, err)
+		}
+	}
+
+	err = s.UpdateMsgStatus(ctx, id, models.StatusProcessing, models.Change{Actor: models.ActorAdmin})
+	if !errors.Is(err, storage.ErrConflict) || !errors.Is(err, storage.ErrInvalidTransition) {
+		t.Errorf("UpdateMsgStatus from completed: err = %v, want %v", err, storage.ErrInvalidTransition)
+	}
+
+	err = s.UpdateMsgStatus(ctx, id, "unknown", models.Change{Actor: models.ActorAdmin})
+	if !errors.Is(err, storage.ErrInvalidStatus) {
+		t.Errorf("UpdateMsgStatus to an unknown status: err = %v, want %v", err, storage.ErrInvalidStatus)
+	}
+
+	err = s.UpdateMsgStatus(ctx, id+1_000_000, models.StatusCancelled, models.Change{Actor: models.ActorAdmin})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateMsgStatus of a missing message: err = %v, want %v", err, storage.ErrNotFound)
+	}
+
+	msg, err := s.Msg(ctx, id)
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.Status != models.StatusCompleted {
+		t.Errorf("Msg.Status = %q, want %q", msg.Status, models.StatusCompleted)
+	}
+
+	history, err := s.MsgHistory(ctx, id)
+	if err != nil {
+		t.Fatalf("MsgHistory: %v", err)
+	}
+	if len(history) != len(steps)+1 {
+		t.Fatalf("MsgHistory has %d entries, want %d", len(history), len(steps)+1)
+	}
+
+	prev := models.StatusNew
+	for i, step := range steps {
+		e := history[i+1]
+		if e.OldStatus != prev || e.NewStatus != step.status || e.Actor != step.change.Actor || e.Reason != step.change.Reason {
+			t.Errorf("history entry %d = %+v, want %s -> %s by %s", i+1, e, prev, step.status, step.change.Actor)
+		}
+		if e.ID <= history[i].ID || e.CreatedAt.Before(history[i].CreatedAt) {
+			t.Errorf("history entry %d is out of order", i+1)
+		}
+		prev = step.status
+	}
+
+	e := history[2]
+	if e.Partition == nil || *e.Partition != partition || e.Offset == nil || *e.Offset != offset {
+		t.Errorf("history entry 2 has partition %v, offset %v, want %d, %d", e.Partition, e.Offset, partition, offset)
+	}
+}
+
+func testUpdateContent(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	content := unique(t)
+
+	id, err := s.SaveMsg(ctx, models.NewMsg{Content: content})
+	if err != nil {
+		t.Fatalf("SaveMsg: %v", err)
+	}
+
+	if err := s.UpdateMsg(ctx, id, content+"updated", models.Change{Actor: models.ActorConsumer}); err != nil {
+		t.Fatalf("UpdateMsg: %v", err)
+	}
+
+	msg, err := s.Msg(ctx, id)
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.Content != content+"updated" || msg.Status != models.StatusNew {
+		t.Errorf("Msg = %+v, want updated content and status %q", msg, models.StatusNew)
+	}
+
+	err = s.UpdateMsg(ctx, id+1_000_000, "x", models.Change{Actor: models.ActorConsumer})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateMsg of a missing message: err = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func testOutbox(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	content := unique(t)
+
+	id, err := s.SaveMsg(ctx, models.NewMsg{Content: content, RequestID: "req-outbox"})
+	if err != nil {
+		t.Fatalf("SaveMsg: %v", err)
+	}
+
+	pending, err := s.OutboxPending(ctx, id)
+	if err != nil || !pending {
+		t.Fatalf("OutboxPending = %v, %v, want true", pending, err)
+	}
+
+	entry := fetchOutboxEntry(t, s, id)
+	if entry == nil {
+		t.Fatal("FetchOutbox did not return the entry of a new message")
+	}
+	if entry.Content != content || entry.RequestID != "req-outbox" || entry.Attempts != 1 {
+		t.Errorf("outbox entry = %+v, want content %q, request ID %q, 1 attempt", entry, content, "req-outbox")
+	}
+
+	if again := fetchOutboxEntry(t, s, id); again != nil {
+		t.Error("FetchOutbox returned an entry that is still leased")
+	}
+
+	if err := s.FailOutbox(ctx, entry.ID, "broker down"); err != nil {
+		t.Fatalf("FailOutbox: %v", err)
+	}
+
+	if err := s.MarkOutboxSent(ctx, []int64{entry.ID}); err != nil {
+		t.Fatalf("MarkOutboxSent: %v", err)
+	}
+
+	pending, err = s.OutboxPending(ctx, id)
+	if err != nil || pending {
+		t.Errorf("OutboxPending after MarkOutboxSent = %v, %v, want false", pending, err)
+	}
+
+	msg, err := s.Msg(ctx, id)
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.Status != models.StatusQueued {
+		t.Errorf("Msg.Status after MarkOutboxSent = %q, want %q", msg.Status, models.StatusQueued)
+	}
+
+	history, err := s.MsgHistory(ctx, id)
+	if err != nil {
+		t.Fatalf("MsgHistory: %v", err)
+	}
+	last := history[len(history)-1]
+	if last.NewStatus != models.StatusQueued || last.Actor != models.ActorRelay {
+		t.Errorf("last history entry = %+v, want queued by %q", last, models.ActorRelay)
+	}
+
+	// A message already moved on by the consumer keeps its status.
+	id, err = s.SaveMsg(ctx, models.NewMsg{Content: content})
+	if err != nil {
+		t.Fatalf("SaveMsg: %v", err)
+	}
+	if err := s.UpdateMsgStatus(ctx, id, models.StatusProcessing, models.Change{Actor: models.ActorConsumer}); err != nil {
+		t.Fatalf("UpdateMsgStatus: %v", err)
+	}
+
+	entry = fetchOutboxEntry(t, s, id)
+	if entry == nil {
+		t.Fatal("FetchOutbox did not return the entry of a new message")
+	}
+	if err := s.MarkOutboxSent(ctx, []int64{entry.ID}); err != nil {
+		t.Fatalf("MarkOutboxSent: %v", err)
+	}
+
+	msg, err = s.Msg(ctx, id)
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.Status != models.StatusProcessing {
+		t.Errorf("Msg.Status after MarkOutboxSent = %q, want %q", msg.Status, models.StatusProcessing)
+	}
+}
+
+// fetchOutboxEntry leases outbox entries and returns the one of the message,
+// if any.
+func fetchOutboxEntry(t *testing.T, s storage.Storage, msgID int64) *models.OutboxMsg {
+	t.Helper()
+
+	entries, err := s.FetchOutbox(context.Background(), 10_000, time.Minute)
+	if err != nil {
+		t.Fatalf("FetchOutbox: %v", err)
+	}
+
+	for i := 1; i < len(entries); i++ {
+		if entries[i].ID <= entries[i-1].ID {
+			t.Errorf("FetchOutbox entries are not ordered by id")
+			break
+		}
+	}
+
+	for _, e := range entries {
+		if e.MsgID == msgID {
+			return &e
+		}
+	}
+
+	return nil
+}
+
+func testSaveResults(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	prefix := unique(t)
+
+	ids, err := s.SaveMsgs(ctx, []models.NewMsg{
+		{Content: prefix + "a"},
+		{Content: prefix + "b"},
+		{Content: prefix + "c"},
+	})
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+	for _, id := range ids {
+		if err := s.UpdateMsgStatus(ctx, id, models.StatusProcessing, models.Change{Actor: models.ActorConsumer}); err != nil {
+			t.Fatalf("UpdateMsgStatus: %v", err)
+		}
+	}
+
+	processed := prefix + "A"
+	err = s.SaveResult(ctx, models.ProcessingResult{
+		MsgID:    ids[0],
+		Status:   models.StatusCompleted,
+		Content:  &processed,
+		Attempts: 1,
+		Change:   models.Change{Actor: models.ActorConsumer},
+	})
+	if err != nil {
+		t.Fatalf("SaveResult: %v", err)
+	}
+
+	errs, err := s.SaveResults(ctx, []models.ProcessingResult{
+		{MsgID: ids[1], Status: models.StatusFailed, Attempts: 3, Error: "boom", Change: models.Change{Actor: models.ActorConsumer}},
+		{MsgID: ids[0], Status: models.StatusFailed, Attempts: 2, Change: models.Change{Actor: models.ActorConsumer}},
+		{MsgID: ids[2] + 1_000_000, Status: models.StatusCompleted, Change: models.Change{Actor: models.ActorConsumer}},
+		{MsgID: ids[2], Status: models.StatusCompleted, Attempts: 1, Change: models.Change{Actor: models.ActorConsumer}},
+	})
+	if err != nil {
+		t.Fatalf("SaveResults: %v", err)
+	}
+	if len(errs) != 4 {
+		t.Fatalf("SaveResults returned %d errors, want 4", len(errs))
+	}
+	if errs[0] != nil || errs[3] != nil {
+		t.Errorf("SaveResults errors = %v, want nil for valid results", errs)
+	}
+	if !errors.Is(errs[1], storage.ErrInvalidTransition) {
+		t.Errorf("SaveResults error for a completed message = %v, want %v", errs[1], storage.ErrInvalidTransition)
+	}
+	if !errors.Is(errs[2], storage.ErrNotFound) {
+		t.Errorf("SaveResults error for a missing message = %v, want %v", errs[2], storage.ErrNotFound)
+	}
+
+	want := []models.Message{
+		{ID: ids[0], Content: processed, Status: models.StatusCompleted, Attempts: 1},
+		{ID: ids[1], Content: prefix + "b", Status: models.StatusFailed, Attempts: 3, LastError: "boom"},
+		{ID: ids[2], Content: prefix + "c", Status: models.StatusCompleted, Attempts: 1},
+	}
+	for _, w := range want {
+		msg, err := s.Msg(ctx, w.ID)
+		if err != nil {
+			t.Fatalf("Msg: %v", err)
+		}
+		if msg.Content != w.Content || msg.Status != w.Status || msg.Attempts != w.Attempts || msg.LastError != w.LastError {
+			t.Errorf("Msg(%d) = %+v, want %+v", w.ID, msg, w)
+		}
+	}
+
+	_, err = s.SaveResults(ctx, []models.ProcessingResult{
+		{MsgID: ids[0], Status: models.StatusCompleted},
+		{MsgID: ids[0], Status: models.StatusCompleted},
+	})
+	if err == nil {
+		t.Error("SaveResults with duplicate messages succeeded")
+	}
+}
+
+func testStats(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	before := stats(t, s)
+
+	ids, err := s.SaveMsgs(ctx, []models.NewMsg{
+		{Content: "ab"},
+		{Content: "абвг"},
+	})
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+	if err := s.UpdateMsgStatus(ctx, ids[0], models.StatusCancelled, models.Change{Actor: models.ActorAdmin}); err != nil {
+		t.Fatalf("UpdateMsgStatus: %v", err)
+	}
+	if err := s.UpdateMsg(ctx, ids[1], "абвгде", models.Change{Actor: models.ActorAdmin}); err != nil {
+		t.Fatalf("UpdateMsg: %v", err)
+	}
+
+	after := stats(t, s)
+
+	if d := after.TotalMessages - before.TotalMessages; d != 2 {
+		t.Errorf("TotalMessages grew by %d, want 2", d)
+	}
+	if d := after.MessagesLastDay - before.MessagesLastDay; d != 2 {
+		t.Errorf("MessagesLastDay grew by %d, want 2", d)
+	}
+	if d := after.MessagesUpdatedLastDay - before.MessagesUpdatedLastDay; d != 2 {
+		t.Errorf("MessagesUpdatedLastDay grew by %d, want 2", d)
+	}
+	if d := after.MessagesByStatus[models.StatusNew] - before.MessagesByStatus[models.StatusNew]; d != 1 {
+		t.Errorf("new messages grew by %d, want 1", d)
+	}
+	if d := after.MessagesByStatus[models.StatusCancelled] - before.MessagesByStatus[models.StatusCancelled]; d != 1 {
+		t.Errorf("cancelled messages grew by %d, want 1", d)
+	}
+
+	// Lengths are counted in characters, not bytes.
+	if before.TotalMessages == 0 && after.AverageMessageLength != 4 {
+		t.Errorf("AverageMessageLength = %v, want 4", after.AverageMessageLength)
+	}
+
+	if after.RefreshedAt.IsZero() {
+		t.Error("RefreshedAt is not set")
+	}
+
+	byStatus, err := s.MessagesByStatus(ctx)
+	if err != nil {
+		t.Fatalf("MessagesByStatus: %v", err)
+	}
+	if !reflect.DeepEqual(byStatus, after.MessagesByStatus) {
+		t.Errorf("MessagesByStatus = %v, want %v", byStatus, after.MessagesByStatus)
+	}
+
+	// Rebuilding from the messages agrees with the incremental rollups.
+	if err := s.RebuildStats(ctx); err != nil {
+		t.Fatalf("RebuildStats: %v", err)
+	}
+
+	rebuilt, err := s.StatsSummary(ctx)
+	if err != nil {
+		t.Fatalf("StatsSummary: %v", err)
+	}
+	rebuilt.RefreshedAt = after.RefreshedAt
+	if !reflect.DeepEqual(rebuilt, after) {
+		t.Errorf("rebuilt statistics = %+v, want %+v", rebuilt, after)
+	}
+}
+
+func testSeries(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	filter := models.SeriesFilter{
+		From:   time.Now().Add(-3 * time.Hour),
+		To:     time.Now().Add(time.Hour),
+		Bucket: models.BucketHour,
+	}
+
+	before := seriesTotals(t, s, filter)
+
+	ids, err := s.SaveMsgs(ctx, []models.NewMsg{
+		{Content: unique(t) + "a"},
+		{Content: unique(t) + "b"},
+		{Content: unique(t) + "c"},
+	})
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+
+	consumer := models.Change{Actor: models.ActorConsumer}
+	for i, status := range []string{models.StatusCompleted, models.StatusFailed} {
+		if err := s.UpdateMsgStatus(ctx, ids[i], models.StatusProcessing, consumer); err != nil {
+			t.Fatalf("UpdateMsgStatus: %v", err)
+		}
+		if err := s.UpdateMsgStatus(ctx, ids[i], status, consumer); err != nil {
+			t.Fatalf("UpdateMsgStatus: %v", err)
+		}
+	}
+
+	after := seriesTotals(t, s, filter)
+
+	if d := after.Created - before.Created; d != 3 {
+		t.Errorf("created grew by %d, want 3", d)
+	}
+	if d := after.Completed - before.Completed; d != 1 {
+		t.Errorf("completed grew by %d, want 1", d)
+	}
+	if d := after.Failed - before.Failed; d != 1 {
+		t.Errorf("failed grew by %d, want 1", d)
+	}
+
+	// A window in the past has all its buckets, with nothing in them.
+	past := models.SeriesFilter{
+		From:   time.Date(2001, 2, 3, 4, 30, 0, 0, time.UTC),
+		To:     time.Date(2001, 2, 3, 5, 30, 0, 0, time.UTC),
+		Bucket: models.BucketMinute,
+	}
+	series, err := s.MessageSeries(ctx, past)
+	if err != nil {
+		t.Fatalf("MessageSeries: %v", err)
+	}
+	if len(series) != 60 {
+		t.Fatalf("MessageSeries returned %d buckets, want 60", len(series))
+	}
+	for i, p := range series {
+		want := past.From.Add(time.Duration(i) * time.Minute)
+		if !p.Time.Equal(want) || p.Created != 0 || p.Completed != 0 || p.Failed != 0 {
+			t.Errorf("bucket %d = %+v, want an empty bucket at %v", i, p, want)
+			break
+		}
+	}
+
+	past.Bucket = models.BucketDay
+	series, err = s.MessageSeries(ctx, past)
+	if err != nil {
+		t.Fatalf("MessageSeries: %v", err)
+	}
+	if len(series) != 1 || !series[0].Time.Equal(time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("MessageSeries by day = %+v, want a single bucket at midnight", series)
+	}
+
+	past.Bucket = "week"
+	if _, err := s.MessageSeries(ctx, past); !errors.Is(err, storage.ErrInvalidBucket) {
+		t.Errorf("MessageSeries with an unknown bucket: err = %v, want %v", err, storage.ErrInvalidBucket)
+	}
+}
+
+func testLatency(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	before, err := s.ProcessingLatency(ctx, from, to)
+	if err != nil {
+		t.Fatalf("ProcessingLatency: %v", err)
+	}
+
+	ids, err := s.SaveMsgs(ctx, []models.NewMsg{
+		{Content: unique(t) + "a"},
+		{Content: unique(t) + "b"},
+		{Content: unique(t) + "c"},
+	})
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+
+	consumer := models.Change{Actor: models.ActorConsumer}
+	for _, id := range ids {
+		if err := s.UpdateMsgStatus(ctx, id, models.StatusProcessing, consumer); err != nil {
+			t.Fatalf("UpdateMsgStatus: %v", err)
+		}
+	}
+
+	msg, err := s.Msg(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.ProcessingStartedAt == nil || msg.ProcessedAt != nil {
+		t.Fatalf("Msg in processing has start %v and finish %v, want only a start", msg.ProcessingStartedAt, msg.ProcessedAt)
+	}
+	started := *msg.ProcessingStartedAt
+
+	// Redelivery keeps the original start.
+	if err := s.UpdateMsgStatus(ctx, ids[0], models.StatusProcessing, consumer); err != nil {
+		t.Fatalf("UpdateMsgStatus: %v", err)
+	}
+	if err := s.UpdateMsgStatus(ctx, ids[0], models.StatusCompleted, consumer); err != nil {
+		t.Fatalf("UpdateMsgStatus: %v", err)
+	}
+	if err := s.SaveResult(ctx, models.ProcessingResult{MsgID: ids[1], Status: models.StatusCompleted, Change: consumer}); err != nil {
+		t.Fatalf("SaveResult: %v", err)
+	}
+	if err := s.SaveResult(ctx, models.ProcessingResult{MsgID: ids[2], Status: models.StatusFailed, Change: consumer}); err != nil {
+		t.Fatalf("SaveResult: %v", err)
+	}
+
+	for _, id := range ids {
+		msg, err := s.Msg(ctx, id)
+		if err != nil {
+			t.Fatalf("Msg: %v", err)
+		}
+		if msg.ProcessingStartedAt == nil || msg.ProcessedAt == nil || msg.ProcessedAt.Before(*msg.ProcessingStartedAt) {
+			t.Errorf("Msg(%d) has start %v and finish %v, want both in order", id, msg.ProcessingStartedAt, msg.ProcessedAt)
+		}
+		if id == ids[0] && !msg.ProcessingStartedAt.Equal(started) {
+			t.Errorf("redelivery moved the start from %v to %v", started, msg.ProcessingStartedAt)
+		}
+	}
+
+	after, err := s.ProcessingLatency(ctx, from, to)
+	if err != nil {
+		t.Fatalf("ProcessingLatency: %v", err)
+	}
+
+	// Only completed messages count.
+	if d := after.EndToEnd.Count - before.EndToEnd.Count; d != 2 {
+		t.Errorf("end-to-end count grew by %d, want 2", d)
+	}
+	if d := after.Consumer.Count - before.Consumer.Count; d != 2 {
+		t.Errorf("consumer count grew by %d, want 2", d)
+	}
+
+	for _, l := range []models.Latency{after.EndToEnd, after.Consumer} {
+		if l.P50 < 0 || l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.Max {
+			t.Errorf("latency %+v is not ordered", l)
+		}
+	}
+
+	long := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	empty, err := s.ProcessingLatency(ctx, long, long.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ProcessingLatency: %v", err)
+	}
+	if empty != (models.ProcessingLatency{}) {
+		t.Errorf("ProcessingLatency of an empty window = %+v, want zeros", empty)
+	}
+}
+
+// seriesTotals sums the buckets of the series, checking that none is missing.
+func seriesTotals(t *testing.T, s storage.Storage, filter models.SeriesFilter) models.SeriesPoint {
+	t.Helper()
+
+	series, err := s.MessageSeries(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("MessageSeries: %v", err)
+	}
+	if len(series) != filter.Buckets() {
+		t.Fatalf("MessageSeries returned %d buckets, want %d", len(series), filter.Buckets())
+	}
+
+	var total models.SeriesPoint
+	for i, p := range series {
+		if i > 0 && p.Time.Sub(series[i-1].Time) != time.Hour {
+			t.Errorf("buckets %d and %d are not an hour apart", i-1, i)
+		}
+		total.Created += p.Created
+		total.Completed += p.Completed
+		total.Failed += p.Failed
+	}
+
+	return total
+}
+
+func testEvents(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	prefix := unique(t)
+
+	last, err := s.LastStatusEventID(ctx)
+	if err != nil {
+		t.Fatalf("LastStatusEventID: %v", err)
+	}
+
+	ids, err := s.SaveMsgs(ctx, []models.NewMsg{
+		{Content: prefix + "a"},
+		{Content: prefix + "b"},
+	})
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+	if err := s.UpdateMsgStatus(ctx, ids[0], models.StatusCancelled, models.Change{Actor: models.ActorAdmin}); err != nil {
+		t.Fatalf("UpdateMsgStatus: %v", err)
+	}
+
+	events, err := s.StatusEvents(ctx, models.EventFilter{AfterID: last, MsgIDs: ids, Limit: 10})
+	if err != nil {
+		t.Fatalf("StatusEvents: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("StatusEvents returned %d events, want 3", len(events))
+	}
+	for i, e := range events {
+		if i > 0 && e.ID <= events[i-1].ID {
+			t.Errorf("event %d is out of order", i)
+		}
+	}
+	cancelled := events[2]
+	if cancelled.MsgID != ids[0] || cancelled.OldStatus != models.StatusNew || cancelled.NewStatus != models.StatusCancelled {
+		t.Errorf("last event = %+v, want %d moving from new to cancelled", cancelled, ids[0])
+	}
+
+	latest, err := s.LastStatusEventID(ctx)
+	if err != nil {
+		t.Fatalf("LastStatusEventID: %v", err)
+	}
+	if latest < cancelled.ID {
+		t.Errorf("LastStatusEventID = %d, want at least %d", latest, cancelled.ID)
+	}
+
+	filters := []struct {
+		name   string
+		filter models.EventFilter
+		want   []models.StatusHistoryEntry
+	}{
+		{"status", models.EventFilter{AfterID: last, MsgIDs: ids, Statuses: []string{models.StatusCancelled}, Limit: 10}, events[2:]},
+		{"message", models.EventFilter{AfterID: last, MsgIDs: ids[1:], Limit: 10}, events[1:2]},
+		{"ids", models.EventFilter{IDs: []int64{events[0].ID, events[2].ID}, Limit: 10}, []models.StatusHistoryEntry{events[0], events[2]}},
+		{"after", models.EventFilter{AfterID: events[0].ID, MsgIDs: ids, Limit: 10}, events[1:]},
+		{"limit", models.EventFilter{AfterID: last, MsgIDs: ids, Limit: 1}, events[:1]},
+	}
+	for _, f := range filters {
+		got, err := s.StatusEvents(ctx, f.filter)
+		if err != nil {
+			t.Fatalf("StatusEvents by %s: %v", f.name, err)
+		}
+		if !reflect.DeepEqual(got, f.want) {
+			t.Errorf("StatusEvents by %s = %+v, want %+v", f.name, got, f.want)
+		}
+	}
+}
+
+func testWebhooks(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	prefix := unique(t)
+	url := "http://example.com/hooks/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	ids, err := s.SaveMsgs(ctx, []models.NewMsg{
+		{Content: prefix + "a", CallbackURL: url},
+		{Content: prefix + "b"},
+	})
+	if err != nil {
+		t.Fatalf("SaveMsgs: %v", err)
+	}
+
+	msg, err := s.Msg(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("Msg: %v", err)
+	}
+	if msg.CallbackURL != url {
+		t.Errorf("Msg.CallbackURL = %q, want %q", msg.CallbackURL, url)
+	}
+
+	processed := prefix + "A"
+	for _, id := range ids {
+		if err := s.UpdateMsgStatus(ctx, id, models.StatusProcessing, models.Change{Actor: models.ActorConsumer}); err != nil {
+			t.Fatalf("UpdateMsgStatus: %v", err)
+		}
+		err := s.SaveResult(ctx, models.ProcessingResult{
+			MsgID:    id,
+			Status:   models.StatusCompleted,
+			Content:  &processed,
+			Attempts: 1,
+			Change:   models.Change{Actor: models.ActorConsumer},
+		})
+		if err != nil {
+			t.Fatalf("SaveResult: %v", err)
+		}
+	}
+
+	webhook := fetchWebhook(t, s, ids[0])
+	if webhook == nil {
+		t.Fatal("FetchWebhooks did not return the delivery of a completed message")
+	}
+	p := webhook.Payload
+	if webhook.URL != url || webhook.Attempts != 0 || p.DeliveryID != webhook.ID || p.MsgID != ids[0] ||
+		p.Status != models.StatusCompleted || p.Content != processed || p.ProcessedAt == nil {
+		t.Errorf("webhook = %+v, want a completed delivery of %q to %q", webhook, processed, url)
+	}
+
+	if again := fetchWebhook(t, s, ids[0]); again != nil {
+		t.Error("FetchWebhooks returned a delivery that is still leased")
+	}
+
+	attempt := models.WebhookAttempt{DeliveryID: webhook.ID, Attempt: 1, StatusCode: 503, Error: "unexpected status 503", DurationMS: 12}
+	if err := s.RecordWebhookAttempt(ctx, attempt, models.WebhookPending, 0); err != nil {
+		t.Fatalf("RecordWebhookAttempt: %v", err)
+	}
+
+	webhook = fetchWebhook(t, s, ids[0])
+	if webhook == nil {
+		t.Fatal("FetchWebhooks did not return a delivery due for a retry")
+	}
+	if webhook.Attempts != 1 {
+		t.Errorf("webhook.Attempts = %d, want 1", webhook.Attempts)
+	}
+
+	attempt = models.WebhookAttempt{DeliveryID: webhook.ID, Attempt: 2, StatusCode: 204, DurationMS: 5}
+	if err := s.RecordWebhookAttempt(ctx, attempt, models.WebhookDelivered, 0); err != nil {
+		t.Fatalf("RecordWebhookAttempt: %v", err)
+	}
+
+	if again := fetchWebhook(t, s, ids[0]); again != nil {
+		t.Error("FetchWebhooks returned a delivered webhook")
+	}
+
+	deliveries, err := s.WebhookDeliveries(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("WebhookDeliveries: %v", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("WebhookDeliveries returned %d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.ID != webhook.ID || d.URL != url || d.State != models.WebhookDelivered || d.Attempts != 2 || d.NextAttemptAt != nil {
+		t.Errorf("delivery = %+v, want delivered after 2 attempts", d)
+	}
+	if len(d.Log) != 2 {
+		t.Fatalf("delivery log has %d attempts, want 2", len(d.Log))
+	}
+	if d.Log[0].Attempt != 1 || d.Log[0].StatusCode != 503 || d.Log[0].Error == "" ||
+		d.Log[1].Attempt != 2 || d.Log[1].StatusCode != 204 || d.Log[1].Error != "" {
+		t.Errorf("delivery log = %+v, want a 503 then a 204", d.Log)
+	}
+
+	deliveries, err = s.WebhookDeliveries(ctx, ids[1])
+	if err != nil {
+		t.Fatalf("WebhookDeliveries: %v", err)
+	}
+	if len(deliveries) != 0 {
+		t.Errorf("WebhookDeliveries of a message without a callback = %+v, want none", deliveries)
+	}
+
+	_, err = s.WebhookDeliveries(ctx, ids[1]+1_000_000)
+	if !errors.Is(err, storage.ErrMsgNotFound) {
+		t.Errorf("WebhookDeliveries of a missing message = %v, want %v", err, storage.ErrMsgNotFound)
+	}
+
+	attempt.DeliveryID = webhook.ID + 1_000_000
+	err = s.RecordWebhookAttempt(ctx, attempt, models.WebhookFailed, 0)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("RecordWebhookAttempt for a missing delivery = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+// fetchWebhook leases due webhook deliveries and returns the one of the
+// message, if any.
+func fetchWebhook(t *testing.T, s storage.Storage, msgID int64) *models.Webhook {
+	t.Helper()
+
+	webhooks, err := s.FetchWebhooks(context.Background(), 10_000, time.Minute)
+	if err != nil {
+		t.Fatalf("FetchWebhooks: %v", err)
+	}
+
+	for _, w := range webhooks {
+		if w.Payload.MsgID == msgID {
+			return &w
+		}
+	}
+
+	return nil
+}
+
+// stats refreshes the rollups and reads them.
+func stats(t *testing.T, s storage.Storage) models.StatsSummary {
+	t.Helper()
+
+	ctx := context.Background()
+
+	if err := s.RefreshStats(ctx); err != nil {
+		t.Fatalf("RefreshStats: %v", err)
+	}
+
+	st, err := s.StatsSummary(ctx)
+	if err != nil {
+		t.Fatalf("StatsSummary: %v", err)
+	}
+
+	return st
+}
+
+func assertIDs(t *testing.T, page *models.MsgPage, want ...int64) {
+	t.Helper()
+
+	got := make([]int64, 0, len(page.Msgs))
+	for _, m := range page.Msgs {
+		got = append(got, m.ID)
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("page ids = %v, want %v", got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("page ids = %v, want %v", got, want)
+			return
+		}
+	}
+}
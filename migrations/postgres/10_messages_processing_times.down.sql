@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS messages_status_processed_at_idx;
+
+ALTER TABLE messages
+      DROP COLUMN IF EXISTS processing_started_at,
+      DROP COLUMN IF EXISTS processed_at;
@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS messages_stats_delete ON messages;
+DROP TRIGGER IF EXISTS messages_stats_update ON messages;
+DROP TRIGGER IF EXISTS messages_stats_insert ON messages;
+DROP FUNCTION IF EXISTS msg_stats_record_change();
+
+DROP TABLE IF EXISTS msg_stats_refresh;
+DROP TABLE IF EXISTS msg_stats_by_minute;
+DROP TABLE IF EXISTS msg_stats_by_status;
+DROP TABLE IF EXISTS msg_stats_changes;
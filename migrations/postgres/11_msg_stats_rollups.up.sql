@@ -0,0 +1,100 @@
+-- Statistics are kept in rollups instead of being computed over messages on
+-- every request. Triggers append the change of every message write to
+-- msg_stats_changes, an append-only log that no two writers contend on, and
+-- the service folds the log into the rollups periodically.
+CREATE TABLE IF NOT EXISTS msg_stats_changes (
+      id BIGSERIAL PRIMARY KEY,
+      status VARCHAR(50) NOT NULL,
+      messages INTEGER NOT NULL,
+      content_length BIGINT NOT NULL,
+      created_bucket TIMESTAMP,
+      created INTEGER NOT NULL,
+      updated_bucket TIMESTAMP,
+      updated INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS msg_stats_by_status (
+      status VARCHAR(50) PRIMARY KEY,
+      messages BIGINT NOT NULL,
+      content_length BIGINT NOT NULL
+);
+
+-- Messages created and last updated per minute, for the last day counts.
+CREATE TABLE IF NOT EXISTS msg_stats_by_minute (
+      bucket TIMESTAMP PRIMARY KEY,
+      created BIGINT NOT NULL,
+      updated BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS msg_stats_refresh (
+      id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+      refreshed_at TIMESTAMP NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION msg_stats_record_change() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO msg_stats_changes
+            (status, messages, content_length, created_bucket, created, updated_bucket, updated)
+        VALUES
+            (NEW.status, 1, LENGTH(NEW.content),
+             date_trunc('minute', NEW.created_at), 1, date_trunc('minute', NEW.updated_at), 1);
+    ELSIF TG_OP = 'UPDATE' THEN
+        INSERT INTO msg_stats_changes
+            (status, messages, content_length, created_bucket, created, updated_bucket, updated)
+        VALUES
+            (OLD.status, -1, -LENGTH(OLD.content), NULL, 0, date_trunc('minute', OLD.updated_at), -1),
+            (NEW.status, 1, LENGTH(NEW.content), NULL, 0, date_trunc('minute', NEW.updated_at), 1);
+    ELSE
+        INSERT INTO msg_stats_changes
+            (status, messages, content_length, created_bucket, created, updated_bucket, updated)
+        VALUES
+            (OLD.status, -1, -LENGTH(OLD.content),
+             date_trunc('minute', OLD.created_at), -1, date_trunc('minute', OLD.updated_at), -1);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS messages_stats_insert ON messages;
+CREATE TRIGGER messages_stats_insert
+      AFTER INSERT ON messages
+      FOR EACH ROW EXECUTE FUNCTION msg_stats_record_change();
+
+DROP TRIGGER IF EXISTS messages_stats_update ON messages;
+CREATE TRIGGER messages_stats_update
+      AFTER UPDATE OF status, content, updated_at ON messages
+      FOR EACH ROW
+      WHEN (OLD.status IS DISTINCT FROM NEW.status
+            OR OLD.content IS DISTINCT FROM NEW.content
+            OR OLD.updated_at IS DISTINCT FROM NEW.updated_at)
+      EXECUTE FUNCTION msg_stats_record_change();
+
+DROP TRIGGER IF EXISTS messages_stats_delete ON messages;
+CREATE TRIGGER messages_stats_delete
+      AFTER DELETE ON messages
+      FOR EACH ROW EXECUTE FUNCTION msg_stats_record_change();
+
+INSERT INTO msg_stats_by_status
+      (status, messages, content_length)
+SELECT
+      status, COUNT(*), COALESCE(SUM(LENGTH(content)), 0)
+FROM
+      messages
+GROUP BY status;
+
+INSERT INTO msg_stats_by_minute
+      (bucket, created, updated)
+SELECT
+      bucket, SUM(created), SUM(updated)
+FROM (
+      SELECT date_trunc('minute', created_at) AS bucket, 1 AS created, 0 AS updated FROM messages
+      UNION ALL
+      SELECT date_trunc('minute', updated_at), 0, 1 FROM messages
+) buckets
+WHERE
+      bucket >= date_trunc('minute', CURRENT_TIMESTAMP - INTERVAL '1 day')
+GROUP BY bucket;
+
+INSERT INTO msg_stats_refresh (refreshed_at) VALUES (CURRENT_TIMESTAMP);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS msg_status_history_notify ON msg_status_history;
+DROP FUNCTION IF EXISTS msg_status_history_notify();
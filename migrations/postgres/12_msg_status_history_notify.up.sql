@@ -0,0 +1,15 @@
+-- Every status change is announced on the msg_status_history channel with the
+-- ID of its history entry, so the event stream of every instance sees it.
+-- Notifications are sent when the inserting transaction commits, so IDs may
+-- arrive out of order.
+CREATE OR REPLACE FUNCTION msg_status_history_notify() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('msg_status_history', NEW.id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS msg_status_history_notify ON msg_status_history;
+CREATE TRIGGER msg_status_history_notify
+      AFTER INSERT ON msg_status_history
+      FOR EACH ROW EXECUTE FUNCTION msg_status_history_notify();
@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS messages_webhook_enqueue ON messages;
+DROP FUNCTION IF EXISTS webhook_deliveries_enqueue();
+
+DROP TABLE IF EXISTS webhook_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+
+ALTER TABLE messages
+      DROP COLUMN IF EXISTS callback_url;
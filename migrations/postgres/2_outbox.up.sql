@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS outbox (
+      id BIGSERIAL PRIMARY KEY,
+      msg_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+      content TEXT NOT NULL,
+      attempts INTEGER NOT NULL DEFAULT 0,
+      last_error TEXT,
+      locked_until TIMESTAMP,
+      created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS outbox_locked_until_idx ON outbox (locked_until);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS messages_updated_at_id_idx;
+DROP INDEX IF EXISTS messages_created_at_id_idx;
+DROP INDEX IF EXISTS messages_status_id_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS messages_status_id_idx ON messages (status, id);
+CREATE INDEX IF NOT EXISTS messages_created_at_id_idx ON messages (created_at, id);
+CREATE INDEX IF NOT EXISTS messages_updated_at_id_idx ON messages (updated_at, id);
@@ -0,0 +1,3 @@
+ALTER TABLE messages
+      DROP COLUMN IF EXISTS last_error,
+      DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,2 @@
+ALTER TABLE messages
+      DROP CONSTRAINT IF EXISTS messages_status_check;
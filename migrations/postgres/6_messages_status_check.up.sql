@@ -0,0 +1,3 @@
+ALTER TABLE messages
+      ADD CONSTRAINT messages_status_check
+      CHECK (status IN ('new', 'queued', 'processing', 'completed', 'failed', 'cancelled'));
@@ -0,0 +1 @@
+DROP TABLE msg_status_history;
\ No newline at end of file
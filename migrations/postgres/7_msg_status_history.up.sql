@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS msg_status_history (
+      id BIGSERIAL PRIMARY KEY,
+      msg_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+      old_status VARCHAR(50),
+      new_status VARCHAR(50) NOT NULL,
+      actor VARCHAR(50) NOT NULL,
+      reason TEXT,
+      kafka_partition INTEGER,
+      kafka_offset BIGINT,
+      created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS msg_status_history_msg_id_idx ON msg_status_history (msg_id, id);
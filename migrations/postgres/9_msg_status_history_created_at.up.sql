@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS msg_status_history_new_status_created_at_idx ON msg_status_history (new_status, created_at);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS msg_status_history;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS outbox;
+DROP TABLE IF EXISTS messages;
@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS messages (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      content TEXT NOT NULL,
+      status VARCHAR(50) NOT NULL DEFAULT 'new'
+            CHECK (status IN ('new', 'queued', 'processing', 'completed', 'failed', 'cancelled')),
+      attempts INTEGER NOT NULL DEFAULT 0,
+      last_error TEXT,
+      created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+      updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS messages_status_id_idx ON messages (status, id);
+CREATE INDEX IF NOT EXISTS messages_created_at_id_idx ON messages (created_at, id);
+CREATE INDEX IF NOT EXISTS messages_updated_at_id_idx ON messages (updated_at, id);
+
+CREATE TABLE IF NOT EXISTS outbox (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      msg_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+      content TEXT NOT NULL,
+      request_id VARCHAR(255),
+      attempts INTEGER NOT NULL DEFAULT 0,
+      last_error TEXT,
+      locked_until TIMESTAMP,
+      created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS outbox_locked_until_idx ON outbox (locked_until);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+      key VARCHAR(255) PRIMARY KEY,
+      request_hash VARCHAR(64) NOT NULL,
+      msg_id INTEGER REFERENCES messages (id) ON DELETE CASCADE,
+      created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+      expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+CREATE TABLE IF NOT EXISTS msg_status_history (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      msg_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+      old_status VARCHAR(50),
+      new_status VARCHAR(50) NOT NULL,
+      actor VARCHAR(50) NOT NULL,
+      reason TEXT,
+      kafka_partition INTEGER,
+      kafka_offset BIGINT,
+      created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS msg_status_history_msg_id_idx ON msg_status_history (msg_id, id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS msg_status_history_new_status_created_at_idx;
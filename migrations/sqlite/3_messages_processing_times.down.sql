@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS messages_status_processed_at_idx;
+
+ALTER TABLE messages DROP COLUMN processed_at;
+ALTER TABLE messages DROP COLUMN processing_started_at;
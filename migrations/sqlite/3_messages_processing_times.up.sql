@@ -0,0 +1,7 @@
+ALTER TABLE messages ADD COLUMN processing_started_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN processed_at TIMESTAMP;
+
+-- The last update of a finished message is the best guess at when it finished.
+UPDATE messages SET processed_at = updated_at WHERE status IN ('completed', 'failed');
+
+CREATE INDEX IF NOT EXISTS messages_status_processed_at_idx ON messages (status, processed_at);
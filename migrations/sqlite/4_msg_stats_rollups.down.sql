@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS messages_stats_delete;
+DROP TRIGGER IF EXISTS messages_stats_update;
+DROP TRIGGER IF EXISTS messages_stats_insert;
+
+DROP TABLE IF EXISTS msg_stats_refresh;
+DROP TABLE IF EXISTS msg_stats_by_minute;
+DROP TABLE IF EXISTS msg_stats_by_status;
+DROP TABLE IF EXISTS msg_stats_changes;
@@ -0,0 +1,89 @@
+-- Statistics are kept in rollups instead of being computed over messages on
+-- every request. Triggers append the change of every message write to
+-- msg_stats_changes and the service folds it into the rollups periodically.
+CREATE TABLE IF NOT EXISTS msg_stats_changes (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      status VARCHAR(50) NOT NULL,
+      messages INTEGER NOT NULL,
+      content_length BIGINT NOT NULL,
+      created_bucket TIMESTAMP,
+      created INTEGER NOT NULL,
+      updated_bucket TIMESTAMP,
+      updated INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS msg_stats_by_status (
+      status VARCHAR(50) PRIMARY KEY,
+      messages BIGINT NOT NULL,
+      content_length BIGINT NOT NULL
+);
+
+-- Messages created and last updated per minute, for the last day counts.
+CREATE TABLE IF NOT EXISTS msg_stats_by_minute (
+      bucket TIMESTAMP PRIMARY KEY,
+      created BIGINT NOT NULL,
+      updated BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS msg_stats_refresh (
+      id INTEGER PRIMARY KEY CHECK (id = 1),
+      refreshed_at TIMESTAMP NOT NULL
+);
+
+CREATE TRIGGER IF NOT EXISTS messages_stats_insert
+      AFTER INSERT ON messages
+BEGIN
+      INSERT INTO msg_stats_changes
+            (status, messages, content_length, created_bucket, created, updated_bucket, updated)
+      VALUES
+            (NEW.status, 1, LENGTH(NEW.content),
+             strftime('%Y-%m-%d %H:%M:00.000', NEW.created_at), 1,
+             strftime('%Y-%m-%d %H:%M:00.000', NEW.updated_at), 1);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_stats_update
+      AFTER UPDATE OF status, content, updated_at ON messages
+      WHEN OLD.status IS NOT NEW.status
+            OR OLD.content IS NOT NEW.content
+            OR OLD.updated_at IS NOT NEW.updated_at
+BEGIN
+      INSERT INTO msg_stats_changes
+            (status, messages, content_length, created_bucket, created, updated_bucket, updated)
+      VALUES
+            (OLD.status, -1, -LENGTH(OLD.content), NULL, 0, strftime('%Y-%m-%d %H:%M:00.000', OLD.updated_at), -1),
+            (NEW.status, 1, LENGTH(NEW.content), NULL, 0, strftime('%Y-%m-%d %H:%M:00.000', NEW.updated_at), 1);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_stats_delete
+      AFTER DELETE ON messages
+BEGIN
+      INSERT INTO msg_stats_changes
+            (status, messages, content_length, created_bucket, created, updated_bucket, updated)
+      VALUES
+            (OLD.status, -1, -LENGTH(OLD.content),
+             strftime('%Y-%m-%d %H:%M:00.000', OLD.created_at), -1,
+             strftime('%Y-%m-%d %H:%M:00.000', OLD.updated_at), -1);
+END;
+
+INSERT INTO msg_stats_by_status
+      (status, messages, content_length)
+SELECT
+      status, COUNT(*), COALESCE(SUM(LENGTH(content)), 0)
+FROM
+      messages
+GROUP BY status;
+
+INSERT INTO msg_stats_by_minute
+      (bucket, created, updated)
+SELECT
+      bucket, SUM(created), SUM(updated)
+FROM (
+      SELECT strftime('%Y-%m-%d %H:%M:00.000', created_at) AS bucket, 1 AS created, 0 AS updated FROM messages
+      UNION ALL
+      SELECT strftime('%Y-%m-%d %H:%M:00.000', updated_at), 0, 1 FROM messages
+)
+WHERE
+      bucket >= strftime('%Y-%m-%d %H:%M:00.000', 'now', '-1 day')
+GROUP BY bucket;
+
+INSERT INTO msg_stats_refresh (id, refreshed_at) VALUES (1, strftime('%Y-%m-%d %H:%M:%f', 'now'));
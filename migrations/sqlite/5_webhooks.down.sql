@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS messages_webhook_enqueue;
+
+DROP TABLE IF EXISTS webhook_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+
+ALTER TABLE messages DROP COLUMN callback_url;
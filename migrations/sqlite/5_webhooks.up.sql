@@ -0,0 +1,48 @@
+ALTER TABLE messages ADD COLUMN callback_url TEXT;
+
+-- A delivery is queued when a message with a callback URL completes or
+-- fails, with the message as it was then.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      msg_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+      url TEXT NOT NULL,
+      status VARCHAR(50) NOT NULL,
+      content TEXT NOT NULL,
+      last_error TEXT,
+      processed_at TIMESTAMP,
+      state VARCHAR(20) NOT NULL DEFAULT 'pending'
+            CHECK (state IN ('pending', 'delivered', 'failed')),
+      attempts INTEGER NOT NULL DEFAULT 0,
+      next_attempt_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+      locked_until TIMESTAMP,
+      created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+      updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx
+      ON webhook_deliveries (next_attempt_at) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_msg_id_idx ON webhook_deliveries (msg_id, id);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+      attempt INTEGER NOT NULL,
+      status_code INTEGER,
+      error TEXT,
+      duration_ms BIGINT NOT NULL,
+      created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id, id);
+
+CREATE TRIGGER IF NOT EXISTS messages_webhook_enqueue
+      AFTER UPDATE OF status ON messages
+      WHEN NEW.callback_url IS NOT NULL
+            AND NEW.status IN ('completed', 'failed')
+            AND OLD.status IS NOT NEW.status
+BEGIN
+      INSERT INTO webhook_deliveries
+            (msg_id, url, status, content, last_error, processed_at)
+      VALUES
+            (NEW.id, NEW.callback_url, NEW.status, NEW.content, NEW.last_error, NEW.processed_at);
+END;